@@ -0,0 +1,203 @@
+package accesslog
+
+import (
+	"log"
+	"math/rand"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"vps-screener/agent/config"
+	"vps-screener/agent/logwatch"
+)
+
+const (
+	stateFileName = "accesslog.json"
+	maxSamples    = 10000 // Latencies kept per project and tick for percentiles (reservoir sampled)
+)
+
+// latencyBuckets are the histogram upper bounds in seconds.
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Report holds a project's request metrics for one tick.
+type Report struct {
+	Requests          int64            `json:"requests"`
+	RequestsPerSecond float64          `json:"requests_per_second"`
+	Status            map[string]int64 `json:"status"` // "1xx" ... "5xx"
+	LatencyP50        float64          `json:"latency_p50_seconds,omitempty"`
+	LatencyP95        float64          `json:"latency_p95_seconds,omitempty"`
+	LatencyP99        float64          `json:"latency_p99_seconds,omitempty"`
+	// LatencyBuckets counts requests per histogram bucket, keyed by the bucket's
+	// upper bound in seconds ("+Inf" for the rest). Counts are not cumulative.
+	LatencyBuckets map[string]int64 `json:"latency_buckets,omitempty"`
+}
+
+// aggregate accumulates one project's requests during a tick.
+type aggregate struct {
+	report    Report
+	latencies []float64
+	seen      int64 // Requests with a latency, for reservoir sampling
+}
+
+func newAggregate() *aggregate {
+	return &aggregate{report: Report{Status: map[string]int64{"1xx": 0, "2xx": 0, "3xx": 0, "4xx": 0, "5xx": 0}}}
+}
+
+func (a *aggregate) add(req request) {
+	a.report.Requests++
+	if req.status >= 100 && req.status < 600 {
+		a.report.Status[strconv.Itoa(req.status/100)+"xx"]++
+	}
+	if req.latency < 0 {
+		return
+	}
+
+	if a.report.LatencyBuckets == nil {
+		a.report.LatencyBuckets = make(map[string]int64, len(latencyBuckets)+1)
+	}
+	a.report.LatencyBuckets[bucketFor(req.latency)]++
+
+	a.seen++
+	if len(a.latencies) < maxSamples {
+		a.latencies = append(a.latencies, req.latency)
+	} else if i := rand.Int63n(a.seen); i < maxSamples {
+		a.latencies[i] = req.latency
+	}
+}
+
+func bucketFor(latency float64) string {
+	for _, bound := range latencyBuckets {
+		if latency <= bound {
+			return strconv.FormatFloat(bound, 'f', -1, 64)
+		}
+	}
+	return "+Inf"
+}
+
+// finish computes the rate and percentiles.
+func (a *aggregate) finish(elapsed time.Duration) Report {
+	if elapsed > 0 {
+		a.report.RequestsPerSecond = float64(a.report.Requests) / elapsed.Seconds()
+	}
+	if len(a.latencies) > 0 {
+		sort.Float64s(a.latencies)
+		a.report.LatencyP50 = percentile(a.latencies, 0.50)
+		a.report.LatencyP95 = percentile(a.latencies, 0.95)
+		a.report.LatencyP99 = percentile(a.latencies, 0.99)
+	}
+	return a.report
+}
+
+// percentile returns the nearest-rank percentile of sorted values, or 0 if
+// there are none.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := int(p*float64(len(sorted))+0.999999) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	if rank >= len(sorted) {
+		rank = len(sorted) - 1
+	}
+	return sorted[rank]
+}
+
+// route returns the project a request belongs to, or "" if none.
+func route(al config.AccessLog, req request) string {
+	for _, r := range al.Routes {
+		if r.Host != "" && !globMatch(r.Host, req.host) {
+			continue
+		}
+		if r.Upstream != "" && !globMatch(r.Upstream, req.upstream) {
+			continue
+		}
+		return r.Project
+	}
+	return al.Project
+}
+
+func globMatch(pattern, value string) bool {
+	if value == "" {
+		return false
+	}
+	ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(value))
+	return err == nil && ok
+}
+
+var (
+	follower      *logwatch.Follower
+	followerState string
+	lastCollect   time.Time
+	mutex         = &sync.Mutex{}
+)
+
+// Collect reads new lines of all configured access logs and returns request
+// metrics per project. Projects that are routed to but got no requests are
+// reported with zero counts.
+func Collect(cfg *config.Config) map[string]Report {
+	if len(cfg.AccessLogs) == 0 {
+		return nil
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	statePath := filepath.Join(cfg.AgentSettings.StateDir, stateFileName)
+	if follower == nil || followerState != statePath {
+		follower, followerState = logwatch.NewFollower(statePath), statePath
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(lastCollect)
+	if lastCollect.IsZero() {
+		elapsed = time.Duration(cfg.AgentSettings.CollectionInterval) * time.Second
+	}
+	lastCollect = now
+
+	aggregates := make(map[string]*aggregate)
+	get := func(project string) *aggregate {
+		a, ok := aggregates[project]
+		if !ok {
+			a = newAggregate()
+			aggregates[project] = a
+		}
+		return a
+	}
+
+	var parseErrors int
+	for _, al := range cfg.AccessLogs {
+		al := al
+		if al.Project != "" {
+			get(al.Project)
+		}
+		for _, r := range al.Routes {
+			get(r.Project)
+		}
+		owner := "access_log:" + strings.Join(al.Files, ",")
+		follower.Poll(owner, al.Files, func(_, line string, _ logwatch.Position) {
+			req, err := parseLine(al, line)
+			if err != nil {
+				parseErrors++
+				return
+			}
+			if project := route(al, req); project != "" {
+				get(project).add(req)
+			}
+		})
+	}
+	follower.Commit()
+	if parseErrors > 0 {
+		log.Printf("accesslog: skipped %d lines that did not match the configured format", parseErrors)
+	}
+
+	reports := make(map[string]Report, len(aggregates))
+	for project, a := range aggregates {
+		reports[project] = a.finish(elapsed)
+	}
+	return reports
+}
@@ -0,0 +1,176 @@
+package accesslog
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"vps-screener/agent/config"
+)
+
+func TestRoute(t *testing.T) {
+	al := config.AccessLog{
+		Project: "default",
+		Routes: []config.AccessLogRoute{
+			{Host: "shop.example.com", Upstream: "10.0.0.9:*", Project: "shop-canary"},
+			{Host: "*.shop.example.com", Project: "shop"},
+			{Host: "shop.example.com", Project: "shop"},
+			{Upstream: "unix:/run/php/*", Project: "php"},
+		},
+	}
+	tests := []struct {
+		host, upstream string
+		want           string
+	}{
+		{"shop.example.com", "10.0.0.9:8080", "shop-canary"},
+		{"shop.example.com", "10.0.0.1:8080", "shop"},
+		{"SHOP.Example.com", "", "shop"},
+		{"api.shop.example.com", "", "shop"},
+		{"blog.example.com", "unix:/run/php/fpm.sock", "php"},
+		{"blog.example.com", "", "default"},
+		{"", "", "default"},
+	}
+	for _, tt := range tests {
+		if got := route(al, request{host: tt.host, upstream: tt.upstream}); got != tt.want {
+			t.Errorf("route(%q, %q) = %q, want %q", tt.host, tt.upstream, got, tt.want)
+		}
+	}
+	al.Project = ""
+	if got := route(al, request{host: "blog.example.com"}); got != "" {
+		t.Errorf("unrouted request without a default project went to %q", got)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		p      float64
+		want   float64
+	}{
+		{"no samples", nil, 0.5, 0},
+		{"one sample p50", []float64{0.3}, 0.5, 0.3},
+		{"one sample p99", []float64{0.3}, 0.99, 0.3},
+		{"p50 of 4", []float64{1, 2, 3, 4}, 0.5, 2},
+		{"p95 of 4", []float64{1, 2, 3, 4}, 0.95, 4},
+		{"p50 of 5", []float64{1, 2, 3, 4, 5}, 0.5, 3},
+		{"p95 of 100", seq(100), 0.95, 95},
+		{"p99 of 100", seq(100), 0.99, 99},
+		{"p99 of 1000", seq(1000), 0.99, 990},
+	}
+	for _, tt := range tests {
+		if got := percentile(tt.values, tt.p); got != tt.want {
+			t.Errorf("%s: percentile = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// seq returns 1, 2, ..., n.
+func seq(n int) []float64 {
+	values := make([]float64, n)
+	for i := range values {
+		values[i] = float64(i + 1)
+	}
+	return values
+}
+
+func TestAggregate(t *testing.T) {
+	// No requests at all, and requests without a latency.
+	empty := newAggregate().finish(10 * time.Second)
+	if empty.Requests != 0 || empty.LatencyP50 != 0 || empty.LatencyBuckets != nil {
+		t.Errorf("empty = %+v", empty)
+	}
+	a := newAggregate()
+	a.add(request{status: 200, latency: -1})
+	a.add(request{status: 999, latency: -1})
+	if r := a.finish(time.Second); r.Requests != 2 || r.Status["2xx"] != 1 || r.LatencyP99 != 0 || r.LatencyBuckets != nil {
+		t.Errorf("without latencies = %+v", r)
+	}
+
+	// One request.
+	a = newAggregate()
+	a.add(request{status: 503, latency: 0.2})
+	r := a.finish(2 * time.Second)
+	if r.RequestsPerSecond != 0.5 || r.Status["5xx"] != 1 || r.LatencyP50 != 0.2 || r.LatencyP99 != 0.2 {
+		t.Errorf("one request = %+v", r)
+	}
+	if want := map[string]int64{"0.25": 1}; !reflect.DeepEqual(r.LatencyBuckets, want) {
+		t.Errorf("buckets = %v, want %v", r.LatencyBuckets, want)
+	}
+
+	// More requests than the reservoir holds: uniform latencies 0.0001 .. 3 s.
+	const n = 3 * maxSamples
+	a = newAggregate()
+	for i := 1; i <= n; i++ {
+		a.add(request{status: 200, latency: float64(i) / maxSamples})
+	}
+	r = a.finish(time.Second)
+	if len(a.latencies) != maxSamples || a.seen != n || r.Requests != n {
+		t.Fatalf("kept %d of %d latencies", len(a.latencies), a.seen)
+	}
+	for _, c := range []struct {
+		name      string
+		got, want float64
+	}{{"p50", r.LatencyP50, 1.5}, {"p95", r.LatencyP95, 2.85}, {"p99", r.LatencyP99, 2.97}} {
+		if c.got < c.want-0.1 || c.got > c.want+0.1 {
+			t.Errorf("%s = %v, want about %v", c.name, c.got, c.want)
+		}
+	}
+	// Buckets count every request, not just the sampled ones.
+	var total int64
+	for _, count := range r.LatencyBuckets {
+		total += count
+	}
+	if total != n || r.LatencyBuckets["+Inf"] != 0 || r.LatencyBuckets["2.5"] != 15000 || r.LatencyBuckets["5"] != 5000 {
+		t.Errorf("buckets = %v", r.LatencyBuckets)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "access.log")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{
+		AgentSettings: config.AgentSettings{StateDir: filepath.Join(dir, "state"), CollectionInterval: 10},
+		AccessLogs: []config.AccessLog{{
+			Files:       []string{path},
+			ExtraFields: []string{"request_time", "host"},
+			Project:     "default",
+			Routes:      []config.AccessLogRoute{{Host: "shop.example.com", Project: "shop"}, {Host: "idle.example.com", Project: "idle"}},
+		}},
+	}
+	Collect(cfg)
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`1.2.3.4 - - [18/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" 200 1 "-" "ua" 0.010 shop.example.com`,
+		`1.2.3.4 - - [18/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" 500 1 "-" "ua" 0.300 shop.example.com`,
+		`1.2.3.4 - - [18/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" 301 1 "-" "ua" 0.001 www.example.com`,
+		`garbage`,
+	} {
+		f.WriteString(line + "\n")
+	}
+	f.Close()
+
+	reports := Collect(cfg)
+	if shop := reports["shop"]; shop.Requests != 2 || shop.Status["2xx"] != 1 || shop.Status["5xx"] != 1 || shop.LatencyP50 != 0.01 || shop.LatencyP99 != 0.3 {
+		t.Errorf("shop = %+v", shop)
+	}
+	if def := reports["default"]; def.Requests != 1 || def.Status["3xx"] != 1 {
+		t.Errorf("default = %+v", def)
+	}
+	if idle, ok := reports["idle"]; !ok || idle.Requests != 0 {
+		t.Errorf("idle = %+v, %v, want a zero report", idle, ok)
+	}
+}
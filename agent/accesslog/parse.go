@@ -0,0 +1,149 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"vps-screener/agent/config"
+)
+
+// request is the part of an access log line the agent aggregates.
+type request struct {
+	status   int
+	latency  float64 // Seconds, or -1 if the line has no request time
+	host     string
+	upstream string
+}
+
+// combinedPattern matches the NCSA combined format shared by nginx and Apache:
+// $remote_addr - $remote_user [$time_local] "$request" $status $body_bytes_sent "$http_referer" "$http_user_agent"
+// followed by optional extra fields.
+var combinedPattern = regexp.MustCompile(`^\S+ \S+ \S+ \[[^\]]*\] "(?:[^"\\]|\\.)*" (\d{3}) \S+(?: "(?:[^"\\]|\\.)*" "(?:[^"\\]|\\.)*")?(.*)$`)
+
+// defaultJSONFields are the keys of a typical nginx `escape=json` log_format.
+var defaultJSONFields = map[string]string{
+	config.AccessFieldStatus:      "status",
+	config.AccessFieldRequestTime: "request_time",
+	config.AccessFieldHost:        "host",
+	config.AccessFieldUpstream:    "upstream_addr",
+}
+
+func parseLine(al config.AccessLog, line string) (request, error) {
+	if al.Format == "json" {
+		return parseJSON(al.JSONFields, line)
+	}
+	return parseCombined(al.ExtraFields, line)
+}
+
+func parseCombined(extraFields []string, line string) (request, error) {
+	m := combinedPattern.FindStringSubmatch(line)
+	if m == nil {
+		return request{}, fmt.Errorf("not a combined log line")
+	}
+	req := request{latency: -1}
+	req.status, _ = strconv.Atoi(m[1])
+
+	extras := splitFields(m[2])
+	for i, name := range extraFields {
+		if i >= len(extras) {
+			break
+		}
+		setField(&req, name, extras[i])
+	}
+	return req, nil
+}
+
+// splitFields splits space separated fields, honouring double quotes.
+func splitFields(s string) []string {
+	var fields []string
+	s = strings.TrimSpace(s)
+	for s != "" {
+		if s[0] == '"' {
+			end := 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end > len(s) {
+				end = len(s)
+			}
+			fields = append(fields, s[1:end])
+			if end < len(s) {
+				end++
+			}
+			s = strings.TrimSpace(s[end:])
+			continue
+		}
+		end := strings.IndexByte(s, ' ')
+		if end < 0 {
+			end = len(s)
+		}
+		fields = append(fields, s[:end])
+		s = strings.TrimSpace(s[end:])
+	}
+	return fields
+}
+
+func parseJSON(fields map[string]string, line string) (request, error) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(line), &obj); err != nil {
+		return request{}, fmt.Errorf("not a JSON log line: %w", err)
+	}
+	req := request{latency: -1}
+	for name, defaultKey := range defaultJSONFields {
+		key := defaultKey
+		if k, ok := fields[name]; ok {
+			key = k
+		}
+		if v, ok := obj[key]; ok {
+			setField(&req, name, fmt.Sprint(v))
+		}
+	}
+	if us, ok := fields[config.AccessFieldRequestTimeUs]; ok {
+		if v, ok := obj[us]; ok {
+			setField(&req, config.AccessFieldRequestTimeUs, fmt.Sprint(v))
+		}
+	}
+	if req.status == 0 {
+		return request{}, fmt.Errorf("JSON log line has no status")
+	}
+	return req, nil
+}
+
+func setField(req *request, name, value string) {
+	if value == "-" || value == "" {
+		return
+	}
+	switch name {
+	case config.AccessFieldStatus:
+		req.status, _ = strconv.Atoi(value)
+	case config.AccessFieldRequestTime:
+		if v, err := strconv.ParseFloat(lastValue(value), 64); err == nil {
+			req.latency = v
+		}
+	case config.AccessFieldRequestTimeUs:
+		if v, err := strconv.ParseFloat(lastValue(value), 64); err == nil {
+			req.latency = v / 1e6
+		}
+	case config.AccessFieldHost:
+		req.host = strings.ToLower(value)
+	case config.AccessFieldUpstream:
+		req.upstream = lastValue(value)
+	}
+}
+
+// lastValue returns the last of several values nginx logs for retried
+// upstreams, separated by ", " (attempts) or " : " (internal redirects).
+func lastValue(value string) string {
+	for _, sep := range []string{", ", " : "} {
+		if i := strings.LastIndex(value, sep); i >= 0 {
+			value = value[i+len(sep):]
+		}
+	}
+	return strings.TrimSpace(value)
+}
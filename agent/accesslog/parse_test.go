@@ -0,0 +1,64 @@
+package accesslog
+
+import (
+	"testing"
+
+	"vps-screener/agent/config"
+)
+
+func TestParseCombined(t *testing.T) {
+	const prefix = `203.0.113.7 - alice [18/Oct/2026:10:00:00 +0000] "GET /api?q=\"x\" HTTP/1.1" `
+	tests := []struct {
+		name   string
+		fields []string
+		line   string
+		want   request
+		err    bool
+	}{
+		{"common format", nil, `203.0.113.7 - - [18/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" 200 512`, request{status: 200, latency: -1}, false},
+		{"combined", nil, prefix + `404 0 "-" "curl/8.0"`, request{status: 404, latency: -1}, false},
+		{"nginx extras", []string{"request_time", "host", "upstream"}, prefix + `502 0 "-" "curl/8.0" 0.250 Shop.Example.com "10.0.0.1:8080, 10.0.0.2:8080"`,
+			request{status: 502, latency: 0.25, host: "shop.example.com", upstream: "10.0.0.2:8080"}, false},
+		{"apache %D", []string{"request_time_us"}, prefix + `200 12 "-" "ua" 1500`, request{status: 200, latency: 0.0015}, false},
+		{"skipped field", []string{"-", "host"}, prefix + `200 12 "-" "ua" "quoted extra" api.example.com`, request{status: 200, latency: -1, host: "api.example.com"}, false},
+		{"missing extras", []string{"request_time", "host"}, prefix + `200 12 "-" "ua" 0.100`, request{status: 200, latency: 0.1}, false},
+		{"dash values", []string{"request_time", "upstream"}, prefix + `200 12 "-" "ua" - -`, request{status: 200, latency: -1}, false},
+		{"internal redirect", []string{"upstream"}, prefix + `200 12 "-" "ua" "10.0.0.1:80 : 10.0.0.3:80"`, request{status: 200, latency: -1, upstream: "10.0.0.3:80"}, false},
+		{"garbage", nil, `not an access log line`, request{}, true},
+		{"no status", nil, `203.0.113.7 - - [18/Oct/2026:10:00:00 +0000] "GET / HTTP/1.1" - 512`, request{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(config.AccessLog{ExtraFields: tt.fields}, tt.line)
+			if (err != nil) != tt.err || got != tt.want {
+				t.Errorf("parseLine = %+v, %v, want %+v, error %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestParseJSON(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[string]string
+		line   string
+		want   request
+		err    bool
+	}{
+		{"nginx defaults", nil, `{"status":"503","request_time":"1.5","host":"API.example.com","upstream_addr":"10.0.0.1:80, 10.0.0.2:80"}`,
+			request{status: 503, latency: 1.5, host: "api.example.com", upstream: "10.0.0.2:80"}, false},
+		{"numbers", nil, `{"status":200,"request_time":0.02}`, request{status: 200, latency: 0.02}, false},
+		{"custom keys", map[string]string{"status": "code", "host": "vhost"}, `{"code":201,"vhost":"shop","status":500}`, request{status: 201, latency: -1, host: "shop"}, false},
+		{"microseconds", map[string]string{"request_time_us": "duration"}, `{"status":200,"duration":2500}`, request{status: 200, latency: 0.0025}, false},
+		{"no status", nil, `{"request_time":"0.1"}`, request{}, true},
+		{"not JSON", nil, `127.0.0.1 - - [...] "GET /" 200 1`, request{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseLine(config.AccessLog{Format: "json", JSONFields: tt.fields}, tt.line)
+			if (err != nil) != tt.err || got != tt.want {
+				t.Errorf("parseLine = %+v, %v, want %+v, error %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
@@ -12,7 +12,11 @@ import (
 
 	"github.com/elastic/go-sysinfo"
 
+	"vps-screener/agent/accesslog"
 	"vps-screener/agent/config"
+	"vps-screener/agent/filecheck"
+	"vps-screener/agent/journal"
+	"vps-screener/agent/logwatch"
 	"vps-screener/agent/mapper" // Import the mapper package
 )
 
@@ -24,13 +28,38 @@ type MetricData struct {
 	RAMPercent    float32            `json:"ram_percent,omitempty"` // for _system
 	DiskPercent   float64            `json:"disk_percent,omitempty"`// for _system
 	ProcessCount  int                `json:"process_count,omitempty"`
+	InheritedProcessCount int        `json:"inherited_process_count,omitempty"` // Part of ProcessCount mapped via a parent process (process_inheritance)
+	Discovered    bool               `json:"discovered,omitempty"` // Project created by a discovery rule, not listed in 'projects'
 	CustomMetrics map[string]interface{} `json:"custom,omitempty"`
+	FileChecks    []filecheck.Result     `json:"file_checks,omitempty"`
+	Logs          *logwatch.Report       `json:"logs,omitempty"`
+	Journal       *journal.Report        `json:"journal,omitempty"`
+	Requests      *accesslog.Report      `json:"requests,omitempty"` // From access_logs routed to the project
+	Agent         *AgentMetrics          `json:"agent,omitempty"`    // for _agent
+	Groups        map[string]MetricData  `json:"groups,omitempty"`   // for _groups: CPU, RAM and process counts per group
+}
+
+// AgentMetrics are the agent's own metrics, reported under the "_agent" key.
+type AgentMetrics struct {
+	DockerCache mapper.DockerCacheStats `json:"docker_cache"`
 }
 
 // CollectedMetrics is a map of project name to its MetricData.
-// The key can be a project name or "_system" for overall system metrics.
+// The key can be a project name, "_system" for overall system metrics,
+// "_groups" for the group rollups or "_agent" for the agent's own metrics.
 type CollectedMetrics map[string]MetricData
 
+// ProjectCount returns the number of projects, i.e. keys other than _system, _groups and _agent.
+func (m CollectedMetrics) ProjectCount() int {
+	n := 0
+	for key := range m {
+		if key != "_system" && key != "_groups" && key != "_agent" {
+			n++
+		}
+	}
+	return n
+}
+
 // executePlugin runs a plugin executable and returns its JSON output.
 // It sets a timeout and passes the project name as an environment variable.
 func executePlugin(pluginPath string, projectName string) (map[string]interface{}, error) {
@@ -109,9 +138,37 @@ func CollectMetrics(cfg *config.Config) CollectedMetrics {
 	// Track which projects we've processed plugins for
 	processedPlugins := make(map[string]bool)
 
+	groupMetrics := make(map[string]MetricData)
+	mappings := mapper.MapProcesses(processes, cfg)
 	for _, p := range processes {
-		projectName := mapper.MapPIDToProject(p, cfg.Projects)
-		if projectName == "" { // MODIFIED: Skip processes not mapped to any project
+		mapping, ok := mappings[p.PID()]
+		if !ok { // MODIFIED: Skip processes not mapped to any project
+			continue
+		}
+		projectName := mapping.Project
+
+		// A process counts toward every group it belongs to, next to its project.
+		if len(mapping.Groups) > 0 {
+			var cpuSeconds float64
+			var rssBytes uint64
+			if procCPUTimes, err := p.CPUTime(); err == nil {
+				cpuSeconds = procCPUTimes.Total().Seconds()
+			}
+			if procMemInfo, err := p.Memory(); err == nil {
+				rssBytes = procMemInfo.Resident
+			}
+			for _, group := range mapping.Groups {
+				groupData := groupMetrics[group]
+				groupData.CPUPercent += cpuSeconds
+				groupData.RAMBytes += rssBytes
+				groupData.ProcessCount++
+				if mapping.Inherited {
+					groupData.InheritedProcessCount++
+				}
+				groupMetrics[group] = groupData
+			}
+		}
+		if projectName == "" { // Only mapped to groups
 			continue
 		}
 
@@ -141,6 +198,10 @@ func CollectMetrics(cfg *config.Config) CollectedMetrics {
 		}
 
 		currentProjectMetrics.ProcessCount++
+		currentProjectMetrics.Discovered = mapping.Discovered
+		if mapping.Inherited {
+			currentProjectMetrics.InheritedProcessCount++
+		}
 
 		// Execute plugin if configured and not yet executed for this project // MODIFIED BLOCK
 		var projectRuleForPlugin *config.ProjectConfig
@@ -152,7 +213,7 @@ func CollectMetrics(cfg *config.Config) CollectedMetrics {
 		}
 
 		if projectRuleForPlugin != nil && projectRuleForPlugin.Plugin != "" && !processedPlugins[projectName] {
-			pluginExecutablePath := filepath.Join("plugins", projectRuleForPlugin.Plugin) // Construct path relative to 'plugins' dir
+			pluginExecutablePath := projectRuleForPlugin.PluginPath()
 			
 			customMetrics, pluginErr := executePlugin(pluginExecutablePath, projectName)
 			if pluginErr != nil {
@@ -177,6 +238,54 @@ func CollectMetrics(cfg *config.Config) CollectedMetrics {
 		metrics[projectName] = currentProjectMetrics
 	}
 
+	// 3. File freshness checks. These are reported even when no process matched
+	// the project, since backup jobs usually run from cron and exit.
+	for _, proj := range cfg.Projects {
+		if len(proj.FileChecks) == 0 {
+			continue
+		}
+		projectMetrics := metrics[proj.Name]
+		projectMetrics.FileChecks = filecheck.Run(proj.FileChecks)
+		for _, res := range projectMetrics.FileChecks {
+			if res.Status != filecheck.StatusOK {
+				log.Printf("File check %q for project %s: %s", res.Name, proj.Name, res.Status)
+			}
+		}
+		metrics[proj.Name] = projectMetrics
+	}
+
+	// 4. Log counters from the projects' tailed log files.
+	for projectName, report := range logwatch.Collect(cfg) {
+		projectMetrics := metrics[projectName]
+		report := report
+		projectMetrics.Logs = &report
+		metrics[projectName] = projectMetrics
+	}
+
+	// 5. Journal messages of projects matched by systemd unit.
+	for projectName, report := range journal.Collect(cfg) {
+		projectMetrics := metrics[projectName]
+		report := report
+		projectMetrics.Journal = &report
+		metrics[projectName] = projectMetrics
+	}
+
+	// 6. Request metrics from web server access logs.
+	for projectName, report := range accesslog.Collect(cfg) {
+		projectMetrics := metrics[projectName]
+		report := report
+		projectMetrics.Requests = &report
+		metrics[projectName] = projectMetrics
+	}
+
+	// 7. Group rollups.
+	if len(groupMetrics) > 0 {
+		metrics["_groups"] = MetricData{Groups: groupMetrics}
+	}
+
+	// 8. Agent self-metrics.
+	metrics["_agent"] = MetricData{Agent: &AgentMetrics{DockerCache: mapper.GetDockerCacheStats()}}
+
 	log.Printf("Collected metrics for %d projects/entities.", len(metrics))
 	return metrics
 } 
\ No newline at end of file
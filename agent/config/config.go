@@ -1,77 +1,880 @@
-package config
+package config // Note: main.go will refer to this as 'config.LoadConfig'
 
 import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"log"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
 
-// Config holds the agent\'s configuration, loaded from config.yaml
+// Config holds the entire agent configuration
+
 type Config struct {
-	ApiGateway APIGatewayConfig         \`yaml:"api_gateway"\`
-	Interval   int                      \`yaml:"interval"\`      // Metrics collection interval in seconds
-	Projects   map[string]ProjectConfig \`yaml:"projects"\` // Map from project name to its configuration
-	// Add other global configurations here if needed, e.g., log level
+	Version        int                    `yaml:"version"` // Schema version, see CurrentVersion
+	APIGateway     APIGatewaySettings     `yaml:"api_gateway"`
+	AgentSettings  AgentSettings          `yaml:"agent_settings"`
+	Projects       []ProjectConfig        `yaml:"projects"`
+	AccessLogs     []AccessLog            `yaml:"access_logs,omitempty"`   // Web server access logs feeding per-project request metrics
+	Discovery      []DiscoveryRule        `yaml:"discovery,omitempty"`     // Create projects from compose projects, slices and units
+	Groups         []GroupConfig          `yaml:"groups,omitempty"`        // Secondary groupings (team, tier, ...) with their own rollups
+	RemoteConfig   *RemoteConfig          `yaml:"remote_config,omitempty"` // Fetch more projects from the API gateway
+	rawConfig      map[string]interface{} // To store the raw map for debugging or direct access if needed
+	projectsDir    string                 // Drop-in directory the projects were read from, see ProjectsDir
+	remoteRevision string                 // Revision of the remote project list in Projects, see RemoteRevision
+}
+
+// Sources a DiscoveryRule can create projects from.
+const (
+	DiscoverySourceCompose = "compose"       // The com.docker.compose.project label of a container
+	DiscoverySourceSlice   = "slice"         // A systemd slice the process runs under
+	DiscoverySourceUnit    = "unit"          // The systemd unit the process runs in
+	DiscoverySourceK8s     = "k8s_namespace" // The Kubernetes namespace of the process's pod
+)
+
+// DiscoveryRule creates a project for every distinct value found on the host,
+// e.g. one per compose project, instead of a projects entry each. Processes
+// are only discovered if no project in 'projects' matches them.
+type DiscoveryRule struct {
+	Source string `yaml:"source"` // compose, slice, unit or k8s_namespace
+	// Pattern filters the source value (compose project, slice, unit or namespace name).
+	// Its named groups can be used in Name. Required for slice and unit.
+	Pattern Regexp `yaml:"pattern,omitempty"` // e.g. "^app-(?P<name>.+)\.service$"
+	// Name is the project name template. $name / ${name} is the compose project
+	// or namespace, or the pattern's "name" group; other named groups work the same way.
+	Name string `yaml:"name,omitempty"` // Default "$name"
+}
+
+// GroupConfig defines a secondary grouping of processes such as "team:payments"
+// or "tier:db". Unlike projects, where the first match wins, a process belongs
+// to every group it matches, in addition to its project.
+type GroupConfig struct {
+	Name     string     `yaml:"name"`
+	Match    MatchRules `yaml:"match,omitempty"`    // Same rules as a project's match
+	Projects []string   `yaml:"projects,omitempty"` // Processes of these projects (configured or discovered) belong to the group too
 }
 
-// APIGatewayConfig contains the configuration for connecting to the API gateway
-type APIGatewayConfig struct {
-	URL   string \`yaml:"url"\`
-	Token string \`yaml:"token"\`
+// APIGatewaySettings defines the API gateway connection details
+type APIGatewaySettings struct {
+	URL string `yaml:"url"`
+	// Token is the token itself or where to read it from: "file:/etc/vps-agent/token",
+	// "env:VPS_AGENT_TOKEN" or "systemd-cred:token". LoadConfig replaces a reference
+	// with the token it points to.
+	Token string `yaml:"token"`
 }
 
-// ProjectConfig defines the configuration for a single monitored project
+// AgentSettings defines general agent behaviors
+type AgentSettings struct {
+	CollectionInterval int         `yaml:"collection_interval"`
+	NodeIdentifier     string      `yaml:"node_identifier,omitempty"` // omitempty if you want to allow it to be absent
+	StateDir           string      `yaml:"state_dir,omitempty"`       // Where the agent persists state (log offsets etc.), default "state"
+	JournalDir         string      `yaml:"journal_dir,omitempty"`     // Journal files to read; default /var/log/journal and /run/log/journal
+	LogShipping        LogShipping `yaml:"log_shipping,omitempty"`
+	// ProcessInheritance lets processes without a match of their own inherit the
+	// project of their nearest matched ancestor (shell scripts, cron jobs, workers).
+	ProcessInheritance bool       `yaml:"process_inheritance,omitempty"`
+	Kubernetes         Kubernetes `yaml:"kubernetes,omitempty"`   // Where pod metadata for k8s_* match rules comes from
+	WatchConfig        bool       `yaml:"watch_config,omitempty"` // Reload the configuration when the file changes, not only on SIGHUP
+	// ProjectsDir holds drop-in files with more projects, one or more per file,
+	// e.g. one per team. Relative to the configuration file's directory.
+	ProjectsDir string `yaml:"projects_dir,omitempty"` // Default "projects.d"
+}
+
+// Kubernetes configures how the pods of processes in kubepods cgroups are resolved
+// to their namespace, name and labels.
+type Kubernetes struct {
+	// KubeletURL is the kubelet's read-only endpoint, e.g. "http://127.0.0.1:10255".
+	// Without it, pods are read from KubeletDir, which has no labels unless the
+	// pod mounts them with a downward API volume.
+	KubeletURL string `yaml:"kubelet_url,omitempty"`
+	KubeletDir string `yaml:"kubelet_dir,omitempty"` // Default /var/lib/kubelet
+}
+
+// LogShipping tunes how shipped log records are batched and buffered on disk
+// (under state_dir) while the API gateway is unreachable.
+type LogShipping struct {
+	BatchSize     int   `yaml:"batch_size,omitempty"`      // Records per batch, default 500
+	QueueMaxBytes int64 `yaml:"queue_max_bytes,omitempty"` // Disk queue limit, default 64 MiB; oldest batches are evicted first
+}
+
+// reservedProjectNames are the keys the collector reports next to the projects.
+var reservedProjectNames = map[string]bool{"_system": true, "_groups": true, "_agent": true}
+
+// ReservedProjectName reports whether name is a key the collector reports next
+// to the projects, which no configured or discovered project may use.
+func ReservedProjectName(name string) bool {
+	return reservedProjectNames[name]
+}
+
+// ProjectConfig defines a single project's mapping rules and plugin
 type ProjectConfig struct {
-	Match      ProjectMatch \`yaml:"match"\`                 // Rules to identify processes belonging to this project
-	PluginPath string       \`yaml:"plugin,omitempty"\` // Optional path to a plugin executable for custom metrics
-	// Add other project-specific configurations here, e.g., resource limits for plugins/tasks
+	Name       string      `yaml:"name"`
+	Match      MatchRules  `yaml:"match"`
+	Plugin     string      `yaml:"plugin,omitempty"`
+	FileChecks []FileCheck `yaml:"file_checks,omitempty"` // Freshness checks for backups and other generated files
+	Logs       *LogWatch   `yaml:"logs,omitempty"`        // Log files to tail and count pattern matches in
+	Journal    *Journal    `yaml:"journal,omitempty"`     // Read the journal of match.systemd_unit
+	ShipLogs   *ShipLogs   `yaml:"ship_logs,omitempty"`   // Forward log lines to the API gateway
+	Inherit    *bool       `yaml:"inherit,omitempty"`     // With process_inheritance: pass the project on to child processes, default true
+	source     string      // Where the project is defined, e.g. "projects.d/10-billing.yaml line 3"
 }
 
-// ProjectMatch specifies the criteria for matching processes to a project.
-// At least one field should be non-empty for a valid match.
-type ProjectMatch struct {
-	SystemdService     string \`yaml:"systemd_service,omitempty"\`     // e.g., "my-app.service"
-	DockerLabel        string \`yaml:"docker_label,omitempty"\`        // e.g., "com.mycompany.project=my-app"
-	DockerContainerName string \`yaml:"docker_container_name,omitempty"\` // e.g., "my-app-container" (exact match)
-	// Consider adding DockerContainerNamePattern for regex matching later.
-	Username           string \`yaml:"user,omitempty"\`                // e.g., "appuser"
-	ProcessNamePattern string \`yaml:"process_name_pattern,omitempty"\`  // Regex, e.g., "^/opt/my-app/bin/server"
-	CgroupPathPattern  string \`yaml:"cgroup_path_pattern,omitempty"\` // Regex, e.g., ".*/docker-[a-f0-9]{64}\\.scope$"
+// PluginPath returns the path the plugin is run from. Paths are relative to the
+// agent's working directory, e.g. "plugins/projectA_plugin.py". A bare file
+// name is looked up in plugins/, as by early releases; that is deprecated and
+// logged on load.
+func (p ProjectConfig) PluginPath() string {
+	if p.Plugin == "" || strings.ContainsRune(p.Plugin, filepath.Separator) {
+		return p.Plugin
+	}
+	return filepath.Join("plugins", p.Plugin)
 }
 
-// LoadConfig reads the configuration file from the given path and parses it.
-// If no path is provided, it defaults to "config.yaml" in the current directory.
-// It also looks for AGENT_CONFIG_PATH environment variable.
-func LoadConfig(path string) (*Config, error) {
-	if path == "" {
-		path = os.Getenv("AGENT_CONFIG_PATH")
-		if path == "" {
-			path = "config.yaml" // Default config file name
+// Inheritable reports whether child processes may inherit this project.
+func (p ProjectConfig) Inheritable() bool {
+	return p.Inherit == nil || *p.Inherit
+}
+
+// MatchRules defines the criteria for mapping a process to a project.
+//
+// The flat criteria of a node are a shorthand for an all: block: the node
+// matches if every one of them does, so "systemd_unit: a, user: b" means unit a
+// and user b. All, Any and Not are combined with the flat criteria, and with
+// each other, by AND; alternatives go in an any: block. A node without any
+// criteria never matches.
+type MatchRules struct {
+	User                 string         `yaml:"user,omitempty"`
+	SystemdUnit          string         `yaml:"systemd_unit,omitempty"`
+	DockerLabel          string         `yaml:"docker_label,omitempty"`           // e.g., "com.example.project=ProjectA"
+	ContainerNamePattern Regexp         `yaml:"container_name_pattern,omitempty"` // Regex on the container name, e.g. "^project_b_"
+	DockerContainerName  string         `yaml:"docker_container_name,omitempty"`  // Exact container name
+	ProcessNamePattern   ProcessPattern `yaml:"process_name_pattern,omitempty"`
+	Cmdline              string         `yaml:"cmdline,omitempty"`       // Substring of the command line; for a regex use process_name_pattern with target cmdline
+	Exe                  string         `yaml:"exe,omitempty"`           // Executable path (/proc/<pid>/exe), exact or shell glob, e.g. "/opt/billing/bin/*"
+	Cwd                  string         `yaml:"cwd,omitempty"`           // Working directory, or a directory above it, e.g. "/srv/billing"
+	Env                  string         `yaml:"env,omitempty"`           // Environment variable "KEY=VALUE" or just "KEY", from /proc/<pid>/environ
+	ListenPort           PortSet        `yaml:"listen_port,omitempty"`   // TCP/UDP port the process listens on: 8080, "9000-9010" or [80, 443]
+	K8sNamespace         string         `yaml:"k8s_namespace,omitempty"` // Namespace of the process's Kubernetes pod
+	K8sLabel             string         `yaml:"k8s_label,omitempty"`     // Pod label "KEY=VALUE" or just "KEY", e.g. "app.kubernetes.io/part-of=shop"
+
+	All []MatchRules `yaml:"all,omitempty"` // Every expression must match
+	Any []MatchRules `yaml:"any,omitempty"` // At least one expression must match
+	Not *MatchRules  `yaml:"not,omitempty"` // The expression must not match
+}
+
+// HasCriteria reports whether any flat criterion (user, systemd_unit, ...) is set.
+func (m MatchRules) HasCriteria() bool {
+	return m.User != "" || m.SystemdUnit != "" || m.DockerLabel != "" ||
+		!m.ContainerNamePattern.IsZero() || m.DockerContainerName != "" || !m.ProcessNamePattern.IsZero() ||
+		m.Cmdline != "" || m.Exe != "" || m.Cwd != "" || m.Env != "" || !m.ListenPort.IsZero() ||
+		m.K8sNamespace != "" || m.K8sLabel != ""
+}
+
+// IsZero reports whether the node has neither criteria nor all/any/not blocks.
+func (m MatchRules) IsZero() bool {
+	return !m.HasCriteria() && len(m.All) == 0 && len(m.Any) == 0 && m.Not == nil
+}
+
+// Compile compiles all patterns of the expression and rejects empty nested
+// nodes. path names the node in error messages, e.g. "match". It is called by LoadConfig.
+func (m *MatchRules) Compile(path string) error {
+	if !m.ProcessNamePattern.IsZero() {
+		if err := m.ProcessNamePattern.Compile(); err != nil {
+			return fmt.Errorf("invalid %s.process_name_pattern %q: %w", path, m.ProcessNamePattern.Pattern, err)
 		}
 	}
+	if !m.ContainerNamePattern.IsZero() {
+		if err := m.ContainerNamePattern.Compile(); err != nil {
+			return fmt.Errorf("invalid %s.container_name_pattern %q: %w", path, m.ContainerNamePattern.Pattern, err)
+		}
+	}
+	if m.Exe != "" {
+		if _, err := filepath.Match(m.Exe, ""); err != nil {
+			return fmt.Errorf("invalid %s.exe %q: %w", path, m.Exe, err)
+		}
+	}
+	if m.Cwd != "" && !filepath.IsAbs(m.Cwd) {
+		return fmt.Errorf("invalid %s.cwd %q: must be an absolute path", path, m.Cwd)
+	}
+	if strings.HasPrefix(m.Env, "=") {
+		return fmt.Errorf("invalid %s.env %q: missing variable name", path, m.Env)
+	}
+	if strings.HasPrefix(m.K8sLabel, "=") {
+		return fmt.Errorf("invalid %s.k8s_label %q: missing label key", path, m.K8sLabel)
+	}
+	for i := range m.All {
+		if err := m.All[i].compileNested(fmt.Sprintf("%s.all[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	for i := range m.Any {
+		if err := m.Any[i].compileNested(fmt.Sprintf("%s.any[%d]", path, i)); err != nil {
+			return err
+		}
+	}
+	if m.Not != nil {
+		if err := m.Not.compileNested(path + ".not"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MatchRules) compileNested(path string) error {
+	if m.IsZero() {
+		return fmt.Errorf("%s has no match criteria", path)
+	}
+	return m.Compile(path)
+}
+
+// Regexp is a regular expression written as a plain string in YAML and
+// compiled by LoadConfig.
+type Regexp struct {
+	Pattern string
+	re      *regexp.Regexp
+}
+
+// UnmarshalYAML reads the pattern from a scalar.
+func (r *Regexp) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: expected a regular expression string", value.Line)
+	}
+	r.Pattern = value.Value
+	r.re = nil
+	return nil
+}
+
+// MarshalYAML writes the pattern back as a plain string.
+func (r Regexp) MarshalYAML() (interface{}, error) {
+	return r.Pattern, nil
+}
+
+// IsZero reports whether no pattern is configured.
+func (r Regexp) IsZero() bool {
+	return r.Pattern == ""
+}
+
+// Compile compiles the pattern. It is called by LoadConfig.
+func (r *Regexp) Compile() error {
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return err
+	}
+	r.re = re
+	return nil
+}
+
+// MatchString reports whether s matches. It never matches if the pattern is
+// empty or was not compiled.
+func (r Regexp) MatchString(s string) bool {
+	return r.re != nil && r.re.MatchString(s)
+}
+
+// NamedGroups matches s and returns the values of the pattern's named groups,
+// or nil if s does not match.
+func (r Regexp) NamedGroups(s string) map[string]string {
+	if r.re == nil {
+		return nil
+	}
+	m := r.re.FindStringSubmatch(s)
+	if m == nil {
+		return nil
+	}
+	groups := make(map[string]string)
+	for i, name := range r.re.SubexpNames() {
+		if name != "" {
+			groups[name] = m[i]
+		}
+	}
+	return groups
+}
+
+// PortSet is a set of ports. In YAML it is a single port (8080), a range
+// ("9000-9010") or a list of both ([80, 443, "9000-9010"]).
+type PortSet struct {
+	Ranges [][2]int // Inclusive [first, last] port ranges
+}
+
+// UnmarshalYAML reads a port, a range or a list of them and validates them.
+func (s *PortSet) UnmarshalYAML(value *yaml.Node) error {
+	s.Ranges = nil
+	nodes := []*yaml.Node{value}
+	if value.Kind == yaml.SequenceNode {
+		nodes = value.Content
+	}
+	for _, node := range nodes {
+		if node.Kind != yaml.ScalarNode {
+			return fmt.Errorf("line %d: expected a port, a port range or a list of them", node.Line)
+		}
+		r, err := parsePortRange(node.Value)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", node.Line, err)
+		}
+		s.Ranges = append(s.Ranges, r)
+	}
+	if len(s.Ranges) == 0 {
+		return fmt.Errorf("line %d: empty port list", value.Line)
+	}
+	return nil
+}
+
+func parsePortRange(value string) ([2]int, error) {
+	first, last, isRange := strings.Cut(strings.TrimSpace(value), "-")
+	lo, err := strconv.Atoi(strings.TrimSpace(first))
+	if err != nil {
+		return [2]int{}, fmt.Errorf("invalid port %q", value)
+	}
+	hi := lo
+	if isRange {
+		if hi, err = strconv.Atoi(strings.TrimSpace(last)); err != nil {
+			return [2]int{}, fmt.Errorf("invalid port range %q", value)
+		}
+	}
+	if lo < 1 || hi > 65535 || lo > hi {
+		return [2]int{}, fmt.Errorf("invalid port range %q: ports must be 1-65535, first <= last", value)
+	}
+	return [2]int{lo, hi}, nil
+}
+
+// MarshalYAML writes the set back as a list of ports and ranges.
+func (s PortSet) MarshalYAML() (interface{}, error) {
+	return strings.Split(s.String(), ", "), nil
+}
+
+// IsZero reports whether no port is configured.
+func (s PortSet) IsZero() bool {
+	return len(s.Ranges) == 0
+}
+
+// Contains reports whether port is in the set.
+func (s PortSet) Contains(port int) bool {
+	for _, r := range s.Ranges {
+		if port >= r[0] && port <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the set as written in YAML, e.g. "80, 443, 9000-9010".
+func (s PortSet) String() string {
+	parts := make([]string, len(s.Ranges))
+	for i, r := range s.Ranges {
+		parts[i] = strconv.Itoa(r[0])
+		if r[1] != r[0] {
+			parts[i] += "-" + strconv.Itoa(r[1])
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Targets a ProcessPattern can be matched against.
+const (
+	PatternTargetName    = "name"    // Process name (comm), e.g. "nginx"
+	PatternTargetExe     = "exe"     // Executable path, e.g. "/usr/sbin/nginx"
+	PatternTargetCmdline = "cmdline" // Full command line, arguments joined by spaces
+)
+
+// ProcessPattern is a regular expression matched against one attribute of a
+// process. In YAML it is either a plain string (matched against the process
+// name) or a mapping with 'pattern' and 'target'.
+type ProcessPattern struct {
+	Pattern string `yaml:"pattern"`
+	Target  string `yaml:"target,omitempty"` // name (default), exe or cmdline
+	re      *regexp.Regexp
+}
+
+// UnmarshalYAML accepts both the string shorthand and the mapping form.
+func (p *ProcessPattern) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		p.Pattern = value.Value
+		p.Target = ""
+		return nil
+	}
+	type plain ProcessPattern // Avoid recursing into this method
+	var raw plain
+	if err := value.Decode(&raw); err != nil {
+		return err
+	}
+	*p = ProcessPattern(raw)
+	return nil
+}
+
+// IsZero reports whether no pattern is configured.
+func (p ProcessPattern) IsZero() bool {
+	return p.Pattern == ""
+}
+
+// Compile validates the target and compiles the pattern. It is called by LoadConfig.
+func (p *ProcessPattern) Compile() error {
+	switch p.Target {
+	case "":
+		p.Target = PatternTargetName
+	case PatternTargetName, PatternTargetExe, PatternTargetCmdline:
+	default:
+		return fmt.Errorf("unknown target %q (want name, exe or cmdline)", p.Target)
+	}
+	re, err := regexp.Compile(p.Pattern)
+	if err != nil {
+		return err
+	}
+	p.re = re
+	return nil
+}
+
+// MatchString reports whether s matches the pattern. It never matches if the
+// pattern is empty or was not compiled.
+func (p ProcessPattern) MatchString(s string) bool {
+	return p.re != nil && p.re.MatchString(s)
+}
+
+// FileCheck asserts that the newest file matching Path is recent and large enough.
+// It is used to catch backup jobs that fail silently.
+type FileCheck struct {
+	Name     string        `yaml:"name,omitempty"`     // Label used in the report; defaults to Path
+	Path     string        `yaml:"path"`               // File path or glob, e.g. "/var/backups/db-*.sql.gz"
+	MaxAge   time.Duration `yaml:"max_age"`            // e.g. "26h"
+	MinSize  int64         `yaml:"min_size,omitempty"` // Minimum size in bytes
+	Checksum string        `yaml:"checksum,omitempty"` // "sha256" or "md5": verify against a "<file>.<checksum>" sidecar
+}
+
+// LogWatch configures log tailing for a project. Every rule is evaluated
+// against every new line of every file; matching lines increment the rule's counter.
+type LogWatch struct {
+	Files   []string  `yaml:"files"`             // File paths or globs, e.g. "/var/log/nginx/*.log"
+	Rules   []LogRule `yaml:"rules"`             // Regex rules evaluated into counters
+	Samples int       `yaml:"samples,omitempty"` // Sample lines kept per rule and tick, default 3
+}
+
+// LogRule counts log lines matching Pattern under the counter Name (e.g. "error_lines").
+type LogRule struct {
+	Name    string `yaml:"name"`
+	Pattern string `yaml:"pattern"` // Go regexp syntax, e.g. "(?i)error"
+}
+
+// Journal enables journald ingestion for a project matched by systemd_unit.
+// Messages are counted by priority, and the project's logs.rules are applied to them.
+type Journal struct {
+	ForwardErrors int `yaml:"forward_errors,omitempty"` // Forward the last N messages with priority err or worse
+}
+
+// ShipLogs configures which of a project's logs are forwarded to the API gateway.
+type ShipLogs struct {
+	Files     []string     `yaml:"files,omitempty"`     // File paths or globs
+	Journal   bool         `yaml:"journal,omitempty"`   // Ship the journal of match.systemd_unit
+	Multiline *Multiline   `yaml:"multiline,omitempty"` // Join continuation lines (e.g. stack traces) into one record
+	Redact    []RedactRule `yaml:"redact,omitempty"`    // Applied to every record before it leaves the host
+}
 
-	data, err := os.ReadFile(path)
+// Multiline joins lines into records: a line matching StartPattern starts a
+// new record, any other line is appended to the current one.
+type Multiline struct {
+	StartPattern string `yaml:"start_pattern"`       // e.g. "^\\d{4}-\\d{2}-\\d{2}"
+	MaxLines     int    `yaml:"max_lines,omitempty"` // Lines per record before it is cut, default 200
+}
+
+// RedactRule replaces every match of Pattern in a record with Replacement.
+type RedactRule struct {
+	Pattern     string `yaml:"pattern"`
+	Replacement string `yaml:"replacement,omitempty"` // Default "[REDACTED]"; may use $1-style group references
+}
+
+// AccessLog is a web server access log (nginx or Apache) whose requests are
+// attributed to projects. One log can feed several projects through Routes.
+type AccessLog struct {
+	Files       []string          `yaml:"files"`                  // File paths or globs
+	Format      string            `yaml:"format,omitempty"`       // "combined" (default) or "json"
+	ExtraFields []string          `yaml:"extra_fields,omitempty"` // combined: fields logged after the user agent, see below
+	JSONFields  map[string]string `yaml:"json_fields,omitempty"`  // json: field -> key, for status, request_time, host, upstream
+	Project     string            `yaml:"project,omitempty"`      // Project for requests no route matches
+	Routes      []AccessLogRoute  `yaml:"routes,omitempty"`       // First matching route wins
+}
+
+// Field names understood in AccessLog.ExtraFields and as AccessLog.JSONFields keys.
+// Use "-" in ExtraFields to skip a field.
+const (
+	AccessFieldStatus        = "status"
+	AccessFieldRequestTime   = "request_time"    // Seconds, nginx $request_time or Apache %T
+	AccessFieldRequestTimeUs = "request_time_us" // Microseconds, Apache %D
+	AccessFieldHost          = "host"            // nginx $host or Apache %v
+	AccessFieldUpstream      = "upstream"        // nginx $upstream_addr
+)
+
+var accessFields = map[string]bool{
+	AccessFieldStatus:        true,
+	AccessFieldRequestTime:   true,
+	AccessFieldRequestTimeUs: true,
+	AccessFieldHost:          true,
+	AccessFieldUpstream:      true,
+}
+
+// AccessLogRoute attributes requests to a project by virtual host and/or
+// upstream address. Both accept shell-style globs, e.g. "*.example.com".
+type AccessLogRoute struct {
+	Host     string `yaml:"host,omitempty"`
+	Upstream string `yaml:"upstream,omitempty"`
+	Project  string `yaml:"project"`
+}
+
+// LoadConfig reads the YAML configuration file and unmarshals it into the Config struct.
+// Files in the version 1 format are converted, logging a deprecation warning
+// per converted setting. With remote_config, the cached remote project list is
+// added if its signature is valid.
+func LoadConfig(filePath string) (*Config, error) {
+	return load(filePath, nil, nil)
+}
+
+// LoadConfigWithRemote is LoadConfig with a remote project list just fetched
+// from the gateway instead of the cached one. Unlike a bad cached copy, which
+// is logged and skipped, a bad signature or project here is an error.
+func LoadConfigWithRemote(filePath string, data, signature []byte) (*Config, error) {
+	if data == nil {
+		return nil, fmt.Errorf("no remote project list")
+	}
+	return load(filePath, data, signature)
+}
+
+func load(filePath string, remoteData, remoteSignature []byte) (*Config, error) {
+	data, err := os.ReadFile(filePath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+		return nil, fmt.Errorf("failed to read config file %s: %w", filePath, err)
 	}
 
-	// Expand environment variables in the config file content
-	expandedData := os.ExpandEnv(string(data))
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal YAML from %s: %w", filePath, err)
+	}
+	version, err := documentVersion(&doc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", filePath, err)
+	}
+	if version == LegacyVersion {
+		// Version 1 files were expanded like a shell would, e.g. token: ${VPS_AGENT_TOKEN}
+		data = []byte(os.ExpandEnv(string(data)))
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML from %s: %w", filePath, err)
+		}
+		warnings, err := upgradeLegacy(&doc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to convert version 1 config file %s: %w", filePath, err)
+		}
+		for _, warning := range warnings {
+			log.Printf("config: %s: %s", filePath, warning)
+		}
+		log.Printf("config: %s uses the deprecated version 1 format; convert it with 'agent config migrate -c %s'", filePath, filePath)
+	}
 
 	var cfg Config
-	err = yaml.Unmarshal([]byte(expandedData), &cfg)
+	if len(doc.Content) > 0 {
+		// Unknown keys are errors rather than silently ignored: a typo or a
+		// setting from another version would otherwise just do nothing.
+		if errs := unknownKeys(doc.Content[0], reflect.TypeOf(cfg), ""); len(errs) > 0 {
+			return nil, fmt.Errorf("invalid config file %s:\n%w", filePath, errors.Join(errs...))
+		}
+		if err := doc.Decode(&cfg); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal YAML from %s: %w", filePath, err)
+		}
+		// Store the raw map as well, useful for debugging or complex lookups
+		_ = doc.Decode(&cfg.rawConfig)
+	}
+	cfg.Version = CurrentVersion // Also for files in the current format without a version key
+
+	// Projects from drop-in files follow the main file's, so they are matched after them.
+	if root := documentRoot(&doc); root != nil {
+		setProjectSources(root, filePath, cfg.Projects)
+	}
+	cfg.projectsDir = projectsDir(filePath, cfg.AgentSettings)
+	dropIns, err := loadDropIns(cfg.projectsDir)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		return nil, err
 	}
+	cfg.Projects = append(cfg.Projects, dropIns...)
 
-	// Basic validation (can be expanded)
-	if cfg.Interval <= 0 {
-		cfg.Interval = 60 // Default to 60 seconds if not specified or invalid
+	if cfg.AgentSettings.CollectionInterval <= 0 {
+		cfg.AgentSettings.CollectionInterval = 30 // Default if invalid
 	}
-	if cfg.ApiGateway.URL == "" {
-		return nil, fmt.Errorf("api_gateway.url is not set in config")
+	if cfg.AgentSettings.StateDir == "" {
+		cfg.AgentSettings.StateDir = "state" // Relative to the agent's working directory, like plugins/
+	}
+	if cfg.APIGateway.Token != "" {
+		token, err := resolveSecret(cfg.APIGateway.Token)
+		if err != nil {
+			return nil, fmt.Errorf("invalid config file %s:\napi_gateway.token %w", filePath, err)
+		}
+		cfg.APIGateway.Token = token
 	}
 
+	// Remote projects come last: local definitions are matched first.
+	if cfg.RemoteConfig != nil {
+		fetched := remoteData != nil
+		if !fetched {
+			if remoteData, remoteSignature, err = cfg.ReadRemoteCache(); err != nil {
+				log.Printf("config: ignoring the cached remote project list: %v", err)
+			}
+		}
+		if remoteData != nil {
+			remote, err := verifyRemote(remoteData, remoteSignature, cfg.RemoteConfig.PublicKey)
+			if err == nil {
+				err = remote.checkNode(cfg.nodeName())
+			}
+			if err == nil && fetched {
+				err = cfg.checkRevision(remote, remoteData)
+			}
+			switch {
+			case err != nil && fetched:
+				return nil, fmt.Errorf("invalid remote project list: %w", err)
+			case err != nil:
+				log.Printf("config: ignoring the cached remote project list %s: %v", cfg.RemoteCachePath(), err)
+			default:
+				cfg.Projects = append(cfg.Projects, remote.Projects...)
+				cfg.remoteRevision = strconv.FormatUint(remote.Revision, 10)
+			}
+		}
+	} else if remoteData != nil {
+		return nil, fmt.Errorf("remote_config is not set in %s", filePath)
+	}
+
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid config file %s:\n%w", filePath, err)
+	}
+	for _, proj := range cfg.Projects {
+		if path := proj.PluginPath(); path != proj.Plugin {
+			log.Printf("config: %s: project %s: plugin %q is looked up in plugins/; bare plugin names are deprecated, write plugin: %s", proj.source, proj.Name, proj.Plugin, path)
+		}
+	}
 	return &cfg, nil
-} 
\ No newline at end of file
+}
+
+// validate checks the decoded configuration and compiles its patterns. It
+// returns every problem found, joined, rather than only the first.
+func (cfg *Config) validate() error {
+	var errs []error
+	if cfg.APIGateway.URL == "" {
+		errs = append(errs, fmt.Errorf("api_gateway.url is required in config"))
+	}
+	if cfg.APIGateway.Token == "" {
+		errs = append(errs, fmt.Errorf("api_gateway.token is required in config"))
+	}
+	if rc := cfg.RemoteConfig; rc != nil {
+		if key, err := base64.StdEncoding.DecodeString(rc.PublicKey); err != nil || len(key) != ed25519.PublicKeySize {
+			errs = append(errs, fmt.Errorf("remote_config.public_key must be a base64 Ed25519 public key"))
+		}
+		if rc.RefreshInterval < 0 {
+			errs = append(errs, fmt.Errorf("remote_config.refresh_interval must not be negative"))
+		}
+	}
+
+	projectsByName := make(map[string]*ProjectConfig)
+	projectsByMatch := make(map[string]*ProjectConfig) // Keyed by the match rules in YAML
+	for i := range cfg.Projects {
+		proj := &cfg.Projects[i]
+		if proj.Name == "" {
+			errs = append(errs, fmt.Errorf("projects[%d].name is required%s", i, definedAt(proj)))
+		} else if other, ok := projectsByName[proj.Name]; ok {
+			errs = append(errs, fmt.Errorf("project %s is defined twice%s", proj.Name, definedAt(other, proj)))
+		} else if reservedProjectNames[proj.Name] {
+			errs = append(errs, fmt.Errorf("project name %s is reserved for the agent's own metrics%s", proj.Name, definedAt(proj)))
+		} else {
+			projectsByName[proj.Name] = proj
+		}
+		if proj.Match.IsZero() {
+			errs = append(errs, fmt.Errorf("project %s has no match rules", proj.Name))
+		} else if err := proj.Match.Compile("match"); err != nil {
+			errs = append(errs, fmt.Errorf("project %s: %w", proj.Name, err))
+		} else if rules, err := yaml.Marshal(proj.Match); err == nil {
+			// The first matching project wins, so the second would never get a process.
+			if other, ok := projectsByMatch[string(rules)]; ok && other.Name != proj.Name {
+				errs = append(errs, fmt.Errorf("project %s has the same match rules as project %s and would never match%s", proj.Name, other.Name, definedAt(other, proj)))
+			} else if !ok {
+				projectsByMatch[string(rules)] = proj
+			}
+		}
+		for i, fc := range proj.FileChecks {
+			if fc.Path == "" {
+				errs = append(errs, fmt.Errorf("project %s: file_checks[%d].path is required", proj.Name, i))
+			}
+			if fc.MaxAge <= 0 {
+				errs = append(errs, fmt.Errorf("project %s: file_checks[%d].max_age must be positive", proj.Name, i))
+			}
+			if fc.Checksum != "" && fc.Checksum != "sha256" && fc.Checksum != "md5" {
+				errs = append(errs, fmt.Errorf("project %s: file_checks[%d].checksum must be sha256 or md5, got %q", proj.Name, i, fc.Checksum))
+			}
+		}
+		if proj.Logs != nil {
+			if len(proj.Logs.Files) == 0 {
+				errs = append(errs, fmt.Errorf("project %s: logs.files must list at least one file", proj.Name))
+			}
+			for i, rule := range proj.Logs.Rules {
+				if rule.Name == "" {
+					errs = append(errs, fmt.Errorf("project %s: logs.rules[%d].name is required", proj.Name, i))
+				}
+				if _, err := regexp.Compile(rule.Pattern); err != nil {
+					errs = append(errs, fmt.Errorf("project %s: logs rule %s has invalid pattern: %w", proj.Name, rule.Name, err))
+				}
+			}
+		}
+		if proj.Journal != nil && !singleUnit(proj.Match.SystemdUnit) {
+			errs = append(errs, fmt.Errorf("project %s: journal requires match.systemd_unit naming a single unit, e.g. my-app.service", proj.Name))
+		}
+		if sl := proj.ShipLogs; sl != nil {
+			if len(sl.Files) == 0 && !sl.Journal {
+				errs = append(errs, fmt.Errorf("project %s: ship_logs needs files or journal", proj.Name))
+			}
+			if sl.Journal && !singleUnit(proj.Match.SystemdUnit) {
+				errs = append(errs, fmt.Errorf("project %s: ship_logs.journal requires match.systemd_unit naming a single unit, e.g. my-app.service", proj.Name))
+			}
+			if sl.Multiline != nil {
+				if sl.Multiline.StartPattern == "" {
+					errs = append(errs, fmt.Errorf("project %s: ship_logs.multiline.start_pattern is required", proj.Name))
+				}
+				if _, err := regexp.Compile(sl.Multiline.StartPattern); err != nil {
+					errs = append(errs, fmt.Errorf("project %s: ship_logs.multiline.start_pattern is invalid: %w", proj.Name, err))
+				}
+			}
+			for i, rule := range sl.Redact {
+				if rule.Pattern == "" {
+					errs = append(errs, fmt.Errorf("project %s: ship_logs.redact[%d].pattern is required", proj.Name, i))
+				}
+				if _, err := regexp.Compile(rule.Pattern); err != nil {
+					errs = append(errs, fmt.Errorf("project %s: ship_logs.redact[%d].pattern is invalid: %w", proj.Name, i, err))
+				}
+			}
+		}
+	}
+
+	for i, al := range cfg.AccessLogs {
+		if len(al.Files) == 0 {
+			errs = append(errs, fmt.Errorf("access_logs[%d].files must list at least one file", i))
+		}
+		if al.Format != "" && al.Format != "combined" && al.Format != "json" {
+			errs = append(errs, fmt.Errorf("access_logs[%d].format must be combined or json, got %q", i, al.Format))
+		}
+		for j, name := range al.ExtraFields {
+			if name != "-" && !accessFields[name] {
+				errs = append(errs, fmt.Errorf("access_logs[%d].extra_fields[%d] must be status, request_time, request_time_us, host, upstream or -, got %q", i, j, name))
+			}
+		}
+		names := make([]string, 0, len(al.JSONFields))
+		for name := range al.JSONFields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if !accessFields[name] {
+				errs = append(errs, fmt.Errorf("access_logs[%d].json_fields: unknown field %q, use status, request_time, request_time_us, host or upstream", i, name))
+			}
+		}
+		if al.Project == "" && len(al.Routes) == 0 {
+			errs = append(errs, fmt.Errorf("access_logs[%d] needs a project or routes", i))
+		}
+		for j, route := range al.Routes {
+			if route.Project == "" || (route.Host == "" && route.Upstream == "") {
+				errs = append(errs, fmt.Errorf("access_logs[%d].routes[%d] needs a project and a host or upstream", i, j))
+			}
+		}
+	}
+
+	for i := range cfg.Discovery {
+		rule := &cfg.Discovery[i]
+		switch rule.Source {
+		case DiscoverySourceCompose, DiscoverySourceSlice, DiscoverySourceUnit, DiscoverySourceK8s:
+		default:
+			errs = append(errs, fmt.Errorf("discovery[%d].source must be compose, slice, unit or k8s_namespace, got %q", i, rule.Source))
+		}
+		if rule.Pattern.IsZero() && (rule.Source == DiscoverySourceSlice || rule.Source == DiscoverySourceUnit) {
+			errs = append(errs, fmt.Errorf("discovery[%d].pattern is required for source %s", i, rule.Source))
+		}
+		if !rule.Pattern.IsZero() {
+			if err := rule.Pattern.Compile(); err != nil {
+				errs = append(errs, fmt.Errorf("discovery[%d]: invalid pattern %q: %w", i, rule.Pattern.Pattern, err))
+			}
+		}
+		if rule.Name == "" {
+			rule.Name = "$name"
+		}
+	}
+
+	groupNames := make(map[string]bool)
+	for i := range cfg.Groups {
+		group := &cfg.Groups[i]
+		if group.Name == "" {
+			errs = append(errs, fmt.Errorf("groups[%d].name is required", i))
+		} else if groupNames[group.Name] {
+			errs = append(errs, fmt.Errorf("group %s is defined twice", group.Name))
+		}
+		groupNames[group.Name] = true
+		if group.Match.IsZero() && len(group.Projects) == 0 {
+			errs = append(errs, fmt.Errorf("group %s needs match rules or projects", group.Name))
+		}
+		if err := group.Match.Compile("match"); err != nil {
+			errs = append(errs, fmt.Errorf("group %s: %w", group.Name, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckPlugins reports plugins that do not exist or are not executable. It is
+// not part of LoadConfig, as a plugin may be deployed after its configuration;
+// the agent logs these problems and `agent validate` fails on them.
+func (cfg *Config) CheckPlugins() []error {
+	var errs []error
+	for _, proj := range cfg.Projects {
+		if proj.Plugin == "" {
+			continue
+		}
+		info, err := os.Stat(proj.PluginPath())
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("project %s: plugin %s: %w", proj.Name, proj.PluginPath(), err))
+		case !info.Mode().IsRegular():
+			errs = append(errs, fmt.Errorf("project %s: plugin %s is not a regular file", proj.Name, proj.PluginPath()))
+		case info.Mode().Perm()&0o111 == 0:
+			errs = append(errs, fmt.Errorf("project %s: plugin %s is not executable", proj.Name, proj.PluginPath()))
+		}
+	}
+	return errs
+}
+
+// ProjectsDir returns the directory drop-in project files were read from.
+func (c *Config) ProjectsDir() string {
+	return c.projectsDir
+}
+
+// definedAt lists where projects are defined, for error messages, e.g.
+// " (config.yaml line 40, projects.d/10-billing.yaml line 3)".
+func definedAt(projects ...*ProjectConfig) string {
+	var sources []string
+	for _, proj := range projects {
+		if proj.source != "" {
+			sources = append(sources, proj.source)
+		}
+	}
+	if len(sources) == 0 {
+		return ""
+	}
+	return " (" + strings.Join(sources, ", ") + ")"
+}
+
+// GetRawConfig allows access to the unmarshalled map[string]interface{} representation
+// This can be useful if you need to access parts of the config that are not strictly typed
+// or for more dynamic processing, though direct struct access is preferred.
+func (c *Config) GetRawConfig() map[string]interface{} {
+	return c.rawConfig
+}
+
+// singleUnit reports whether unit names one systemd unit the way journal
+// entries carry it, rather than a slice, a template or a name without a type.
+func singleUnit(unit string) bool {
+	return strings.Contains(unit, ".") && !strings.HasSuffix(unit, ".slice") && !strings.Contains(unit, "@.")
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+// defaultProjectsDir is where drop-in project files are read from, relative
+// to the directory of the main configuration file.
+const defaultProjectsDir = "projects.d"
+
+// dropInFile is a file in the projects directory. It holds projects only, so
+// teams owning different projects can each edit a file of their own.
+type dropInFile struct {
+	Version  int             `yaml:"version,omitempty"`
+	Projects []ProjectConfig `yaml:"projects"`
+}
+
+// projectsDir returns the drop-in directory configured for the configuration
+// file at configPath.
+func projectsDir(configPath string, settings AgentSettings) string {
+	dir := settings.ProjectsDir
+	if dir == "" {
+		dir = defaultProjectsDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(configPath), dir)
+	}
+	return dir
+}
+
+// loadDropIns reads the projects of every *.yaml and *.yml file in dir, in
+// file name order (e.g. 10-billing.yaml before 20-shop.yaml). A missing
+// directory has no projects. Errors name the file and line.
+func loadDropIns(dir string) ([]ProjectConfig, error) {
+	var files []string
+	for _, pattern := range []string{"*.yaml", "*.yml"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	var projects []ProjectConfig
+	var errs []error
+	for _, file := range files {
+		fileProjects, err := loadDropIn(file)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid project file %s:\n%w", file, err))
+			continue
+		}
+		projects = append(projects, fileProjects...)
+	}
+	return projects, errors.Join(errs...)
+}
+
+func loadDropIn(file string) ([]ProjectConfig, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	root := documentRoot(&doc)
+	if root == nil {
+		return nil, nil // Empty, e.g. everything commented out
+	}
+	if errs := unknownKeys(root, reflect.TypeOf(dropInFile{}), ""); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	var dropIn dropInFile
+	if err := root.Decode(&dropIn); err != nil {
+		return nil, err
+	}
+	if dropIn.Version != 0 && dropIn.Version != CurrentVersion {
+		return nil, fmt.Errorf("version %d is not supported in project files, use version %d", dropIn.Version, CurrentVersion)
+	}
+	setProjectSources(root, file, dropIn.Projects)
+	return dropIn.Projects, nil
+}
+
+// setProjectSources records where each project in the 'projects' list of
+// mapping root was defined, for error messages.
+func setProjectSources(root *yaml.Node, file string, projects []ProjectConfig) {
+	list := mappingValue(root, "projects")
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return
+	}
+	for i, item := range list.Content {
+		if i < len(projects) {
+			projects[i].source = fmt.Sprintf("%s line %d", file, item.Line)
+		}
+	}
+}
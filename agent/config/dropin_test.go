@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+const dropInMain = "version: 2\napi_gateway: {url: http://gw, token: t}\nprojects:\n  - name: web\n    match: {user: www-data}\n"
+
+func TestLoadDropIns(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"config.yaml":                  dropInMain,
+		"projects.d/20-shop.yaml":      "projects:\n  - name: shop\n    match: {systemd_unit: shop.service}\n  - name: shop-worker\n    match: {systemd_unit: shop-worker.service}\n",
+		"projects.d/10-billing.yml":    "version: 2\nprojects:\n  - name: billing\n    match: {user: billing}\n",
+		"projects.d/30-empty.yaml":     "# projects:\n#   - name: later\n",
+		"projects.d/README":            "Not a project file",
+		"projects.d/40-disabled.yaml~": "projects: [oops",
+	})
+	cfg, err := LoadConfig(filepath.Join(dir, "config.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range cfg.Projects {
+		names = append(names, p.Name)
+	}
+	if want := []string{"web", "billing", "shop", "shop-worker"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("projects = %v, want %v", names, want)
+	}
+	if cfg.ProjectsDir() != filepath.Join(dir, "projects.d") {
+		t.Errorf("ProjectsDir = %s", cfg.ProjectsDir())
+	}
+
+	// No drop-in directory at all is fine.
+	other := t.TempDir()
+	writeFiles(t, other, map[string]string{"config.yaml": dropInMain})
+	if cfg, err := LoadConfig(filepath.Join(other, "config.yaml")); err != nil || len(cfg.Projects) != 1 {
+		t.Errorf("without projects.d: %v", err)
+	}
+}
+
+func TestLoadDropInsErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  []string
+	}{
+		{
+			name:  "duplicate across files",
+			files: map[string]string{"projects.d/10-a.yaml": "projects:\n  - name: web\n    match: {user: nginx}\n"},
+			want:  []string{"project web is defined twice (", "config.yaml line 4, ", "10-a.yaml line 2)"},
+		},
+		{
+			name: "same match rules",
+			files: map[string]string{
+				"projects.d/10-a.yaml": "projects:\n  - name: a\n    match: {systemd_unit: a.service}\n",
+				"projects.d/20-b.yaml": "projects:\n\n  - name: b\n    match:\n      systemd_unit: a.service\n",
+			},
+			want: []string{"project b has the same match rules as project a and would never match (", "10-a.yaml line 2, ", "20-b.yaml line 3)"},
+		},
+		{
+			name:  "unknown key",
+			files: map[string]string{"projects.d/10-a.yaml": "groups: []\nprojects:\n  - name: a\n    match: {usr: a}\n"},
+			want:  []string{"invalid project file ", "10-a.yaml:\nline 1: unknown key \"groups\"\nline 4: unknown key \"usr\" in projects[0].match"},
+		},
+		{
+			name:  "custom dir",
+			files: map[string]string{"config.yaml": dropInMain + "agent_settings: {projects_dir: teams}\n", "teams/a.yaml": "projects:\n  - name: web\n    match: {user: a}\n"},
+			want:  []string{"project web is defined twice", "teams/a.yaml line 2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, map[string]string{"config.yaml": dropInMain})
+			writeFiles(t, dir, tt.files)
+			_, err := LoadConfig(filepath.Join(dir, "config.yaml"))
+			for _, want := range tt.want {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("error %v lacks %q", err, want)
+				}
+			}
+		})
+	}
+}
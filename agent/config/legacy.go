@@ -0,0 +1,255 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Configuration schema versions. Version 1 is the format of the first agent
+// releases (config.example.yaml before version 2): projects as a map from name
+// to project, a top-level 'interval' and 'systemd_service' match rules.
+const (
+	LegacyVersion  = 1
+	CurrentVersion = 2
+)
+
+// documentVersion returns the schema version of a parsed configuration file.
+// Files without a 'version' key are version 1 if they have the version 1
+// shape (a 'projects' map or a top-level 'interval') and current otherwise.
+func documentVersion(doc *yaml.Node) (int, error) {
+	root := documentRoot(doc)
+	if root == nil {
+		return CurrentVersion, nil
+	}
+	if value := mappingValue(root, "version"); value != nil {
+		version, err := strconv.Atoi(value.Value)
+		if err != nil || value.Kind != yaml.ScalarNode {
+			return 0, fmt.Errorf("line %d: version must be a number, got %q", value.Line, value.Value)
+		}
+		if version < LegacyVersion || version > CurrentVersion {
+			return 0, fmt.Errorf("line %d: unsupported configuration version %d, this agent reads versions %d to %d", value.Line, version, LegacyVersion, CurrentVersion)
+		}
+		return version, nil
+	}
+	if projects := mappingValue(root, "projects"); projects != nil && projects.Kind == yaml.MappingNode {
+		return LegacyVersion, nil
+	}
+	if mappingValue(root, "interval") != nil {
+		return LegacyVersion, nil
+	}
+	return CurrentVersion, nil
+}
+
+// upgradeLegacy rewrites a version 1 document into the current schema in
+// place, keeping its comments. It returns one deprecation warning per
+// converted setting. Settings without a current equivalent are an error.
+func upgradeLegacy(doc *yaml.Node) ([]string, error) {
+	root := documentRoot(doc)
+	if root == nil {
+		return nil, fmt.Errorf("expected a mapping at the top of the file")
+	}
+	var warnings []string
+
+	if value := mappingValue(root, "version"); value != nil {
+		value.Value = strconv.Itoa(CurrentVersion)
+	} else {
+		key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+		if doc.HeadComment == "" && len(root.Content) > 0 { // A header comment without a blank line below it
+			key.HeadComment, root.Content[0].HeadComment = root.Content[0].HeadComment, ""
+		}
+		root.Content = append([]*yaml.Node{key, {Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(CurrentVersion)}}, root.Content...)
+	}
+
+	if i := mappingIndex(root, "interval"); i >= 0 {
+		key, value := root.Content[i], root.Content[i+1]
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+		settings := mappingValue(root, "agent_settings")
+		if settings == nil {
+			settings = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			settingsKey := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "agent_settings", HeadComment: key.HeadComment}
+			root.Content = append(root.Content[:i], append([]*yaml.Node{settingsKey, settings}, root.Content[i:]...)...)
+			key.HeadComment = ""
+		}
+		if mappingValue(settings, "collection_interval") != nil {
+			warnings = append(warnings, fmt.Sprintf("line %d: interval is deprecated and ignored, agent_settings.collection_interval is set", key.Line))
+		} else {
+			key.Value = "collection_interval"
+			settings.Content = append(settings.Content, key, value)
+			warnings = append(warnings, fmt.Sprintf("line %d: interval is deprecated, use agent_settings.collection_interval", key.Line))
+		}
+	}
+
+	projects := mappingValue(root, "projects")
+	if projects == nil || projects.Kind != yaml.MappingNode {
+		return warnings, nil
+	}
+	warnings = append(warnings, fmt.Sprintf("line %d: projects as a map from name to project is deprecated, use a list of projects with 'name'; the first matching project wins, in file order", projects.Line))
+	list := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", HeadComment: projects.HeadComment, LineComment: projects.LineComment, FootComment: projects.FootComment}
+	for i := 0; i+1 < len(projects.Content); i += 2 {
+		key, project := projects.Content[i], projects.Content[i+1]
+		if project.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("line %d: project %s must be a mapping", project.Line, key.Value)
+		}
+		if mappingValue(project, "name") != nil {
+			return nil, fmt.Errorf("line %d: project %s has a 'name' of its own in a version 1 projects map", project.Line, key.Value)
+		}
+		project.HeadComment = key.HeadComment
+		nameKey := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "name"}
+		name := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key.Value, LineComment: key.LineComment}
+		project.Content = append([]*yaml.Node{nameKey, name}, project.Content...)
+		if match := mappingValue(project, "match"); match != nil && match.Kind == yaml.MappingNode {
+			matchWarnings, err := upgradeLegacyMatch(key.Value, match)
+			if err != nil {
+				return nil, err
+			}
+			warnings = append(warnings, matchWarnings...)
+		}
+		list.Content = append(list.Content, project)
+	}
+	*projects = *list
+	return warnings, nil
+}
+
+// upgradeLegacyMatch converts the match rules of the version 1 project name in
+// place. Several criteria are moved into an all: block, which states what the
+// version 1 examples meant (e.g. systemd_service and user: both must match).
+func upgradeLegacyMatch(name string, match *yaml.Node) ([]string, error) {
+	var warnings []string
+	for i := 0; i+1 < len(match.Content); i += 2 {
+		key, value := match.Content[i], match.Content[i+1]
+		switch key.Value {
+		case "systemd_service":
+			key.Value = "systemd_unit"
+			warnings = append(warnings, fmt.Sprintf("line %d: project %s: match.systemd_service is deprecated, use match.systemd_unit", key.Line, name))
+		case "cgroup_path_pattern":
+			return nil, fmt.Errorf("line %d: project %s: match.cgroup_path_pattern is no longer supported, use systemd_unit, docker_container_name or container_name_pattern instead", key.Line, name)
+		case "process_name_pattern":
+			// Version 1 examples matched executable paths, e.g. "^/usr/local/bin/redis-server";
+			// process_name_pattern now matches the process name unless told otherwise.
+			if value.Kind == yaml.ScalarNode && strings.Contains(value.Value, "/") {
+				pattern := *value
+				pattern.LineComment = ""
+				match.Content[i+1] = &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", LineComment: value.LineComment, Content: []*yaml.Node{
+					{Kind: yaml.ScalarNode, Tag: "!!str", Value: "pattern"}, &pattern,
+					{Kind: yaml.ScalarNode, Tag: "!!str", Value: "target"}, {Kind: yaml.ScalarNode, Tag: "!!str", Value: PatternTargetExe},
+				}}
+				warnings = append(warnings, fmt.Sprintf("line %d: project %s: match.process_name_pattern %q is a path; it now matches the process name, converted to target: exe", key.Line, name, value.Value))
+			}
+		}
+	}
+	if len(match.Content) > 2 {
+		all := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		var keys []string
+		for i := 0; i+1 < len(match.Content); i += 2 {
+			keys = append(keys, match.Content[i].Value)
+			all.Content = append(all.Content, &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: match.Content[i : i+2 : i+2]})
+		}
+		match.Content = []*yaml.Node{{Kind: yaml.ScalarNode, Tag: "!!str", Value: "all"}, all}
+		warnings = append(warnings, fmt.Sprintf("line %d: project %s: match criteria %s are converted to an all: block, so every one of them must match", match.Line, name, strings.Join(keys, ", ")))
+	}
+	return warnings, nil
+}
+
+// Migrate rewrites the contents of a configuration file in the current
+// version, keeping comments. Version 1 files are converted and come with one
+// warning per converted setting; files in the current format without a
+// 'version' key get one. A file that needs no change is returned as is.
+func Migrate(data []byte) ([]byte, []string, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, nil, err
+	}
+	version, err := documentVersion(&doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	if version == CurrentVersion {
+		if root := documentRoot(&doc); root == nil || mappingValue(root, "version") != nil {
+			return data, nil, nil
+		}
+		return insertVersion(data), nil, nil
+	}
+
+	warnings, err := upgradeLegacy(&doc)
+	if err != nil {
+		return nil, nil, err
+	}
+	warnings = append(warnings, upgradeTokenReference(&doc)...)
+	warnings = append(warnings, envReferenceWarnings(&doc)...)
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, nil, err
+	}
+	return out.Bytes(), warnings, nil
+}
+
+// insertVersion adds a 'version' line below the leading comments of a file,
+// leaving the rest untouched.
+func insertVersion(data []byte) []byte {
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	offset := 0
+	for _, line := range lines {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) > 0 && trimmed[0] != '#' && !bytes.Equal(trimmed, []byte("---")) {
+			break
+		}
+		offset += len(line)
+	}
+	out := append([]byte{}, data[:offset]...)
+	out = append(out, fmt.Sprintf("version: %d\n\n", CurrentVersion)...)
+	return append(out, data[offset:]...)
+}
+
+// envReferenceWarnings lists the values that used environment variables,
+// which version 1 expanded and the current version takes literally.
+func envReferenceWarnings(node *yaml.Node) []string {
+	var warnings []string
+	if node.Kind == yaml.ScalarNode {
+		os.Expand(node.Value, func(name string) string {
+			if name != "" {
+				warnings = append(warnings, fmt.Sprintf("line %d: $%s is no longer expanded from the environment, replace it with its value", node.Line, name))
+			}
+			return ""
+		})
+	}
+	for _, child := range node.Content {
+		warnings = append(warnings, envReferenceWarnings(child)...)
+	}
+	return warnings
+}
+
+// documentRoot returns the top-level mapping of a parsed file, or nil if it is empty.
+func documentRoot(doc *yaml.Node) *yaml.Node {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil
+	}
+	return doc.Content[0]
+}
+
+// mappingIndex returns the index of key in mapping's Content, or -1.
+func mappingIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// mappingValue returns the value of key in mapping, or nil.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(mapping, key); i >= 0 {
+		return mapping.Content[i+1]
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+const legacyConfig = `# Old agent config
+api_gateway:
+  url: https://api.example.com/v1/metrics
+  token: ${TEST_AGENT_TOKEN}
+
+interval: 15
+
+projects:
+  # Checked first
+  web_app:
+    match:
+      systemd_service: web-app.service
+      user: webapp
+    plugin: plugins/web_app_metrics.py
+  cache_server:
+    match:
+      process_name_pattern: "^/usr/local/bin/redis-server"
+  backup:
+    match:
+      process_name_pattern: "^restic$"
+`
+
+func TestDocumentVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want int
+		err  bool
+	}{
+		{"legacy map", legacyConfig, LegacyVersion, false},
+		{"legacy interval only", "interval: 30\n", LegacyVersion, false},
+		{"current without version", "projects:\n  - name: a\n", CurrentVersion, false},
+		{"current", "version: 2\nprojects: []\n", CurrentVersion, false},
+		{"explicit 1", "version: 1\n", LegacyVersion, false},
+		{"empty", "", CurrentVersion, false},
+		{"newer", "version: 3\n", 0, true},
+		{"not a number", "version: two\n", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var doc yaml.Node
+			if err := yaml.Unmarshal([]byte(tt.src), &doc); err != nil {
+				t.Fatal(err)
+			}
+			got, err := documentVersion(&doc)
+			if (err != nil) != tt.err || got != tt.want {
+				t.Errorf("documentVersion = %d, %v, want %d, error %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+}
+
+func TestLoadLegacyConfig(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	t.Setenv("TEST_AGENT_TOKEN", "secret")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(legacyConfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Version != CurrentVersion || cfg.APIGateway.Token != "secret" || cfg.AgentSettings.CollectionInterval != 15 {
+		t.Errorf("version %d, token %q, interval %d", cfg.Version, cfg.APIGateway.Token, cfg.AgentSettings.CollectionInterval)
+	}
+	var names []string
+	for _, p := range cfg.Projects {
+		names = append(names, p.Name)
+	}
+	if want := []string{"web_app", "cache_server", "backup"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("projects = %v, want %v in file order", names, want)
+	}
+	want := MatchRules{All: []MatchRules{{SystemdUnit: "web-app.service"}, {User: "webapp"}}}
+	if m := cfg.Projects[0].Match; !reflect.DeepEqual(m, want) || cfg.Projects[0].Plugin != "plugins/web_app_metrics.py" {
+		t.Errorf("web_app = %+v, want match %+v", cfg.Projects[0], want)
+	}
+	if p := cfg.Projects[1].Match.ProcessNamePattern; p.Target != PatternTargetExe || !p.MatchString("/usr/local/bin/redis-server") {
+		t.Errorf("path pattern = %+v, want target exe", p)
+	}
+	if p := cfg.Projects[2].Match.ProcessNamePattern; p.Target != PatternTargetName {
+		t.Errorf("name pattern = %+v, want target name", p)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	out, warnings, err := Migrate([]byte(legacyConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"interval is deprecated", "projects as a map", "systemd_service is deprecated", "converted to target: exe", "criteria systemd_unit, user are converted to an all: block", "token ${TEST_AGENT_TOKEN} is converted to env:TEST_AGENT_TOKEN"} {
+		found := false
+		for _, w := range warnings {
+			found = found || strings.Contains(w, want)
+		}
+		if !found {
+			t.Errorf("no warning about %q in %q", want, warnings)
+		}
+	}
+	for _, want := range []string{"# Old agent config\nversion: 2", "collection_interval: 15", "# Checked first\n- name: web_app", "    all:\n    - systemd_unit: web-app.service\n    - user: webapp\n", "token: env:TEST_AGENT_TOKEN"} {
+		if !strings.Contains(string(out), want) {
+			t.Errorf("migrated file lacks %q:\n%s", want, out)
+		}
+	}
+
+	again, warnings, err := Migrate(out)
+	if err != nil || string(again) != string(out) || len(warnings) != 0 {
+		t.Errorf("migrating a migrated file changed it: %v, %q", err, warnings)
+	}
+
+	current := "# Agent Configuration\n\napi_gateway:\n  url: x\n"
+	if out, _, _ := Migrate([]byte(current)); string(out) != "# Agent Configuration\n\nversion: 2\n\napi_gateway:\n  url: x\n" {
+		t.Errorf("versionless current file migrated to:\n%s", out)
+	}
+
+	if _, _, err := Migrate([]byte("projects:\n  a:\n    match:\n      cgroup_path_pattern: docker\n")); err == nil || !strings.Contains(err.Error(), "cgroup_path_pattern") {
+		t.Errorf("cgroup_path_pattern: err = %v", err)
+	}
+}
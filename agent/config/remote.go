@@ -0,0 +1,191 @@
+package config
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultRemoteRefresh = 300                  // Seconds between two fetches of the remote project list
+	remoteCacheFile      = "remote-config.yaml" // In state_dir, next to remoteCacheFile + ".sig"
+)
+
+// RemoteConfig enables centrally managed projects: the agent fetches a
+// project list from the gateway's /config endpoint and adds it to the
+// projects of this file and projects.d. The list must carry a detached
+// Ed25519 signature by the key pinned here. The last good copy is cached in
+// state_dir, so the agent starts with it while the gateway is unreachable.
+type RemoteConfig struct {
+	PublicKey       string `yaml:"public_key"`                 // Base64 Ed25519 public key, see `agent config keygen`
+	RefreshInterval int    `yaml:"refresh_interval,omitempty"` // Seconds between fetches, default 300
+}
+
+// remoteDocument is the project list served by the gateway. Revision and
+// Nodes are signed along with the projects, so a list cannot be replayed to
+// roll a node back or be served to a node it was not published for.
+type remoteDocument struct {
+	Revision uint64          `yaml:"revision"`        // Chosen by the publisher and increased with every list, reported back once applied
+	Nodes    []string        `yaml:"nodes,omitempty"` // Node identifiers (or hostnames) the list is for; every node if empty
+	Projects []ProjectConfig `yaml:"projects"`
+}
+
+// ParseRemoteDocument checks a remote project list without its signature,
+// e.g. before signing it, and returns its revision.
+func ParseRemoteDocument(data []byte) (string, error) {
+	doc, err := parseRemoteDocument(data)
+	if err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(doc.Revision, 10), nil
+}
+
+func parseRemoteDocument(data []byte) (*remoteDocument, error) {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return nil, err
+	}
+	root := documentRoot(&node)
+	if root == nil {
+		return nil, fmt.Errorf("expected a mapping with revision and projects")
+	}
+	if errs := unknownKeys(root, reflect.TypeOf(remoteDocument{}), ""); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	var doc remoteDocument
+	if err := root.Decode(&doc); err != nil {
+		return nil, err
+	}
+	if doc.Revision == 0 {
+		return nil, fmt.Errorf("revision is required and must be a positive integer")
+	}
+	setProjectSources(root, fmt.Sprintf("remote config revision %d", doc.Revision), doc.Projects)
+	return &doc, nil
+}
+
+// verifyRemote checks the signature of a remote project list and parses it.
+func verifyRemote(data, signature []byte, publicKey string) (*remoteDocument, error) {
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("remote_config.public_key must be a base64 Ed25519 public key")
+	}
+	sig, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(signature)))
+	if err != nil || !ed25519.Verify(key, data, sig) {
+		return nil, fmt.Errorf("signature does not match remote_config.public_key")
+	}
+	return parseRemoteDocument(data)
+}
+
+// checkNode rejects a remote project list published for other nodes.
+func (d *remoteDocument) checkNode(node string) error {
+	if len(d.Nodes) == 0 || slices.Contains(d.Nodes, node) {
+		return nil
+	}
+	return fmt.Errorf("revision %d is for nodes %s, not %s", d.Revision, strings.Join(d.Nodes, ", "), node)
+}
+
+// checkRevision rejects a fetched remote project list that is older than the
+// cached one, the last applied, or that reuses its revision for different
+// content. A cached copy that does not verify is not in use and not compared.
+func (c *Config) checkRevision(d *remoteDocument, data []byte) error {
+	cached, signature, err := c.ReadRemoteCache()
+	if err != nil || cached == nil {
+		return nil
+	}
+	prev, err := verifyRemote(cached, signature, c.RemoteConfig.PublicKey)
+	if err != nil {
+		return nil
+	}
+	switch {
+	case d.Revision < prev.Revision:
+		return fmt.Errorf("revision %d is older than revision %d in use", d.Revision, prev.Revision)
+	case d.Revision == prev.Revision && !bytes.Equal(data, cached):
+		return fmt.Errorf("revision %d is in use with different content, publish changes with a higher revision", d.Revision)
+	}
+	return nil
+}
+
+// nodeName is how the gateway knows this node: agent_settings.node_identifier,
+// or the hostname.
+func (c *Config) nodeName() string {
+	if c.AgentSettings.NodeIdentifier != "" {
+		return c.AgentSettings.NodeIdentifier
+	}
+	hn, err := os.Hostname()
+	if err != nil {
+		log.Printf("Warning: Could not determine OS hostname: %v. Using 'unknown-host'.", err)
+		return "unknown-host"
+	}
+	return hn
+}
+
+// RemoteCachePath returns where the last good remote project list is kept;
+// its signature is next to it with a ".sig" suffix.
+func (c *Config) RemoteCachePath() string {
+	return filepath.Join(c.AgentSettings.StateDir, remoteCacheFile)
+}
+
+// ReadRemoteCache returns the cached remote project list and its signature,
+// or nil if there is none yet.
+func (c *Config) ReadRemoteCache() (data, signature []byte, err error) {
+	data, err = os.ReadFile(c.RemoteCachePath())
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	signature, err = os.ReadFile(c.RemoteCachePath() + ".sig")
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, signature, nil
+}
+
+// SaveRemoteCache stores a remote project list that was applied, replacing
+// the cached one. The signature is written first, so a crash in between
+// leaves a copy that fails verification instead of an unsigned one.
+func (c *Config) SaveRemoteCache(data, signature []byte) error {
+	path := c.RemoteCachePath()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state dir: %w", err)
+	}
+	for _, file := range []struct {
+		path string
+		data []byte
+	}{{path + ".sig", signature}, {path, data}} {
+		tmp := file.path + ".tmp"
+		if err := os.WriteFile(tmp, file.data, 0o600); err != nil {
+			return fmt.Errorf("failed to write %s: %w", tmp, err)
+		}
+		if err := os.Rename(tmp, file.path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoteRevision returns the revision of the remote project list in use, or ""
+// if there is none.
+func (c *Config) RemoteRevision() string {
+	return c.remoteRevision
+}
+
+// RemoteRefreshInterval returns the seconds between two fetches of the remote project list.
+func (c *Config) RemoteRefreshInterval() int {
+	if c.RemoteConfig == nil || c.RemoteConfig.RefreshInterval <= 0 {
+		return defaultRemoteRefresh
+	}
+	return c.RemoteConfig.RefreshInterval
+}
@@ -0,0 +1,115 @@
+package config
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const remoteList = "revision: 7\nprojects:\n  - name: shop\n    match: {systemd_unit: shop.service}\n"
+
+func TestLoadConfigWithRemote(t *testing.T) {
+	public, private, _ := ed25519.GenerateKey(nil)
+	sign := func(data string) []byte {
+		return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(private, []byte(data))) + "\n")
+	}
+	dir := t.TempDir()
+	mainFile := dropInMain + "agent_settings: {node_identifier: web-1, state_dir: " + filepath.Join(dir, "state") + "}\n" +
+		"remote_config: {public_key: " + base64.StdEncoding.EncodeToString(public) + "}\n"
+	writeFiles(t, dir, map[string]string{"config.yaml": mainFile})
+	path := filepath.Join(dir, "config.yaml")
+
+	cfg, err := LoadConfigWithRemote(path, []byte(remoteList), sign(remoteList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range cfg.Projects {
+		names = append(names, p.Name)
+	}
+	if want := []string{"web", "shop"}; !reflect.DeepEqual(names, want) || cfg.RemoteRevision() != "7" {
+		t.Errorf("projects = %v revision %q, want %v revision 7", names, cfg.RemoteRevision(), want)
+	}
+
+	// Without a cached copy there are no remote projects; with one, LoadConfig uses it.
+	if cfg, err := LoadConfig(path); err != nil || len(cfg.Projects) != 1 || cfg.RemoteRevision() != "" {
+		t.Errorf("without cache: %v", err)
+	}
+	if err := cfg.SaveRemoteCache([]byte(remoteList), sign(remoteList)); err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := LoadConfig(path); err != nil || len(cfg.Projects) != 2 || cfg.RemoteRevision() != "7" {
+		t.Errorf("with cache: %v", err)
+	}
+
+	// A fetched list must not be older than the cached one, nor reuse its
+	// revision for something else: a list signed earlier cannot be replayed.
+	older := strings.Replace(remoteList, "revision: 7", "revision: 6", 1)
+	if _, err := LoadConfigWithRemote(path, []byte(older), sign(older)); err == nil || !strings.Contains(err.Error(), "revision 6 is older than revision 7 in use") {
+		t.Errorf("older revision: %v", err)
+	}
+	changed := strings.Replace(remoteList, "shop.service", "shop-v2.service", 1)
+	if _, err := LoadConfigWithRemote(path, []byte(changed), sign(changed)); err == nil || !strings.Contains(err.Error(), "revision 7 is in use with different content") {
+		t.Errorf("same revision, different content: %v", err)
+	}
+	if _, err := LoadConfigWithRemote(path, []byte(remoteList), sign(remoteList)); err != nil {
+		t.Errorf("cached revision fetched again: %v", err)
+	}
+	newer := strings.Replace(changed, "revision: 7", "revision: 8", 1)
+	if cfg, err := LoadConfigWithRemote(path, []byte(newer), sign(newer)); err != nil || cfg.RemoteRevision() != "8" {
+		t.Errorf("newer revision: %v", err)
+	}
+
+	// Lists name the nodes they are for; the node identifier is signed too.
+	forNode := "nodes: [web-1, web-2]\n" + newer
+	if cfg, err := LoadConfigWithRemote(path, []byte(forNode), sign(forNode)); err != nil || len(cfg.Projects) != 2 {
+		t.Errorf("list for this node: %v", err)
+	}
+	forOther := "nodes: [db-1]\n" + newer
+	if _, err := LoadConfigWithRemote(path, []byte(forOther), sign(forOther)); err == nil || !strings.Contains(err.Error(), "revision 8 is for nodes db-1, not web-1") {
+		t.Errorf("list for another node: %v", err)
+	}
+	writeFiles(t, dir, map[string]string{"state/remote-config.yaml": forOther, "state/remote-config.yaml.sig": string(sign(forOther))})
+	if cfg, err := LoadConfig(path); err != nil || len(cfg.Projects) != 1 {
+		t.Errorf("cached list for another node: %v", err)
+	}
+
+	// A tampered cache is skipped rather than stopping the agent.
+	tampered := strings.Replace(remoteList, "shop.service", "evil.service", 1)
+	writeFiles(t, dir, map[string]string{"state/remote-config.yaml": tampered})
+	if cfg, err := LoadConfig(path); err != nil || len(cfg.Projects) != 1 {
+		t.Errorf("tampered cache: %v", err)
+	}
+
+	tests := []struct {
+		name      string
+		data      string
+		signature []byte
+		want      string
+	}{
+		{"bad signature", tampered, sign(remoteList), "signature does not match remote_config.public_key"},
+		{"no signature", remoteList, nil, "signature does not match"},
+		{"unknown key", "revision: 9\nagent_settings: {}\nprojects: []\n", sign("revision: 9\nagent_settings: {}\nprojects: []\n"), `unknown key "agent_settings"`},
+		{"no revision", "projects: []\n", sign("projects: []\n"), "revision is required"},
+		{"revision not a number", "revision: r9\nprojects: []\n", sign("revision: r9\nprojects: []\n"), "cannot unmarshal"},
+		{"conflict", "revision: 9\nprojects:\n  - name: web\n    match: {user: a}\n", sign("revision: 9\nprojects:\n  - name: web\n    match: {user: a}\n"),
+			"project web is defined twice (" + path + " line 4, remote config revision 9 line 3)"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfigWithRemote(path, []byte(tt.data), tt.signature)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error %v lacks %q", err, tt.want)
+			}
+		})
+	}
+
+	other := t.TempDir()
+	writeFiles(t, other, map[string]string{"config.yaml": dropInMain + "remote_config: {public_key: abc}\n"})
+	if _, err := LoadConfig(filepath.Join(other, "config.yaml")); err == nil || !strings.Contains(err.Error(), "remote_config.public_key must be") {
+		t.Errorf("bad public key: %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Prefixes of a secret reference, e.g. api_gateway.token: "file:/etc/vps-agent/token".
+// A value without one of them is the secret itself.
+const (
+	secretFile        = "file:"         // Read from a file that is not readable by everyone
+	secretEnv         = "env:"          // Read from an environment variable of the agent
+	secretSystemdCred = "systemd-cred:" // Read from $CREDENTIALS_DIRECTORY, see LoadCredential= in systemd.exec(5)
+)
+
+// resolveSecret returns the secret value points to. It is called by LoadConfig,
+// so files and credentials are read again on every reload, e.g. after the
+// token was rotated.
+func resolveSecret(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, secretFile):
+		path := strings.TrimPrefix(value, secretFile)
+		if !filepath.IsAbs(path) {
+			return "", fmt.Errorf("%s: the path must be absolute", value)
+		}
+		return readSecretFile(path)
+	case strings.HasPrefix(value, secretEnv):
+		name := strings.TrimPrefix(value, secretEnv)
+		secret, ok := os.LookupEnv(name)
+		if !ok || secret == "" {
+			return "", fmt.Errorf("%s: environment variable %s is not set", value, name)
+		}
+		return secret, nil
+	case strings.HasPrefix(value, secretSystemdCred):
+		name := strings.TrimPrefix(value, secretSystemdCred)
+		if name == "" || strings.ContainsRune(name, '/') {
+			return "", fmt.Errorf("%s: invalid credential name", value)
+		}
+		dir := os.Getenv("CREDENTIALS_DIRECTORY")
+		if dir == "" {
+			return "", fmt.Errorf("%s: $CREDENTIALS_DIRECTORY is not set; the agent must run as a systemd service with LoadCredential=%s:<file>", value, name)
+		}
+		return readSecretFile(filepath.Join(dir, name))
+	}
+	return value, nil
+}
+
+// readSecretFile reads a secret from path, without the trailing newline most
+// editors add. A file other users can read is refused rather than used.
+func readSecretFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.Mode().Perm()&0o004 != 0 {
+		return "", fmt.Errorf("%s is readable by everyone (mode %04o), restrict it with chmod o-rwx", path, info.Mode().Perm())
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
+
+// upgradeTokenReference turns a version 1 api_gateway.token consisting of a
+// single environment variable, such as "${VPS_AGENT_TOKEN}", into the
+// equivalent env: reference, which is read the same way at load time.
+func upgradeTokenReference(doc *yaml.Node) []string {
+	root := documentRoot(doc)
+	if root == nil {
+		return nil
+	}
+	gateway := mappingValue(root, "api_gateway")
+	if gateway == nil || gateway.Kind != yaml.MappingNode {
+		return nil
+	}
+	token := mappingValue(gateway, "token")
+	if token == nil || token.Kind != yaml.ScalarNode {
+		return nil
+	}
+	name := strings.TrimPrefix(token.Value, "$")
+	if name == token.Value {
+		return nil
+	}
+	if strings.HasPrefix(name, "{") && strings.HasSuffix(name, "}") {
+		name = name[1 : len(name)-1]
+	}
+	for _, r := range name {
+		if !(r == '_' || r >= 'A' && r <= 'Z' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			return nil // More than a variable, e.g. "${PREFIX}-suffix"
+		}
+	}
+	if name == "" {
+		return nil
+	}
+	old := token.Value
+	token.Value = secretEnv + name
+	token.Style = 0
+	return []string{fmt.Sprintf("line %d: api_gateway.token %s is converted to %s, which reads the same variable", token.Line, old, token.Value)}
+}
@@ -0,0 +1,73 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestResolveSecret(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"token":        "file-token\n",
+		"open":         "leaked",
+		"empty":        "\n",
+		"creds/token":  "cred-token",
+		"creds/public": "leaked",
+	})
+	for name, mode := range map[string]os.FileMode{"token": 0o600, "open": 0o644, "empty": 0o600, "creds/token": 0o400, "creds/public": 0o604} {
+		if err := os.Chmod(filepath.Join(dir, name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("TEST_AGENT_TOKEN", "env-token")
+	t.Setenv("TEST_AGENT_EMPTY", "")
+	t.Setenv("CREDENTIALS_DIRECTORY", filepath.Join(dir, "creds"))
+
+	tests := []struct {
+		value string
+		want  string // The secret, or a substring of the error
+		ok    bool
+	}{
+		{"plain-token", "plain-token", true},
+		{"file:" + filepath.Join(dir, "token"), "file-token", true},
+		{"env:TEST_AGENT_TOKEN", "env-token", true},
+		{"systemd-cred:token", "cred-token", true},
+		{"file:" + filepath.Join(dir, "open"), "is readable by everyone (mode 0644)", false},
+		{"file:" + filepath.Join(dir, "empty"), "is empty", false},
+		{"file:" + filepath.Join(dir, "missing"), "no such file", false},
+		{"file:token", "must be absolute", false},
+		{"env:TEST_AGENT_EMPTY", "TEST_AGENT_EMPTY is not set", false},
+		{"env:TEST_AGENT_UNSET", "TEST_AGENT_UNSET is not set", false},
+		{"systemd-cred:public", "is readable by everyone", false},
+		{"systemd-cred:../token", "invalid credential name", false},
+	}
+	for _, tt := range tests {
+		got, err := resolveSecret(tt.value)
+		if tt.ok && (err != nil || got != tt.want) {
+			t.Errorf("resolveSecret(%q) = %q, %v, want %q", tt.value, got, err, tt.want)
+		}
+		if !tt.ok && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("resolveSecret(%q) error %v lacks %q", tt.value, err, tt.want)
+		}
+	}
+
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	if _, err := resolveSecret("systemd-cred:token"); err == nil || !strings.Contains(err.Error(), "LoadCredential=token:") {
+		t.Errorf("without $CREDENTIALS_DIRECTORY: %v", err)
+	}
+
+	// LoadConfig reads the token on every load, so a reload picks up a rotated one.
+	cfg, err := loadString(t, "version: 2\napi_gateway: {url: http://gw, token: \"file:"+filepath.Join(dir, "token")+"\"}\n")
+	if err != nil {
+		t.Fatalf("LoadConfig: %v", err)
+	}
+	if cfg.APIGateway.Token != "file-token" {
+		t.Errorf("token = %q, want file-token", cfg.APIGateway.Token)
+	}
+	_, err = loadString(t, "version: 2\napi_gateway: {url: http://gw, token: \"file:"+filepath.Join(dir, "open")+"\"}\n")
+	if err == nil || !strings.Contains(err.Error(), "api_gateway.token "+filepath.Join(dir, "open")+" is readable by everyone") {
+		t.Errorf("world-readable token file: %v", err)
+	}
+}
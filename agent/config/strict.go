@@ -0,0 +1,89 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// renamedKeys are keys of version 1 files that have a different name now.
+// Found in a current file, the error says which key to use instead.
+var renamedKeys = map[string]string{
+	"systemd_service": "systemd_unit",
+	"interval":        "agent_settings.collection_interval",
+}
+
+// unknownKeys returns an error, with its line, for every mapping key in node
+// that does not correspond to a field of t. path names node in the errors,
+// e.g. "projects[0].match"; it is "" for the top level.
+func unknownKeys(node *yaml.Node, t reflect.Type, path string) []error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	var errs []error
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Value == "<<" { // Merge key; the merged mapping is checked where it is defined
+				continue
+			}
+			field, ok := fields[key.Value]
+			if !ok {
+				err := fmt.Sprintf("line %d: unknown key %q", key.Line, key.Value)
+				if path != "" {
+					err += " in " + path
+				}
+				if renamed, ok := renamedKeys[key.Value]; ok {
+					err += fmt.Sprintf(" (did you mean %s?)", renamed)
+				}
+				errs = append(errs, fmt.Errorf("%s", err))
+				continue
+			}
+			errs = append(errs, unknownKeys(value, field.Type, joinPath(path, key.Value))...)
+		}
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			errs = append(errs, unknownKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			errs = append(errs, unknownKeys(node.Content[i+1], t.Elem(), joinPath(path, node.Content[i].Value))...)
+		}
+	}
+	return errs
+}
+
+// yamlFields returns the exported fields of struct type t by their YAML key.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		switch name {
+		case "-":
+			continue
+		case "":
+			name = strings.ToLower(field.Name) // yaml.v3's default key
+		}
+		fields[name] = field
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
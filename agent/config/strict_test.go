@@ -0,0 +1,117 @@
+package config
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func loadString(t *testing.T, src string) (*Config, error) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	const header = "version: 2\napi_gateway: {url: http://gw, token: t}\n"
+	tests := []struct {
+		name string
+		src  string
+		errs []string // Substrings of the error, one per expected problem
+	}{
+		{"misplaced criterion", "projects:\n  - name: a\n    match: {user: a}\n    process_name_pattern: x\n", []string{`line 6: unknown key "process_name_pattern" in projects[0]`}},
+		{"renamed key", "projects:\n  - name: a\n    match:\n      systemd_service: a.service\n", []string{`line 6: unknown key "systemd_service" in projects[0].match (did you mean systemd_unit?)`}},
+		{"top level", "interval: 30\nprojects: []\n", []string{`line 3: unknown key "interval" (did you mean agent_settings.collection_interval?)`}},
+		{"nested", "projects:\n  - name: a\n    match:\n      any:\n        - {user: a, usr: b}\n    logs: {files: [x], rules: [{name: e, patern: e}]}\n", []string{
+			`line 7: unknown key "usr" in projects[0].match.any[0]`,
+			`line 8: unknown key "patern" in projects[0].logs.rules[0]`,
+		}},
+		{"pattern mapping", "projects:\n  - name: a\n    match:\n      process_name_pattern: {pattern: x, taget: exe}\n", []string{`unknown key "taget" in projects[0].match.process_name_pattern`}},
+		{"semantic", "projects:\n  - name: a\n    match: {user: a}\n  - name: a\n    match: {user: b}\n  - name: b\n    match: {}\n  - match: {exe: \"[\"}\n", []string{
+			"project a is defined twice",
+			"project b has no match rules",
+			"projects[3].name is required",
+			"invalid match.exe",
+		}},
+		{"reserved names", "projects:\n  - name: _agent\n    match: {user: a}\n  - name: _groups\n    match: {user: b}\n  - name: _system\n    match: {user: c}\n", []string{
+			"project name _agent is reserved",
+			"project name _groups is reserved",
+			"project name _system is reserved",
+		}},
+		{"access log fields", "access_logs:\n  - files: [x]\n    project: a\n    extra_fields: [host, \"-\", request_tme]\n    json_fields: {status: s, latency: t}\n", []string{
+			`access_logs[0].extra_fields[2] must be status, request_time, request_time_us, host, upstream or -, got "request_tme"`,
+			`access_logs[0].json_fields: unknown field "latency"`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := loadString(t, header+tt.src)
+			for _, want := range tt.errs {
+				if err == nil || !strings.Contains(err.Error(), want) {
+					t.Errorf("error %v lacks %q", err, want)
+				}
+			}
+		})
+	}
+
+	if _, err := loadString(t, ""); err == nil {
+		t.Errorf("empty config file accepted")
+	}
+	cfg, err := loadString(t, header+"agent_settings: {collection_interval: 10}\nprojects:\n  - name: a\n    match: {user: a}\n")
+	if err != nil || len(cfg.Projects) != 1 {
+		t.Fatalf("valid config: %v", err)
+	}
+}
+
+func TestCheckPlugins(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "ok.sh")
+	data := filepath.Join(dir, "data.txt")
+	os.WriteFile(script, []byte("#!/bin/sh\necho {}\n"), 0o755)
+	os.WriteFile(data, []byte("x"), 0o644)
+
+	cfg := &Config{Projects: []ProjectConfig{
+		{Name: "ok", Plugin: script},
+		{Name: "none"},
+		{Name: "missing", Plugin: filepath.Join(dir, "missing.py")},
+		{Name: "noexec", Plugin: data},
+		{Name: "dir", Plugin: dir + "/"},
+	}}
+	var got []string
+	for _, err := range cfg.CheckPlugins() {
+		got = append(got, err.Error())
+	}
+	if len(got) != 3 || !strings.HasPrefix(got[0], "project missing:") || !strings.Contains(got[1], "not executable") || !strings.Contains(got[2], "not a regular file") {
+		t.Errorf("CheckPlugins = %q", got)
+	}
+
+	if p := (ProjectConfig{Plugin: "sample_plugin.py"}).PluginPath(); p != filepath.Join("plugins", "sample_plugin.py") {
+		t.Errorf("bare plugin name resolved to %s", p)
+	}
+	if p := (ProjectConfig{Plugin: "plugins/sample_plugin.py"}).PluginPath(); p != "plugins/sample_plugin.py" {
+		t.Errorf("plugin path resolved to %s", p)
+	}
+}
+
+func TestBarePluginNameDeprecated(t *testing.T) {
+	var logged strings.Builder
+	log.SetOutput(&logged)
+	defer log.SetOutput(os.Stderr)
+	cfg, err := loadString(t, "version: 2\napi_gateway: {url: http://gw, token: t}\nprojects:\n"+
+		"  - name: a\n    match: {user: a}\n    plugin: a.py\n"+
+		"  - name: b\n    match: {user: b}\n    plugin: plugins/b.py\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := logged.String(); strings.Count(got, "deprecated") != 1 || !strings.Contains(got, `project a: plugin "a.py" is looked up in plugins/; bare plugin names are deprecated, write plugin: plugins/a.py`) {
+		t.Errorf("warnings:\n%s", got)
+	}
+	if p := cfg.Projects[0].PluginPath(); p != filepath.Join("plugins", "a.py") {
+		t.Errorf("plugin a runs from %s", p)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"vps-screener/agent/config"
+)
+
+// runConfig implements `agent config <command>`.
+func runConfig(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			return runMigrate(args[1:])
+		case "keygen":
+			return runKeygen(args[1:])
+		case "sign":
+			return runSign(args[1:])
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: %s config migrate [-c config.yaml] [-n]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s config keygen [-o remote-config.key]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s config sign [-k remote-config.key] projects.yaml\n", os.Args[0])
+	return 2
+}
+
+// runMigrate implements `agent config migrate [-c config.yaml] [-n]`: it
+// rewrites a configuration file in the current version, keeping the original
+// next to it as <file>.bak.
+func runMigrate(args []string) int {
+	fs := flag.NewFlagSet("config migrate", flag.ContinueOnError)
+	configPath := fs.String("c", defaultConfigPath(), "Path to the agent configuration")
+	dryRun := fs.Bool("n", false, "Print the migrated configuration instead of rewriting the file")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s config migrate [-c config.yaml] [-n]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Converts a configuration file to version %d, printing a warning per converted setting.\n", config.CurrentVersion)
+		fmt.Fprintf(fs.Output(), "The original is kept as <file>.bak. Comments are kept; formatting may change.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	data, err := os.ReadFile(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config migrate: %v\n", err)
+		return 1
+	}
+	migrated, warnings, err := config.Migrate(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config migrate: cannot convert %s: %v\n", *configPath, err)
+		return 1
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "config migrate: %s\n", warning)
+	}
+	if bytes.Equal(migrated, data) {
+		fmt.Printf("%s is already at version %d\n", *configPath, config.CurrentVersion)
+		return 0
+	}
+	if *dryRun {
+		os.Stdout.Write(migrated)
+		return 0
+	}
+
+	info, err := os.Stat(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config migrate: %v\n", err)
+		return 1
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(*configPath), filepath.Base(*configPath)+".migrate-*")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config migrate: %v\n", err)
+		return 1
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(migrated)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), info.Mode().Perm())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config migrate: %v\n", err)
+		return 1
+	}
+	// Check the result like the agent would load it before replacing anything.
+	if _, err := config.LoadConfig(tmp.Name()); err != nil {
+		fmt.Fprintf(os.Stderr, "config migrate: the migrated configuration does not load, %s is unchanged: %v\n", *configPath, err)
+		return 1
+	}
+
+	backup := *configPath + ".bak"
+	if err := os.WriteFile(backup, data, info.Mode().Perm()); err != nil {
+		fmt.Fprintf(os.Stderr, "config migrate: failed to write backup: %v\n", err)
+		return 1
+	}
+	if err := os.Rename(tmp.Name(), *configPath); err != nil {
+		fmt.Fprintf(os.Stderr, "config migrate: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Migrated %s to version %d, the original is in %s\n", *configPath, config.CurrentVersion, backup)
+	return 0
+}
+
+// runKeygen implements `agent config keygen [-o remote-config.key]`: it
+// creates the Ed25519 key pair remote project lists are signed with. The
+// private key stays with whoever publishes the lists; the public key goes into
+// remote_config.public_key on every node.
+func runKeygen(args []string) int {
+	fs := flag.NewFlagSet("config keygen", flag.ContinueOnError)
+	keyPath := fs.String("o", "remote-config.key", "Where to write the private key")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s config keygen [-o remote-config.key]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Creates a key pair for signing remote project lists and prints the public key.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config keygen: %v\n", err)
+		return 1
+	}
+	// O_EXCL: never overwrite a key that lists may already be signed with.
+	f, err := os.OpenFile(*keyPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config keygen: %v\n", err)
+		return 1
+	}
+	_, err = fmt.Fprintln(f, base64.StdEncoding.EncodeToString(private))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config keygen: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Private key written to %s. Add the public key to the agents' configuration:\n\n", *keyPath)
+	fmt.Printf("remote_config:\n  public_key: %s\n", base64.StdEncoding.EncodeToString(public))
+	return 0
+}
+
+// runSign implements `agent config sign [-k remote-config.key] projects.yaml`:
+// it checks a remote project list and writes its signature to projects.yaml.sig,
+// to be published to the gateway together with the list.
+func runSign(args []string) int {
+	fs := flag.NewFlagSet("config sign", flag.ContinueOnError)
+	keyPath := fs.String("k", "remote-config.key", "Private key from 'config keygen'")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s config sign [-k remote-config.key] projects.yaml\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Checks a remote project list (revision, optional nodes and projects) and writes its signature to <file>.sig.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	path := fs.Arg(0)
+
+	encoded, err := os.ReadFile(*keyPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config sign: %v\n", err)
+		return 1
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		fmt.Fprintf(os.Stderr, "config sign: %s is not a key from 'config keygen'\n", *keyPath)
+		return 1
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config sign: %v\n", err)
+		return 1
+	}
+	revision, err := config.ParseRemoteDocument(data)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "config sign: invalid project list %s:\n%v\n", path, err)
+		return 1
+	}
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(ed25519.PrivateKey(key), data))
+	if err := os.WriteFile(path+".sig", []byte(signature+"\n"), 0o644); err != nil {
+		fmt.Fprintf(os.Stderr, "config sign: %v\n", err)
+		return 1
+	}
+	fmt.Printf("Signed revision %s of %s, signature in %s.sig\n", revision, path, path)
+	return 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/elastic/go-sysinfo"
+
+	"vps-screener/agent/config"
+	"vps-screener/agent/mapper"
+)
+
+// runExplain implements `agent explain [-c config.yaml] [pid]`: it shows how
+// processes are mapped to projects, rule by rule, without sending anything.
+func runExplain(args []string) int {
+	fs := flag.NewFlagSet("explain", flag.ContinueOnError)
+	configPath := fs.String("c", defaultConfigPath(), "Path to the agent configuration")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s explain [-c config.yaml] [pid]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Prints the rules evaluated for each process (or only pid), the observed values and the\n")
+		fmt.Fprintf(fs.Output(), "project that won, followed by the projects that match no process on this host.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	pid := 0
+	switch fs.NArg() {
+	case 0:
+	case 1:
+		n, err := strconv.Atoi(fs.Arg(0))
+		if err != nil || n <= 0 {
+			fmt.Fprintf(os.Stderr, "explain: invalid PID %q\n", fs.Arg(0))
+			return 2
+		}
+		pid = n
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "explain: failed to load configuration from %s: %v\n", *configPath, err)
+		return 1
+	}
+	processes, err := sysinfo.Processes()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "explain: failed to list processes: %v\n", err)
+		return 1
+	}
+
+	log.SetOutput(io.Discard) // The report covers what the mapper would log
+	if err := mapper.Explain(os.Stdout, processes, cfg, pid); err != nil {
+		fmt.Fprintf(os.Stderr, "explain: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// captureStdout returns what fn writes to os.Stdout.
+func captureStdout(t *testing.T, fn func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	done := make(chan string)
+	go func() {
+		out, _ := io.ReadAll(r)
+		done <- string(out)
+	}()
+	fn()
+	os.Stdout = stdout
+	w.Close()
+	return <-done
+}
+
+func TestRunExplain(t *testing.T) {
+	defer log.SetOutput(os.Stderr)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, "version: 2\napi_gateway: {url: http://gw, token: t}\nprojects:\n"+
+		"  - name: tests\n    match: {process_name_pattern: {pattern: '\\.test$', target: exe}}\n"+
+		"  - name: nothing\n    match: {systemd_unit: no-such-unit.service}\n")
+
+	// The test binary itself, by PID: rule outcomes, the winning project and
+	// the projects matching no process.
+	pid := os.Getpid()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var code int
+	out := captureStdout(t, func() { code = runExplain([]string{"-c", path, strconv.Itoa(pid)}) })
+	if code != 0 {
+		t.Fatalf("explain %d: exit code %d, output:\n%s", pid, code, out)
+	}
+	for _, want := range []string{
+		"PID " + strconv.Itoa(pid) + " (",
+		"  exe:          " + exe + "\n",
+		"  project tests: match\n",
+		"  project nothing: no match\n",
+		"  => tests (first matching project)\n",
+		"Projects matching none of ",
+		"  nothing\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("explain output lacks %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "PID "); n != 1 {
+		t.Errorf("%d processes explained, want 1", n)
+	}
+
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"unknown PID", []string{"-c", path, "2147483647"}, 1},
+		{"invalid PID", []string{"-c", path, "web"}, 2},
+		{"two PIDs", []string{"-c", path, "1", "2"}, 2},
+		{"missing config", []string{"-c", path + ".missing"}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			captureStdout(t, func() { code = runExplain(tt.args) })
+			if code != tt.want {
+				t.Errorf("exit code %d, want %d", code, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,241 @@
+package filecheck
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"vps-screener/agent/config"
+)
+
+// Check statuses reported in Result.Status.
+const (
+	StatusOK               = "ok"
+	StatusMissing          = "missing"
+	StatusStale            = "stale"
+	StatusTooSmall         = "too_small"
+	StatusChecksumMissing  = "checksum_missing"
+	StatusChecksumMismatch = "checksum_mismatch"
+	StatusError            = "error"
+)
+
+// Result is the outcome of a single file check, reported in the project's metrics.
+type Result struct {
+	Name       string  `json:"name"`
+	File       string  `json:"file,omitempty"` // Newest file matching the configured path
+	Status     string  `json:"status"`
+	AgeSeconds float64 `json:"age_seconds,omitempty"`
+	SizeBytes  int64   `json:"size_bytes,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+// checksumEntry records the verification result for one version of a file.
+// Backups are often large, so we only hash a file again when it or its sidecar changes.
+type checksumEntry struct {
+	size     int64
+	modTime  time.Time
+	expected string
+	match    bool
+}
+
+var (
+	checksumCache      = make(map[string]checksumEntry) // Keyed by file path
+	checksumCacheMutex = &sync.Mutex{}
+)
+
+// Run evaluates all checks and returns one Result per check, in order.
+func Run(checks []config.FileCheck) []Result {
+	results := make([]Result, 0, len(checks))
+	now := time.Now()
+	for _, fc := range checks {
+		results = append(results, runCheck(fc, now))
+	}
+	pruneChecksumCache(checks, results)
+	return results
+}
+
+// pruneChecksumCache drops the cached checksums of files the checks no longer
+// pick, e.g. older backups once a newer one is written, so the cache does not
+// grow with every rotation. Run is called per project, so entries matching none
+// of the checks passed in belong to another project and are kept.
+func pruneChecksumCache(checks []config.FileCheck, results []Result) {
+	seen := make(map[string]bool, len(results))
+	for _, res := range results {
+		if res.File != "" {
+			seen[res.File] = true
+		}
+	}
+	checksumCacheMutex.Lock()
+	defer checksumCacheMutex.Unlock()
+	maps.DeleteFunc(checksumCache, func(path string, _ checksumEntry) bool {
+		if seen[path] {
+			return false
+		}
+		for _, fc := range checks {
+			if ok, _ := filepath.Match(fc.Path, path); ok {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func runCheck(fc config.FileCheck, now time.Time) Result {
+	res := Result{Name: fc.Name}
+	if res.Name == "" {
+		res.Name = fc.Path
+	}
+
+	path, info, err := newestMatch(fc)
+	if err != nil {
+		res.Status = StatusError
+		res.Error = err.Error()
+		return res
+	}
+	if info == nil {
+		res.Status = StatusMissing
+		return res
+	}
+
+	res.File = path
+	res.SizeBytes = info.Size()
+	age := now.Sub(info.ModTime())
+	if age < 0 {
+		age = 0 // Clock skew or a file written in the future; treat as brand new
+	}
+	res.AgeSeconds = age.Seconds()
+
+	switch {
+	case age > fc.MaxAge:
+		res.Status = StatusStale
+	case info.Size() < fc.MinSize:
+		res.Status = StatusTooSmall
+	default:
+		res.Status = StatusOK
+	}
+	if res.Status != StatusOK || fc.Checksum == "" {
+		return res
+	}
+
+	status, err := verifyChecksum(path, info, fc.Checksum)
+	if err != nil {
+		log.Printf("filecheck: checksum verification failed for %s: %v", path, err)
+		res.Error = err.Error()
+	}
+	res.Status = status
+	return res
+}
+
+// newestMatch returns the most recently modified regular file matching fc.Path.
+// A nil FileInfo means nothing matched. Checksum sidecars are never candidates.
+func newestMatch(fc config.FileCheck) (string, os.FileInfo, error) {
+	matches, err := filepath.Glob(fc.Path)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid path pattern %q: %w", fc.Path, err)
+	}
+
+	var newestPath string
+	var newestInfo os.FileInfo
+	for _, m := range matches {
+		if fc.Checksum != "" && strings.HasSuffix(m, "."+fc.Checksum) {
+			continue
+		}
+		info, err := os.Stat(m)
+		if err != nil || !info.Mode().IsRegular() {
+			continue // Removed between glob and stat, or a directory
+		}
+		if newestInfo == nil || info.ModTime().After(newestInfo.ModTime()) {
+			newestPath, newestInfo = m, info
+		}
+	}
+	return newestPath, newestInfo, nil
+}
+
+// verifyChecksum compares the file's digest with the first field of its
+// "<file>.<algo>" sidecar, the format written by sha256sum and md5sum.
+func verifyChecksum(path string, info os.FileInfo, algo string) (string, error) {
+	sidecar := path + "." + algo
+	expected, err := readSidecar(sidecar)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return StatusChecksumMissing, nil
+		}
+		return StatusError, err
+	}
+
+	expected = strings.ToLower(expected)
+	checksumCacheMutex.Lock()
+	entry, found := checksumCache[path]
+	checksumCacheMutex.Unlock()
+
+	if !found || entry.size != info.Size() || !entry.modTime.Equal(info.ModTime()) || entry.expected != expected {
+		actual, err := fileDigest(path, algo)
+		if err != nil {
+			return StatusError, err
+		}
+		entry = checksumEntry{size: info.Size(), modTime: info.ModTime(), expected: expected, match: actual == expected}
+		checksumCacheMutex.Lock()
+		checksumCache[path] = entry
+		checksumCacheMutex.Unlock()
+	}
+
+	if !entry.match {
+		return StatusChecksumMismatch, nil
+	}
+	return StatusOK, nil
+}
+
+func readSidecar(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return "", fmt.Errorf("error reading checksum file %s: %w", path, err)
+		}
+		return "", fmt.Errorf("checksum file %s is empty", path)
+	}
+	fields := strings.Fields(scanner.Text())
+	if len(fields) == 0 {
+		return "", fmt.Errorf("checksum file %s is empty", path)
+	}
+	return fields[0], nil
+}
+
+func fileDigest(path string, algo string) (string, error) {
+	var h hash.Hash
+	switch algo {
+	case "sha256":
+		h = sha256.New()
+	case "md5":
+		h = md5.New()
+	default:
+		return "", fmt.Errorf("unsupported checksum algorithm %q", algo)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("error opening %s: %w", path, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(h, file); err != nil {
+		return "", fmt.Errorf("error hashing %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,152 @@
+package filecheck
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"vps-screener/agent/config"
+)
+
+var now = time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+
+// writeFile creates path with data, last modified age before now.
+func writeFile(t *testing.T, path, data string, age time.Duration) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func sha256Hex(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestRunCheck(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	dir := t.TempDir()
+	backup := "backup contents"
+	writeFile(t, filepath.Join(dir, "db-1.sql.gz"), "old backup, now stale", 72*time.Hour)
+	writeFile(t, filepath.Join(dir, "db-2.sql.gz"), backup, 2*time.Hour)
+	writeFile(t, filepath.Join(dir, "db-2.sql.gz.sha256"), sha256Hex(backup)+"  db-2.sql.gz\n", time.Minute) // Newer, but never a candidate
+	if err := os.Mkdir(filepath.Join(dir, "db-3.sql.gz"), 0o755); err != nil {                               // Directories are skipped
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(dir, "bad.tar"), "tampered", time.Hour)
+	writeFile(t, filepath.Join(dir, "bad.tar.sha256"), sha256Hex("original")+"\n", time.Hour)
+	writeFile(t, filepath.Join(dir, "nosidecar.tar"), "data", time.Hour)
+	writeFile(t, filepath.Join(dir, "upper.iso"), "image", time.Hour)
+	md5sum := md5.Sum([]byte("image"))
+	writeFile(t, filepath.Join(dir, "upper.iso.md5"), strings.ToUpper(hex.EncodeToString(md5sum[:]))+" *upper.iso\n", time.Hour)
+	writeFile(t, filepath.Join(dir, "empty.tar"), "data", time.Hour)
+	writeFile(t, filepath.Join(dir, "empty.tar.sha256"), "\n", time.Hour)
+
+	tests := []struct {
+		name   string
+		check  config.FileCheck
+		status string
+		file   string // Base name of the reported file
+	}{
+		{"newest glob match", config.FileCheck{Path: filepath.Join(dir, "db-*.sql.gz"), MaxAge: 26 * time.Hour}, StatusOK, "db-2.sql.gz"},
+		{"stale", config.FileCheck{Path: filepath.Join(dir, "db-*.sql.gz"), MaxAge: time.Hour}, StatusStale, "db-2.sql.gz"},
+		{"single stale file", config.FileCheck{Path: filepath.Join(dir, "db-1.sql.gz"), MaxAge: 26 * time.Hour}, StatusStale, "db-1.sql.gz"},
+		{"min_size reached", config.FileCheck{Path: filepath.Join(dir, "db-*.sql.gz"), MaxAge: 26 * time.Hour, MinSize: int64(len(backup))}, StatusOK, "db-2.sql.gz"},
+		{"too_small", config.FileCheck{Path: filepath.Join(dir, "db-*.sql.gz"), MaxAge: 26 * time.Hour, MinSize: 1 << 20}, StatusTooSmall, "db-2.sql.gz"},
+		{"stale wins over too_small", config.FileCheck{Path: filepath.Join(dir, "db-*.sql.gz"), MaxAge: time.Hour, MinSize: 1 << 20}, StatusStale, "db-2.sql.gz"},
+		{"checksum ok, sidecar not a candidate", config.FileCheck{Path: filepath.Join(dir, "db-*"), MaxAge: 26 * time.Hour, Checksum: "sha256"}, StatusOK, "db-2.sql.gz"},
+		{"checksum mismatch", config.FileCheck{Path: filepath.Join(dir, "bad.tar"), MaxAge: 26 * time.Hour, Checksum: "sha256"}, StatusChecksumMismatch, "bad.tar"},
+		{"missing sidecar", config.FileCheck{Path: filepath.Join(dir, "nosidecar.tar"), MaxAge: 26 * time.Hour, Checksum: "sha256"}, StatusChecksumMissing, "nosidecar.tar"},
+		{"md5 sidecar in upper case", config.FileCheck{Path: filepath.Join(dir, "upper.iso"), MaxAge: 26 * time.Hour, Checksum: "md5"}, StatusOK, "upper.iso"},
+		{"empty sidecar", config.FileCheck{Path: filepath.Join(dir, "empty.tar"), MaxAge: 26 * time.Hour, Checksum: "sha256"}, StatusError, "empty.tar"},
+		{"nothing matches", config.FileCheck{Path: filepath.Join(dir, "*.bak"), MaxAge: time.Hour}, StatusMissing, ""},
+		{"invalid pattern", config.FileCheck{Path: filepath.Join(dir, "[")}, StatusError, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := runCheck(tt.check, now)
+			file := ""
+			if res.File != "" {
+				file = filepath.Base(res.File)
+			}
+			if res.Status != tt.status || file != tt.file {
+				t.Errorf("status %s, file %q (%s), want %s, %q", res.Status, file, res.Error, tt.status, tt.file)
+			}
+			if res.Name != tt.check.Path {
+				t.Errorf("name = %q, want the path", res.Name)
+			}
+		})
+	}
+
+	res := runCheck(config.FileCheck{Name: "nightly", Path: filepath.Join(dir, "db-*.sql.gz"), MaxAge: 26 * time.Hour}, now)
+	if res.Name != "nightly" || res.SizeBytes != int64(len(backup)) || res.AgeSeconds != (2*time.Hour).Seconds() {
+		t.Errorf("result = %+v", res)
+	}
+}
+
+func TestChecksumCache(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "backup.tar")
+	writeFile(t, path, "v1", time.Hour)
+	writeFile(t, path+".sha256", sha256Hex("v1"), time.Hour)
+	check := config.FileCheck{Path: path, MaxAge: 26 * time.Hour, Checksum: "sha256"}
+	if res := runCheck(check, now); res.Status != StatusOK {
+		t.Fatalf("status = %s", res.Status)
+	}
+
+	// A new version of the file with the old sidecar is hashed again.
+	writeFile(t, path, "v2", 30*time.Minute)
+	if res := runCheck(check, now); res.Status != StatusChecksumMismatch {
+		t.Errorf("after the file changed: %s", res.Status)
+	}
+	// So is the same file once its sidecar is updated.
+	writeFile(t, path+".sha256", sha256Hex("v2"), time.Minute)
+	if res := runCheck(check, now); res.Status != StatusOK {
+		t.Errorf("after the sidecar changed: %s", res.Status)
+	}
+}
+
+func TestChecksumCachePrune(t *testing.T) {
+	dir := t.TempDir()
+	backup := func(name, data string, age time.Duration) {
+		writeFile(t, filepath.Join(dir, name), data, age)
+		writeFile(t, filepath.Join(dir, name+".sha256"), sha256Hex(data), age)
+	}
+	cached := func(name string) bool {
+		checksumCacheMutex.Lock()
+		defer checksumCacheMutex.Unlock()
+		_, found := checksumCache[filepath.Join(dir, name)]
+		return found
+	}
+	backups := []config.FileCheck{{Path: filepath.Join(dir, "db-*.tar"), MaxAge: 24 * 365 * time.Hour, Checksum: "sha256"}}
+	other := []config.FileCheck{{Path: filepath.Join(dir, "site.tar"), MaxAge: 24 * 365 * time.Hour, Checksum: "sha256"}}
+
+	backup("db-1.tar", "v1", 2*time.Hour)
+	backup("site.tar", "site", 2*time.Hour)
+	Run(backups)
+	Run(other) // Another project's checks
+	if !cached("db-1.tar") || !cached("site.tar") {
+		t.Fatalf("checksums not cached")
+	}
+
+	// A newer backup replaces the older one in the cache; the other project's
+	// entry stays although this Run did not see it.
+	backup("db-2.tar", "v2", time.Hour)
+	if res := Run(backups); res[0].File != filepath.Join(dir, "db-2.tar") || res[0].Status != StatusOK {
+		t.Fatalf("results = %+v", res)
+	}
+	if cached("db-1.tar") || !cached("db-2.tar") || !cached("site.tar") {
+		t.Errorf("after rotation: db-1 %v, db-2 %v, site %v cached, want only db-2 and site", cached("db-1.tar"), cached("db-2.tar"), cached("site.tar"))
+	}
+}
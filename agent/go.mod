@@ -1,16 +1,23 @@
 module vps-screener/agent
 
-go 1.21 // Or your desired Go version
+go 1.22 // Or your desired Go version
 
 require (
 	github.com/elastic/go-sysinfo v1.11.1 // For system metrics
 	github.com/gorilla/websocket v1.5.1 // For WebSocket communication
 )
 
+require (
+	github.com/fsnotify/fsnotify v1.9.0
+	github.com/klauspost/compress v1.18.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
+)
+
 require (
 	github.com/elastic/go-windows v1.0.0 // indirect
 	github.com/joeshaw/multierror v0.0.0-20140124173710-69b34d4ec901 // indirect
 	github.com/pkg/errors v0.9.1 // indirect
-	github.com/prometheus/procfs v0.0.0-20190425082905-87a4384522e0 // indirect
-	golang.org/x/sys v0.0.0-20191026070338-33540a1f6037 // indirect
-) 
\ No newline at end of file
+	github.com/prometheus/procfs v0.8.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
+	howett.net/plist v0.0.0-20181124034731-591f970eefbb // indirect
+)
@@ -0,0 +1,412 @@
+package journal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Minimal read-only parser for the systemd journal file format, see
+// https://systemd.io/JOURNAL_FILE_FORMAT/. It only walks the global entry
+// array and decodes the fields of each entry; hash tables are not used.
+
+const (
+	headerSignature = "LPKSHHRH"
+
+	incompatibleCompressedXZ   = 1 << 0
+	incompatibleCompressedLZ4  = 1 << 1
+	incompatibleKeyedHash      = 1 << 2
+	incompatibleCompressedZSTD = 1 << 3
+	incompatibleCompact        = 1 << 4
+	incompatibleSupported      = incompatibleCompressedXZ | incompatibleCompressedLZ4 | incompatibleKeyedHash | incompatibleCompressedZSTD | incompatibleCompact
+
+	objectData       = 1
+	objectEntry      = 3
+	objectEntryArray = 6
+
+	objectCompressedXZ   = 1 << 0
+	objectCompressedLZ4  = 1 << 1
+	objectCompressedZSTD = 1 << 2
+	objectCompressedMask = objectCompressedXZ | objectCompressedLZ4 | objectCompressedZSTD
+
+	objectHeaderSize     = 16
+	entryObjectFixedSize = 64 // Object header + seqnum, realtime, monotonic, boot_id, xor_hash
+	entryArrayFixedSize  = 24 // Object header + next_entry_array_offset
+	dataPayloadRegular   = 64
+	dataPayloadCompact   = 72
+
+	maxDataPayload       = 64 * 1024 // Larger fields are truncated
+	maxCompressedPayload = 4 << 20   // Larger compressed fields are not decoded
+
+	// undecodedMessage stands in for a MESSAGE that could not be decoded, so
+	// the entry is still counted.
+	undecodedMessage = "[message not decoded: unsupported compression]"
+)
+
+// zstdDecoder decompresses ZSTD data objects, the default since systemd 246.
+// DecodeAll is safe for concurrent use.
+var zstdDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1), zstd.WithDecoderMaxMemory(maxCompressedPayload))
+
+var errCorruptLZ4 = errors.New("corrupt LZ4 block")
+
+// fileHeader holds the header fields the reader needs.
+type fileHeader struct {
+	incompatibleFlags uint32
+	seqnumID          string
+	tailEntrySeqnum   uint64
+	entryArrayOffset  uint64
+	tailEntryRealtime uint64
+	headerSize        uint64
+	arenaSize         uint64
+	compact           bool
+}
+
+// entry is a decoded journal entry with the fields the agent cares about.
+type entry struct {
+	seqnumID string
+	seqnum   uint64
+	realtime uint64 // Microseconds since the epoch
+	fields   map[string]string
+}
+
+// journalFile is an open journal file. Data objects are cached by offset since
+// many entries share them (e.g. the same _SYSTEMD_UNIT).
+type journalFile struct {
+	path      string
+	file      *os.File
+	header    fileHeader
+	dataCache map[uint64]dataField
+	undecoded int // Fields of the entries read so far that could not be decompressed
+}
+
+type dataField struct {
+	name      string
+	value     string
+	ok        bool // False for malformed objects and fields that could not be decompressed
+	undecoded bool // A compressed field that could not be decompressed, e.g. XZ
+}
+
+func openJournalFile(path string) (*journalFile, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening journal file %s: %w", path, err)
+	}
+	jf := &journalFile{path: path, file: file, dataCache: make(map[uint64]dataField)}
+	if err := jf.readHeader(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return jf, nil
+}
+
+func (jf *journalFile) close() {
+	jf.file.Close()
+}
+
+func (jf *journalFile) readHeader() error {
+	buf := make([]byte, 208)
+	if _, err := jf.file.ReadAt(buf, 0); err != nil {
+		return fmt.Errorf("error reading journal header of %s: %w", jf.path, err)
+	}
+	if string(buf[0:8]) != headerSignature {
+		return fmt.Errorf("%s is not a journal file", jf.path)
+	}
+	h := fileHeader{
+		incompatibleFlags: binary.LittleEndian.Uint32(buf[12:16]),
+		seqnumID:          hex.EncodeToString(buf[72:88]),
+		headerSize:        binary.LittleEndian.Uint64(buf[88:96]),
+		arenaSize:         binary.LittleEndian.Uint64(buf[96:104]),
+		tailEntrySeqnum:   binary.LittleEndian.Uint64(buf[160:168]),
+		entryArrayOffset:  binary.LittleEndian.Uint64(buf[176:184]),
+		tailEntryRealtime: binary.LittleEndian.Uint64(buf[192:200]),
+	}
+	if unknown := h.incompatibleFlags &^ incompatibleSupported; unknown != 0 {
+		return fmt.Errorf("journal file %s uses unsupported features (flags %#x)", jf.path, unknown)
+	}
+	h.compact = h.incompatibleFlags&incompatibleCompact != 0
+	jf.header = h
+	return nil
+}
+
+func (jf *journalFile) readAt(size uint64, offset uint64) ([]byte, error) {
+	if offset < jf.header.headerSize || offset+size > jf.header.headerSize+jf.header.arenaSize {
+		return nil, fmt.Errorf("object at %d outside of journal file %s", offset, jf.path)
+	}
+	buf := make([]byte, size)
+	if _, err := jf.file.ReadAt(buf, int64(offset)); err != nil {
+		return nil, fmt.Errorf("error reading journal file %s at %d: %w", jf.path, offset, err)
+	}
+	return buf, nil
+}
+
+// objectHeader returns the type, flags and size of the object at offset.
+func (jf *journalFile) objectHeader(offset uint64) (uint8, uint8, uint64, error) {
+	buf, err := jf.readAt(objectHeaderSize, offset)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	return buf[0], buf[1], binary.LittleEndian.Uint64(buf[8:16]), nil
+}
+
+// entriesAfter calls fn for every entry whose seqnum is greater than after,
+// in file order. Older entries are skipped without being decoded.
+func (jf *journalFile) entriesAfter(after uint64, fn func(entry)) error {
+	itemSize := uint64(8)
+	if jf.header.compact {
+		itemSize = 4
+	}
+
+	for arrayOffset := jf.header.entryArrayOffset; arrayOffset != 0; {
+		typ, _, size, err := jf.objectHeader(arrayOffset)
+		if err != nil {
+			return err
+		}
+		if typ != objectEntryArray || size < entryArrayFixedSize {
+			return fmt.Errorf("journal file %s: expected entry array at %d", jf.path, arrayOffset)
+		}
+		buf, err := jf.readAt(size, arrayOffset)
+		if err != nil {
+			return err
+		}
+		next := binary.LittleEndian.Uint64(buf[16:24])
+
+		var offsets []uint64
+		for pos := uint64(entryArrayFixedSize); pos+itemSize <= size; pos += itemSize {
+			var off uint64
+			if jf.header.compact {
+				off = uint64(binary.LittleEndian.Uint32(buf[pos:]))
+			} else {
+				off = binary.LittleEndian.Uint64(buf[pos:])
+			}
+			if off == 0 {
+				break // Unused tail of the last array
+			}
+			offsets = append(offsets, off)
+		}
+
+		// Entries are ordered by seqnum, so binary search for the first new one.
+		var searchErr error
+		first := sort.Search(len(offsets), func(i int) bool {
+			seqnum, err := jf.entrySeqnum(offsets[i])
+			if err != nil && searchErr == nil {
+				searchErr = err
+			}
+			return err != nil || seqnum > after
+		})
+		if searchErr != nil {
+			return searchErr
+		}
+		for _, off := range offsets[first:] {
+			e, err := jf.readEntry(off, after)
+			if err != nil {
+				return err
+			}
+			if e != nil {
+				fn(*e)
+			}
+		}
+		arrayOffset = next
+	}
+	return nil
+}
+
+func (jf *journalFile) entrySeqnum(offset uint64) (uint64, error) {
+	buf, err := jf.readAt(24, offset)
+	if err != nil {
+		return 0, err
+	}
+	if buf[0] != objectEntry {
+		return 0, fmt.Errorf("journal file %s: expected entry at %d", jf.path, offset)
+	}
+	return binary.LittleEndian.Uint64(buf[16:24]), nil
+}
+
+// readEntry decodes the entry at offset, or returns nil if it is not newer than after.
+func (jf *journalFile) readEntry(offset uint64, after uint64) (*entry, error) {
+	typ, _, size, err := jf.objectHeader(offset)
+	if err != nil {
+		return nil, err
+	}
+	if typ != objectEntry || size < entryObjectFixedSize {
+		return nil, fmt.Errorf("journal file %s: expected entry at %d", jf.path, offset)
+	}
+	buf, err := jf.readAt(size, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &entry{
+		seqnumID: jf.header.seqnumID,
+		seqnum:   binary.LittleEndian.Uint64(buf[16:24]),
+		realtime: binary.LittleEndian.Uint64(buf[24:32]),
+		fields:   make(map[string]string),
+	}
+	if e.seqnum <= after {
+		return nil, nil
+	}
+
+	itemSize := uint64(16)
+	if jf.header.compact {
+		itemSize = 4
+	}
+	for pos := uint64(entryObjectFixedSize); pos+itemSize <= size; pos += itemSize {
+		var dataOffset uint64
+		if jf.header.compact {
+			dataOffset = uint64(binary.LittleEndian.Uint32(buf[pos:]))
+		} else {
+			dataOffset = binary.LittleEndian.Uint64(buf[pos:])
+		}
+		field, err := jf.readData(dataOffset)
+		if err != nil {
+			return nil, err
+		}
+		if field.ok {
+			e.fields[field.name] = field.value
+		}
+		if field.undecoded {
+			jf.undecoded++
+			if _, ok := e.fields["MESSAGE"]; !ok {
+				e.fields["MESSAGE"] = undecodedMessage
+			}
+		}
+	}
+	return e, nil
+}
+
+// readData decodes a "NAME=value" data object. journald compresses fields
+// larger than its compression threshold (512 bytes by default) with ZSTD or,
+// before systemd 246, LZ4; both are decompressed. XZ, used by releases
+// before 2014, is not, and neither are fields over maxCompressedPayload.
+func (jf *journalFile) readData(offset uint64) (dataField, error) {
+	if field, ok := jf.dataCache[offset]; ok {
+		return field, nil
+	}
+
+	typ, flags, size, err := jf.objectHeader(offset)
+	if err != nil {
+		return dataField{}, err
+	}
+	payloadStart := uint64(dataPayloadRegular)
+	if jf.header.compact {
+		payloadStart = dataPayloadCompact
+	}
+	if typ != objectData || size < payloadStart {
+		return dataField{}, fmt.Errorf("journal file %s: expected data object at %d", jf.path, offset)
+	}
+
+	var field dataField
+	length := size - payloadStart
+	compression := flags & objectCompressedMask
+	switch {
+	case compression == 0 && length > maxDataPayload:
+		length = maxDataPayload
+	case compression != 0 && (length > maxCompressedPayload || compression&objectCompressedXZ != 0):
+		field.undecoded = true
+		jf.dataCache[offset] = field
+		return field, nil
+	}
+	payload, err := jf.readAt(length, offset+payloadStart)
+	if err != nil {
+		return dataField{}, err
+	}
+	switch compression {
+	case objectCompressedZSTD:
+		payload, err = zstdDecoder.DecodeAll(payload, nil)
+	case objectCompressedLZ4:
+		payload, err = decodeLZ4(payload)
+	}
+	if err != nil {
+		field.undecoded = true
+		jf.dataCache[offset] = field
+		return field, nil
+	}
+	if len(payload) > maxDataPayload {
+		payload = payload[:maxDataPayload]
+	}
+	if eq := bytes.IndexByte(payload, '='); eq > 0 {
+		field = dataField{name: string(payload[:eq]), value: string(payload[eq+1:]), ok: true}
+	}
+	jf.dataCache[offset] = field
+	return field, nil
+}
+
+// decodeLZ4 decompresses an LZ4 data object: the uncompressed size as a
+// little-endian uint64, followed by one LZ4 block.
+func decodeLZ4(payload []byte) ([]byte, error) {
+	if len(payload) < 8 {
+		return nil, errCorruptLZ4
+	}
+	size := binary.LittleEndian.Uint64(payload)
+	if size > maxCompressedPayload {
+		return nil, fmt.Errorf("LZ4 field of %d bytes is too large", size)
+	}
+	return decodeLZ4Block(payload[8:], int(size))
+}
+
+// decodeLZ4Block decompresses an LZ4 block (the raw format, no frame) of
+// exactly size bytes. Each sequence is a token, literals and a back reference
+// into the output; the last one has literals only.
+func decodeLZ4Block(src []byte, size int) ([]byte, error) {
+	dst := make([]byte, 0, size)
+	length := func(n int, i *int) (int, error) {
+		if n != 15 {
+			return n, nil
+		}
+		for {
+			if *i >= len(src) {
+				return 0, errCorruptLZ4
+			}
+			b := src[*i]
+			*i++
+			n += int(b)
+			if b != 255 {
+				return n, nil
+			}
+		}
+	}
+	for i := 0; i < len(src); {
+		token := src[i]
+		i++
+		literals, err := length(int(token>>4), &i)
+		if err != nil {
+			return nil, err
+		}
+		if literals > len(src)-i || literals > size-len(dst) {
+			return nil, errCorruptLZ4
+		}
+		dst = append(dst, src[i:i+literals]...)
+		i += literals
+		if i == len(src) {
+			break
+		}
+
+		if i+2 > len(src) {
+			return nil, errCorruptLZ4
+		}
+		offset := int(src[i]) | int(src[i+1])<<8
+		i += 2
+		match, err := length(int(token&15), &i)
+		if err != nil {
+			return nil, err
+		}
+		match += 4
+		if offset == 0 || offset > len(dst) || match > size-len(dst) {
+			return nil, errCorruptLZ4
+		}
+		// Byte by byte: the match may overlap the bytes it produces.
+		for start := len(dst) - offset; match > 0; match-- {
+			dst = append(dst, dst[start])
+			start++
+		}
+	}
+	if len(dst) != size {
+		return nil, errCorruptLZ4
+	}
+	return dst, nil
+}
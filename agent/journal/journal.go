@@ -0,0 +1,354 @@
+package journal
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"vps-screener/agent/config"
+	"vps-screener/agent/logwatch"
+)
+
+const (
+	stateFileName = "journal.json"
+	maxErrorLine  = 512 // Forwarded error lines are truncated to keep the payload small
+)
+
+// defaultJournalDirs are searched when agent_settings.journal_dir is not set.
+var defaultJournalDirs = []string{"/var/log/journal", "/run/log/journal"}
+
+// priorityNames maps syslog priorities (0-7) to the names journalctl uses.
+var priorityNames = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// Report summarises the journal messages of a project's systemd unit for one tick.
+type Report struct {
+	Unit       string              `json:"unit"`
+	Messages   int64               `json:"messages"`
+	Priorities map[string]int64    `json:"priorities"`         // Priority name -> messages this tick
+	Counters   map[string]int64    `json:"counters,omitempty"` // Same rules as the project's log files
+	Samples    map[string][]string `json:"samples,omitempty"`
+	ErrorLines []string            `json:"error_lines,omitempty"` // Last N messages with priority err or worse
+}
+
+// cursor is the persisted position of the last processed entry. Seqnums are
+// only comparable within the same seqnum ID; across IDs we fall back to time.
+type cursor struct {
+	SeqnumID string `json:"seqnum_id"`
+	Seqnum   uint64 `json:"seqnum"`
+	Realtime uint64 `json:"realtime"`
+}
+
+// Entry is a journal message as seen by the agent.
+type Entry struct {
+	Unit     string
+	Priority int // 0 (emerg) to 7 (debug), or -1 if the message has none
+	Message  string
+	Time     time.Time
+
+	pos cursor // Position of the entry itself, see Reader.Commit
+}
+
+// Reader returns journal entries written since its previous call. Its cursor
+// is persisted to a state file by Commit, so restarts resume where they left off.
+type Reader struct {
+	dirs      []string
+	statePath string
+	cursor    *cursor
+	mu        sync.Mutex
+}
+
+// NewReader creates a Reader over the journal files in dirs (searched directly
+// and per machine ID) whose cursor is stored in statePath.
+func NewReader(dirs []string, statePath string) *Reader {
+	return &Reader{dirs: dirs, statePath: statePath, cursor: loadCursor(statePath)}
+}
+
+// Dirs returns the journal directories configured for the agent.
+func Dirs(cfg *config.Config) []string {
+	if cfg.AgentSettings.JournalDir != "" {
+		return []string{cfg.AgentSettings.JournalDir}
+	}
+	return defaultJournalDirs
+}
+
+// Read returns the entries written since the previous call, oldest first.
+// On the very first run (no persisted cursor) it returns nothing and starts
+// following from the current end of the journal. The new position is only
+// persisted by Commit.
+func (r *Reader) Read() ([]Entry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries, tail, err := readEntries(journalFiles(r.dirs), r.cursor)
+	if r.cursor == nil {
+		r.cursor = tail
+		entries = nil
+	} else if len(entries) > 0 {
+		last := entries[len(entries)-1]
+		r.cursor = &cursor{SeqnumID: last.seqnumID, Seqnum: last.seqnum, Realtime: last.realtime}
+	}
+
+	result := make([]Entry, 0, len(entries))
+	for _, e := range entries {
+		priority := -1
+		if p, err := strconv.Atoi(e.fields["PRIORITY"]); err == nil && p >= 0 && p < len(priorityNames) {
+			priority = p
+		}
+		result = append(result, Entry{
+			Unit:     entryUnit(e),
+			Priority: priority,
+			Message:  e.fields["MESSAGE"],
+			Time:     time.UnixMicro(int64(e.realtime)),
+			pos:      cursor{SeqnumID: e.seqnumID, Seqnum: e.seqnum, Realtime: e.realtime},
+		})
+	}
+	return result, err
+}
+
+// Commit persists the position of the last entry returned by Read. If held
+// is not empty, it persists the position just before the oldest of those
+// entries instead, so a restart reads them again; callers pass the entries
+// they have not finished processing.
+func (r *Reader) Commit(held []Entry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	c := r.cursor
+	for _, e := range held {
+		before := cursor{SeqnumID: e.pos.SeqnumID, Seqnum: e.pos.Seqnum - 1, Realtime: e.pos.Realtime - 1}
+		if c == nil || before.Realtime < c.Realtime || before.Realtime == c.Realtime && before.Seqnum < c.Seqnum {
+			c = &before
+		}
+	}
+	if c == nil {
+		return
+	}
+	if err := saveCursor(r.statePath, c); err != nil {
+		log.Printf("journal: failed to persist cursor: %v", err)
+	}
+}
+
+var (
+	reader      *Reader
+	readerMutex = &sync.Mutex{}
+)
+
+// projectReader accumulates a Report for one project.
+type projectReader struct {
+	report        Report
+	matcher       *logwatch.Matcher
+	forwardErrors int
+}
+
+// Collect reads the journal entries written since the previous call and
+// returns a Report for every project with a `journal` section.
+func Collect(cfg *config.Config) map[string]Report {
+	readerMutex.Lock()
+	defer readerMutex.Unlock()
+
+	readers := make(map[string]*projectReader)
+	byUnit := make(map[string][]*projectReader)
+	for _, proj := range cfg.Projects {
+		if proj.Journal == nil || proj.Match.SystemdUnit == "" {
+			continue
+		}
+		r := &projectReader{
+			report: Report{
+				Unit:       proj.Match.SystemdUnit,
+				Priorities: make(map[string]int64, len(priorityNames)),
+			},
+			forwardErrors: proj.Journal.ForwardErrors,
+		}
+		for _, name := range priorityNames {
+			r.report.Priorities[name] = 0
+		}
+		if proj.Logs != nil && len(proj.Logs.Rules) > 0 {
+			r.matcher = logwatch.NewMatcher(proj.Logs.Rules, proj.Logs.Samples)
+		}
+		readers[proj.Name] = r
+		byUnit[proj.Match.SystemdUnit] = append(byUnit[proj.Match.SystemdUnit], r)
+	}
+	if len(readers) == 0 {
+		return nil
+	}
+
+	statePath := filepath.Join(cfg.AgentSettings.StateDir, stateFileName)
+	dirs := Dirs(cfg)
+	if reader == nil || reader.statePath != statePath || !sameDirs(reader.dirs, dirs) {
+		reader = NewReader(dirs, statePath)
+	}
+	entries, err := reader.Read()
+	if err != nil {
+		log.Printf("journal: %v", err)
+	}
+	for _, e := range entries {
+		for _, r := range byUnit[e.Unit] {
+			r.add(e)
+		}
+	}
+	reader.Commit(nil)
+
+	reports := make(map[string]Report, len(readers))
+	for name, r := range readers {
+		if r.matcher != nil {
+			lr := r.matcher.Report()
+			r.report.Counters = lr.Counters
+			r.report.Samples = lr.Samples
+		}
+		reports[name] = r.report
+	}
+	return reports
+}
+
+func sameDirs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *projectReader) add(e Entry) {
+	r.report.Messages++
+	if e.Priority >= 0 {
+		r.report.Priorities[priorityNames[e.Priority]]++
+	}
+	if r.matcher != nil {
+		r.matcher.Line(e.Message)
+	}
+	if r.forwardErrors > 0 && e.Priority >= 0 && e.Priority <= 3 {
+		message := e.Message
+		if len(message) > maxErrorLine {
+			message = message[:maxErrorLine]
+		}
+		r.report.ErrorLines = append(r.report.ErrorLines, message)
+		if len(r.report.ErrorLines) > r.forwardErrors {
+			r.report.ErrorLines = r.report.ErrorLines[1:]
+		}
+	}
+}
+
+// entryUnit returns the unit an entry belongs to, using the same fields as `journalctl -u`:
+// messages from the unit itself, and messages about the unit from systemd.
+func entryUnit(e entry) string {
+	for _, field := range []string{"_SYSTEMD_UNIT", "UNIT", "OBJECT_SYSTEMD_UNIT"} {
+		if unit := e.fields[field]; unit != "" {
+			return unit
+		}
+	}
+	return ""
+}
+
+// journalFiles lists journal files directly in each dir and in its
+// per-machine-id subdirectories, e.g. /var/log/journal/<machine-id>/system.journal.
+func journalFiles(dirs []string) []string {
+	var files []string
+	for _, dir := range dirs {
+		for _, pattern := range []string{"*.journal", "*/*.journal"} {
+			matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+			files = append(files, matches...)
+		}
+	}
+	return files
+}
+
+// readEntries returns the entries after c from all files, oldest first, and a
+// cursor pointing at the newest entry found in any file header.
+func readEntries(paths []string, c *cursor) ([]entry, *cursor, error) {
+	var entries []entry
+	var tail *cursor
+	var firstErr error
+	for _, path := range paths {
+		jf, err := openJournalFile(path)
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		h := jf.header
+		if tail == nil || h.tailEntryRealtime > tail.Realtime {
+			tail = &cursor{SeqnumID: h.seqnumID, Seqnum: h.tailEntrySeqnum, Realtime: h.tailEntryRealtime}
+		}
+		if c == nil {
+			jf.close()
+			continue
+		}
+
+		var after uint64
+		if h.seqnumID == c.SeqnumID {
+			if h.tailEntrySeqnum <= c.Seqnum {
+				jf.close()
+				continue
+			}
+			after = c.Seqnum
+		} else if h.tailEntryRealtime <= c.Realtime {
+			jf.close()
+			continue
+		}
+		err = jf.entriesAfter(after, func(e entry) {
+			if e.seqnumID != c.SeqnumID && e.realtime <= c.Realtime {
+				return
+			}
+			entries = append(entries, e)
+		})
+		if jf.undecoded > 0 {
+			log.Printf("journal: %s: %d compressed fields could not be decoded", path, jf.undecoded)
+		}
+		jf.close()
+		if err != nil && firstErr == nil {
+			// Online files are written concurrently; keep what we read so far.
+			firstErr = err
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].realtime != entries[j].realtime {
+			return entries[i].realtime < entries[j].realtime
+		}
+		return entries[i].seqnum < entries[j].seqnum
+	})
+	return entries, tail, firstErr
+}
+
+func loadCursor(path string) *cursor {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("journal: could not read cursor from %s: %v", path, err)
+		}
+		return nil
+	}
+	var c cursor
+	if err := json.Unmarshal(data, &c); err != nil {
+		log.Printf("journal: ignoring corrupt cursor file %s: %v", path, err)
+		return nil
+	}
+	return &c
+}
+
+// saveCursor writes the cursor atomically, so a crash never leaves a half-written file.
+func saveCursor(path string, c *cursor) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return fmt.Errorf("failed to marshal cursor: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,289 @@
+package logship
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"vps-screener/agent/config"
+	"vps-screener/agent/journal"
+	"vps-screener/agent/logwatch"
+)
+
+const (
+	defaultBatchSize  = 500
+	maxBatchesPerTick = 20 // Delivered per tick, so a long backlog does not stall the agent loop
+)
+
+// Batch is the payload POSTed to the gateway's /logs endpoint.
+type Batch struct {
+	NodeHostname string   `json:"node_hostname"`
+	Timestamp    int64    `json:"timestamp"` // Unix seconds when the batch was created
+	Records      []Record `json:"records"`
+}
+
+// rejectedError marks a batch the gateway refused for good (e.g. 400, 413).
+type rejectedError struct {
+	status string
+}
+
+func (e *rejectedError) Error() string {
+	return fmt.Sprintf("API gateway rejected log batch with status %s", e.status)
+}
+
+// shipper holds the state that must survive between ticks: read offsets,
+// the journal cursor, partially joined multiline records and the disk queue.
+// Read positions are only persisted once the records read up to them are
+// queued, so a crash or a failed write means reading them again, not losing them.
+type shipper struct {
+	stateDir string
+	follower *logwatch.Follower
+	journal  *journal.Reader
+	joiners  map[string]*joiner // Keyed by project name
+	queue    *diskQueue
+	unqueued []Record // Records a previous tick failed to queue
+}
+
+var (
+	current      *shipper
+	shipperMutex = &sync.Mutex{}
+)
+
+func newShipper(cfg *config.Config) *shipper {
+	dir := filepath.Join(cfg.AgentSettings.StateDir, "logship")
+	return &shipper{
+		stateDir: cfg.AgentSettings.StateDir,
+		follower: logwatch.NewFollower(filepath.Join(dir, "offsets.json")),
+		journal:  journal.NewReader(journal.Dirs(cfg), filepath.Join(dir, "journal.json")),
+		joiners:  make(map[string]*joiner),
+		queue:    newDiskQueue(filepath.Join(dir, "queue"), cfg.AgentSettings.LogShipping.QueueMaxBytes),
+	}
+}
+
+// Ship reads new log lines from every project's ship_logs sources, joins and
+// redacts them, queues them on disk in batches and delivers as many queued
+// batches as possible to the API gateway. Undelivered batches stay queued
+// for the next tick. While records cannot be queued (e.g. the disk is full),
+// no new lines are read.
+func Ship(cfg *config.Config) {
+	shipperMutex.Lock()
+	defer shipperMutex.Unlock()
+
+	var projects []config.ProjectConfig
+	journalUnits := make(map[string][]config.ProjectConfig)
+	for _, proj := range cfg.Projects {
+		if proj.ShipLogs == nil {
+			continue
+		}
+		projects = append(projects, proj)
+		if proj.ShipLogs.Journal {
+			journalUnits[proj.Match.SystemdUnit] = append(journalUnits[proj.Match.SystemdUnit], proj)
+		}
+	}
+
+	if current == nil || current.stateDir != cfg.AgentSettings.StateDir {
+		if len(projects) == 0 {
+			return // Nothing configured and nothing can be queued from a previous run
+		}
+		current = newShipper(cfg)
+	}
+	s := current
+	s.queue.maxBytes = cfg.AgentSettings.LogShipping.QueueMaxBytes
+	if s.queue.maxBytes <= 0 {
+		s.queue.maxBytes = defaultQueueMaxBytes
+	}
+
+	if len(s.unqueued) > 0 {
+		var err error
+		if s.unqueued, err = s.enqueue(cfg, s.unqueued); err != nil {
+			log.Printf("logship: %v", err)
+		}
+	}
+	if len(s.unqueued) == 0 {
+		records := s.read(projects, journalUnits)
+		var err error
+		if s.unqueued, err = s.enqueue(cfg, records); err != nil {
+			log.Printf("logship: %v", err)
+		} else {
+			s.commit()
+		}
+	}
+
+	sent, err := s.queue.flush(maxBatchesPerTick, func(data []byte) error { return sendBatch(cfg, data) })
+	if err != nil {
+		log.Printf("logship: delivery failed, %d batch(es) stay queued: %v", s.queue.pending(), err)
+	} else if sent > 0 {
+		log.Printf("logship: delivered %d log batch(es)", sent)
+	}
+}
+
+// read returns the records completed since the previous tick.
+func (s *shipper) read(projects []config.ProjectConfig, journalUnits map[string][]config.ProjectConfig) []Record {
+	var records []Record
+	emit := func(rec Record) {
+		rec.Message = truncateRecord(rec.Message)
+		records = append(records, rec)
+	}
+
+	now := time.Now().UnixMilli()
+	for _, proj := range projects {
+		proj := proj
+		j := s.joiner(proj.Name)
+		if len(proj.ShipLogs.Files) > 0 {
+			s.follower.Poll(proj.Name, proj.ShipLogs.Files, func(path, line string, pos logwatch.Position) {
+				rec := Record{Timestamp: now, Project: proj.Name, Source: "file:" + path, Message: line, origin: origin{path: path, pos: pos}}
+				j.add(proj.ShipLogs.Multiline, rec, func(r Record) { emit(s.finish(proj, r)) })
+			})
+		}
+	}
+
+	if len(journalUnits) > 0 {
+		entries, err := s.journal.Read()
+		if err != nil {
+			log.Printf("logship: %v", err)
+		}
+		for i, e := range entries {
+			for _, proj := range journalUnits[e.Unit] {
+				proj := proj
+				priority := e.Priority
+				rec := Record{Timestamp: e.Time.UnixMilli(), Project: proj.Name, Source: "journal:" + e.Unit, Message: e.Message, origin: origin{entry: &entries[i]}}
+				if priority >= 0 {
+					rec.Priority = &priority
+				}
+				s.joiner(proj.Name).add(proj.ShipLogs.Multiline, rec, func(r Record) { emit(s.finish(proj, r)) })
+			}
+		}
+	}
+
+	for _, proj := range projects {
+		proj := proj
+		s.joiner(proj.Name).flushIdle(func(r Record) { emit(s.finish(proj, r)) })
+	}
+	// Drop joiners of projects that no longer ship logs.
+	for name := range s.joiners {
+		if !shipsLogs(projects, name) {
+			delete(s.joiners, name)
+		}
+	}
+	return records
+}
+
+// commit persists the read positions of the files and the journal, but not
+// past the first line of a multiline record that is still being joined: that
+// record only exists in memory, so a restart must read its lines again.
+func (s *shipper) commit() {
+	var held []journal.Entry
+	for project, j := range s.joiners {
+		for _, p := range j.pending {
+			switch o := p.record.origin; {
+			case o.entry != nil:
+				held = append(held, *o.entry)
+			case o.path != "":
+				s.follower.Hold(project, o.path, o.pos)
+			}
+		}
+	}
+	s.follower.Commit()
+	s.journal.Commit(held)
+}
+
+func (s *shipper) joiner(project string) *joiner {
+	j, ok := s.joiners[project]
+	if !ok {
+		j = newJoiner()
+		s.joiners[project] = j
+	}
+	return j
+}
+
+// finish applies the project's redaction rules to a completed record.
+func (s *shipper) finish(proj config.ProjectConfig, rec Record) Record {
+	rec.Message = redact(proj.ShipLogs.Redact, rec.Message)
+	return rec
+}
+
+func shipsLogs(projects []config.ProjectConfig, name string) bool {
+	for _, proj := range projects {
+		if proj.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// enqueue splits records into batches and stores them in the disk queue. On
+// error it returns the records that were not queued.
+func (s *shipper) enqueue(cfg *config.Config, records []Record) ([]Record, error) {
+	batchSize := cfg.AgentSettings.LogShipping.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	hostname := getHostname(cfg)
+	for len(records) > 0 {
+		n := batchSize
+		if n > len(records) {
+			n = len(records)
+		}
+		data, err := json.Marshal(Batch{NodeHostname: hostname, Timestamp: time.Now().Unix(), Records: records[:n]})
+		if err != nil {
+			return records, fmt.Errorf("failed to marshal log batch: %w", err)
+		}
+		if err := s.queue.push(data); err != nil {
+			return records, err
+		}
+		records = records[n:]
+	}
+	return nil, nil
+}
+
+func getHostname(cfg *config.Config) string {
+	nodeHostname := cfg.AgentSettings.NodeIdentifier
+	if nodeHostname == "" {
+		hn, err := os.Hostname()
+		if err != nil {
+			log.Printf("Warning: Could not determine OS hostname: %v. Using 'unknown-host'.", err)
+			return "unknown-host"
+		}
+		return hn
+	}
+	return nodeHostname
+}
+
+// sendBatch POSTs one encoded batch to the gateway's /logs endpoint.
+func sendBatch(cfg *config.Config, data []byte) error {
+	client := &http.Client{
+		Timeout: 15 * time.Second,
+	}
+
+	logsEndpoint := fmt.Sprintf("%s/logs", cfg.APIGateway.URL)
+	req, err := http.NewRequest("POST", logsEndpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create new HTTP request to %s: %w", logsEndpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.APIGateway.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request to %s: %w", logsEndpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	switch resp.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		log.Printf("Log batch rejected by %s. Status: %s, Body: %s", logsEndpoint, resp.Status, string(bodyBytes))
+		return &rejectedError{status: resp.Status}
+	}
+	return fmt.Errorf("API gateway at %s returned error status %s", logsEndpoint, resp.Status)
+}
@@ -0,0 +1,286 @@
+package logship
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"vps-screener/agent/config"
+)
+
+// gateway is a stand-in for the API gateway's /logs endpoint.
+type gateway struct {
+	mu       sync.Mutex
+	status   func(Batch) int // Response status for a batch; 200 if nil
+	requests int
+	batches  []Batch // Accepted batches
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.requests++
+	var batch Batch
+	if r.URL.Path != "/v1/logs" || r.Header.Get("Authorization") != "Bearer secret" || json.NewDecoder(r.Body).Decode(&batch) != nil {
+		w.WriteHeader(http.StatusTeapot)
+		return
+	}
+	status := http.StatusOK
+	if g.status != nil {
+		status = g.status(batch)
+	}
+	if status == http.StatusOK {
+		g.batches = append(g.batches, batch)
+	}
+	w.WriteHeader(status)
+}
+
+// messages returns the messages of the accepted batches, one slice per batch.
+func (g *gateway) messages() [][]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var all [][]string
+	for _, b := range g.batches {
+		var messages []string
+		for _, rec := range b.Records {
+			messages = append(messages, rec.Message)
+		}
+		all = append(all, messages)
+	}
+	return all
+}
+
+// setup starts a gateway and returns a config shipping the files matching
+// dir/*.log of project "shop", and the log file dir/app.log.
+func setup(t *testing.T, g *gateway) (*config.Config, string) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	current = nil
+	server := httptest.NewServer(g)
+	t.Cleanup(server.Close)
+
+	dir := t.TempDir()
+	cfg := &config.Config{
+		APIGateway: config.APIGatewaySettings{URL: server.URL + "/v1", Token: "secret"},
+		AgentSettings: config.AgentSettings{
+			StateDir:       filepath.Join(dir, "state"),
+			JournalDir:     filepath.Join(dir, "journal"),
+			NodeIdentifier: "node-1",
+		},
+		Projects: []config.ProjectConfig{{
+			Name:     "shop",
+			ShipLogs: &config.ShipLogs{Files: []string{filepath.Join(dir, "*.log")}},
+		}},
+	}
+	path := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(path, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	Ship(cfg) // Starts following app.log at its end
+	return cfg, path
+}
+
+func appendLines(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for _, line := range lines {
+		if _, err := fmt.Fprintln(f, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// committedOffset returns the offset of path persisted for project shop.
+func committedOffset(t *testing.T, cfg *config.Config, path string) int64 {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(cfg.AgentSettings.StateDir, "logship", "offsets.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var offsets map[string]struct{ Offset int64 }
+	if err := json.Unmarshal(data, &offsets); err != nil {
+		t.Fatal(err)
+	}
+	return offsets["shop\x00"+path].Offset
+}
+
+func TestShipBatches(t *testing.T) {
+	g := &gateway{}
+	cfg, path := setup(t, g)
+	cfg.AgentSettings.LogShipping.BatchSize = 2
+	cfg.Projects[0].ShipLogs.Redact = []config.RedactRule{{Pattern: `token=\S+`}}
+
+	appendLines(t, path, "one", "two token=abc", "three", "four", "five")
+	Ship(cfg)
+	want := [][]string{{"one", "two [REDACTED]"}, {"three", "four"}, {"five"}}
+	if got := g.messages(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("batches = %q, want %q", got, want)
+	}
+	rec := g.batches[0].Records[0]
+	if g.batches[0].NodeHostname != "node-1" || rec.Project != "shop" || rec.Source != "file:"+path || rec.Priority != nil {
+		t.Errorf("batch = %+v", g.batches[0])
+	}
+	if current.queue.pending() != 0 {
+		t.Errorf("%d batches still queued", current.queue.pending())
+	}
+
+	Ship(cfg)
+	if g.requests != 3 {
+		t.Errorf("%d requests, want no more without new lines", g.requests)
+	}
+}
+
+func TestShipRetry(t *testing.T) {
+	down := true
+	g := &gateway{status: func(Batch) int {
+		if down {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	}}
+	cfg, path := setup(t, g)
+	cfg.AgentSettings.LogShipping.BatchSize = 1
+
+	appendLines(t, path, "one", "two")
+	Ship(cfg)
+	appendLines(t, path, "three")
+	Ship(cfg)
+	// Delivery stops at the first failure of a tick instead of trying every batch.
+	if g.requests != 2 || current.queue.pending() != 3 {
+		t.Fatalf("while down: %d requests, %d queued, want 2 and 3", g.requests, current.queue.pending())
+	}
+
+	down = false
+	Ship(cfg)
+	if got, want := g.messages(), [][]string{{"one"}, {"two"}, {"three"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after recovery = %q, want %q in order", got, want)
+	}
+	if current.queue.pending() != 0 {
+		t.Errorf("%d batches still queued", current.queue.pending())
+	}
+}
+
+func TestShipDropsRejected(t *testing.T) {
+	g := &gateway{status: func(b Batch) int {
+		if strings.HasPrefix(b.Records[0].Message, "bad") {
+			return http.StatusRequestEntityTooLarge
+		}
+		return http.StatusOK
+	}}
+	cfg, path := setup(t, g)
+	cfg.AgentSettings.LogShipping.BatchSize = 1
+
+	appendLines(t, path, "bad one", "good one")
+	Ship(cfg)
+	if got, want := g.messages(), [][]string{{"good one"}}; !reflect.DeepEqual(got, want) || g.requests != 2 {
+		t.Errorf("delivered %q in %d requests, want %q in 2", got, g.requests, want)
+	}
+	if current.queue.pending() != 0 {
+		t.Errorf("rejected batch still queued")
+	}
+}
+
+func TestDiskQueueEviction(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	q := newDiskQueue(filepath.Join(t.TempDir(), "queue"), 250)
+	for i := 1; i <= 5; i++ {
+		if err := q.push([]byte(fmt.Sprintf("%d%s", i, strings.Repeat(".", 99)))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	sent, err := q.flush(10, func(data []byte) error {
+		got = append(got, string(data[:1]))
+		return nil
+	})
+	if err != nil || sent != 2 || !reflect.DeepEqual(got, []string{"4", "5"}) {
+		t.Errorf("flush = %d, %v, batches %v, want the newest two", sent, err, got)
+	}
+
+	// The newest batch is kept even if it alone is over the limit.
+	if err := q.push([]byte(strings.Repeat("x", 300))); err != nil {
+		t.Fatal(err)
+	}
+	if q.pending() != 1 {
+		t.Errorf("pending = %d, want the oversized batch", q.pending())
+	}
+}
+
+func TestShipCommitsAfterEnqueue(t *testing.T) {
+	g := &gateway{}
+	cfg, path := setup(t, g)
+
+	// A file where the queue directory should be makes every push fail.
+	queueDir := filepath.Join(cfg.AgentSettings.StateDir, "logship", "queue")
+	if err := os.RemoveAll(queueDir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(queueDir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	appendLines(t, path, "one")
+	Ship(cfg)
+	appendLines(t, path, "two")
+	Ship(cfg)
+	if offset := committedOffset(t, cfg, path); offset != 0 {
+		t.Errorf("offset %d committed although nothing was queued", offset)
+	}
+	if len(current.unqueued) != 1 {
+		t.Errorf("unqueued = %+v, want the first line only", current.unqueued)
+	}
+
+	if err := os.Remove(queueDir); err != nil {
+		t.Fatal(err)
+	}
+	Ship(cfg)
+	if got, want := g.messages(), [][]string{{"one"}, {"two"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+	if offset := committedOffset(t, cfg, path); offset != int64(len("one\ntwo\n")) {
+		t.Errorf("offset = %d after queueing both lines", offset)
+	}
+}
+
+func TestShipHoldsMultilineRecord(t *testing.T) {
+	g := &gateway{}
+	cfg, path := setup(t, g)
+	cfg.Projects[0].ShipLogs.Multiline = &config.Multiline{StartPattern: `^\d`}
+
+	appendLines(t, path, "1 done", "2 panic", "  at main.go:10")
+	Ship(cfg)
+	// "2 panic" may still get continuation lines, so it is neither shipped nor committed.
+	if got, want := g.messages(), [][]string{{"1 done"}}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("delivered %q, want %q", got, want)
+	}
+	if offset := committedOffset(t, cfg, path); offset != int64(len("1 done\n")) {
+		t.Errorf("offset = %d, want the start of the pending record", offset)
+	}
+
+	// After a restart the pending record is read again rather than lost.
+	current = nil
+	appendLines(t, path, "  at main.go:20")
+	Ship(cfg)
+	Ship(cfg)
+	want := [][]string{{"1 done"}, {"2 panic\n  at main.go:10\n  at main.go:20"}}
+	if got := g.messages(); !reflect.DeepEqual(got, want) {
+		t.Errorf("delivered %q, want %q", got, want)
+	}
+	if offset := committedOffset(t, cfg, path); offset != int64(len("1 done\n2 panic\n  at main.go:10\n  at main.go:20\n")) {
+		t.Errorf("offset = %d after shipping everything", offset)
+	}
+}
@@ -0,0 +1,117 @@
+package logship
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const defaultQueueMaxBytes = 64 << 20
+
+// diskQueue buffers encoded batches as files in a directory until they are
+// delivered. File names sort in enqueue order, so the oldest batch is first
+// both for delivery and for eviction when the queue exceeds maxBytes.
+type diskQueue struct {
+	dir      string
+	maxBytes int64
+	seq      int
+}
+
+func newDiskQueue(dir string, maxBytes int64) *diskQueue {
+	if maxBytes <= 0 {
+		maxBytes = defaultQueueMaxBytes
+	}
+	return &diskQueue{dir: dir, maxBytes: maxBytes}
+}
+
+// push stores a batch and evicts the oldest batches if the queue is over its limit.
+func (q *diskQueue) push(data []byte) error {
+	if err := os.MkdirAll(q.dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create log queue dir %s: %w", q.dir, err)
+	}
+	q.seq++
+	name := fmt.Sprintf("%020d-%06d.json", time.Now().UnixNano(), q.seq%1000000)
+	path := filepath.Join(q.dir, name)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return fmt.Errorf("failed to write log batch %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to enqueue log batch %s: %w", path, err)
+	}
+	q.evict()
+	return nil
+}
+
+// batches returns the queued batch files, oldest first.
+func (q *diskQueue) batches() []string {
+	matches, _ := filepath.Glob(filepath.Join(q.dir, "*.json"))
+	sort.Strings(matches)
+	return matches
+}
+
+func (q *diskQueue) evict() {
+	files := q.batches()
+	sizes := make([]int64, len(files))
+	var total int64
+	for i, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+
+	evicted := 0
+	// Always keep the newest batch, even if it alone exceeds the limit.
+	for i := 0; total > q.maxBytes && i < len(files)-1; i++ {
+		if err := os.Remove(files[i]); err != nil && !os.IsNotExist(err) {
+			log.Printf("logship: failed to evict %s: %v", files[i], err)
+			continue
+		}
+		total -= sizes[i]
+		evicted++
+	}
+	if evicted > 0 {
+		log.Printf("logship: queue over %d bytes, evicted %d oldest batch(es)", q.maxBytes, evicted)
+	}
+}
+
+// flush delivers up to max batches, oldest first, and stops at the first
+// batch that could not be delivered so ordering is kept.
+func (q *diskQueue) flush(max int, send func([]byte) error) (int, error) {
+	sent := 0
+	for _, path := range q.batches() {
+		if sent >= max {
+			break
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // Evicted meanwhile
+			}
+			return sent, fmt.Errorf("failed to read log batch %s: %w", path, err)
+		}
+		if err := send(data); err != nil {
+			var rejected *rejectedError
+			if !errors.As(err, &rejected) {
+				return sent, err
+			}
+			// The gateway will never accept this batch; drop it instead of blocking the queue.
+			log.Printf("logship: dropping batch %s: %v", filepath.Base(path), err)
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return sent, fmt.Errorf("failed to remove delivered log batch %s: %w", path, err)
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// pending reports the number of queued batches.
+func (q *diskQueue) pending() int {
+	return len(q.batches())
+}
@@ -0,0 +1,134 @@
+package logship
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+
+	"vps-screener/agent/config"
+	"vps-screener/agent/journal"
+	"vps-screener/agent/logwatch"
+)
+
+const (
+	defaultMultilineMaxLines = 200
+	defaultRedaction         = "[REDACTED]"
+	maxRecordLen             = 64 * 1024 // Longer records are cut to keep batches bounded
+)
+
+// Record is a single shipped log record.
+type Record struct {
+	Timestamp int64  `json:"ts"` // Unix milliseconds; read time for files, entry time for the journal
+	Project   string `json:"project"`
+	Source    string `json:"source"` // "file:<path>" or "journal:<unit>"
+	Priority  *int   `json:"priority,omitempty"`
+	Message   string `json:"message"`
+
+	origin origin // Where the record starts in its source; not shipped
+}
+
+// origin is the position of a record's first line, which the shipper must not
+// commit past while the record is still being joined.
+type origin struct {
+	path  string // Followed file, read from pos on
+	pos   logwatch.Position
+	entry *journal.Entry // Or the journal entry
+}
+
+var (
+	regexCache      = make(map[string]*regexp.Regexp)
+	regexCacheMutex = &sync.Mutex{}
+)
+
+// compile returns a cached compiled regex. Patterns are validated by config.LoadConfig.
+func compile(pattern string) *regexp.Regexp {
+	regexCacheMutex.Lock()
+	defer regexCacheMutex.Unlock()
+	if re, ok := regexCache[pattern]; ok {
+		return re
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil
+	}
+	regexCache[pattern] = re
+	return re
+}
+
+// redact applies the redaction rules to msg in order.
+func redact(rules []config.RedactRule, msg string) string {
+	for _, rule := range rules {
+		re := compile(rule.Pattern)
+		if re == nil {
+			continue
+		}
+		replacement := rule.Replacement
+		if replacement == "" {
+			replacement = defaultRedaction
+		}
+		msg = re.ReplaceAllString(msg, replacement)
+	}
+	return msg
+}
+
+// pendingRecord is a multiline record still waiting for continuation lines.
+type pendingRecord struct {
+	record  Record
+	lines   int
+	touched bool // Received a line during the current tick
+}
+
+// joiner groups lines into records per source. Without multiline settings
+// every line is its own record.
+type joiner struct {
+	pending map[string]*pendingRecord // Keyed by source
+}
+
+func newJoiner() *joiner {
+	return &joiner{pending: make(map[string]*pendingRecord)}
+}
+
+// add feeds one line. Completed records are passed to emit.
+func (j *joiner) add(ml *config.Multiline, rec Record, emit func(Record)) {
+	if ml == nil {
+		emit(rec)
+		return
+	}
+	start := compile(ml.StartPattern)
+	maxLines := ml.MaxLines
+	if maxLines <= 0 {
+		maxLines = defaultMultilineMaxLines
+	}
+
+	p, ok := j.pending[rec.Source]
+	if ok && (start == nil || !start.MatchString(rec.Message)) && p.lines < maxLines {
+		p.record.Message += "\n" + rec.Message
+		p.lines++
+		p.touched = true
+		return
+	}
+	if ok {
+		emit(p.record)
+	}
+	j.pending[rec.Source] = &pendingRecord{record: rec, lines: 1, touched: true}
+}
+
+// flushIdle emits records that received no line during this tick: their
+// continuation lines, if any, have all been written by now.
+func (j *joiner) flushIdle(emit func(Record)) {
+	for source, p := range j.pending {
+		if p.touched {
+			p.touched = false
+			continue
+		}
+		emit(p.record)
+		delete(j.pending, source)
+	}
+}
+
+func truncateRecord(msg string) string {
+	if len(msg) <= maxRecordLen {
+		return msg
+	}
+	return strings.ToValidUTF8(msg[:maxRecordLen], "")
+}
@@ -0,0 +1,208 @@
+package logwatch
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"vps-screener/agent/config"
+)
+
+const stateFileName = "logwatch.json"
+
+// Position is a read position in a tailed file, as persisted in the state file.
+type Position struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+}
+
+// Follower tails files on behalf of several owners (projects) and persists
+// the read offsets to a state file, so restarts resume where they left off.
+// The same file may be followed by several owners, each with its own offset.
+type Follower struct {
+	statePath     string
+	tails         map[string]*tail // Keyed by followKey(owner, path)
+	knownPatterns map[string]bool  // Keyed by followKey(owner, pattern)
+	savedOffsets  map[string]Position
+	seen          map[string]bool     // Tails polled since the last Commit
+	held          map[string]Position // Set by Hold until the next Commit
+	mu            sync.Mutex
+}
+
+// NewFollower creates a Follower whose offsets are stored in statePath.
+func NewFollower(statePath string) *Follower {
+	return &Follower{
+		statePath:     statePath,
+		tails:         make(map[string]*tail),
+		knownPatterns: make(map[string]bool),
+		savedOffsets:  loadOffsets(statePath),
+		seen:          make(map[string]bool),
+		held:          make(map[string]Position),
+	}
+}
+
+func followKey(owner, path string) string {
+	return owner + "\x00" + path
+}
+
+// Poll reads the lines appended to every file matching patterns since the
+// previous Poll and passes them to fn, together with the file's path and the
+// position the line starts at.
+func (f *Follower) Poll(owner string, patterns []string, fn func(path, line string, pos Position)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, pattern := range patterns {
+		paths, err := filepath.Glob(pattern)
+		if err != nil {
+			log.Printf("logwatch: invalid file pattern %q for %s: %v", pattern, owner, err)
+			continue
+		}
+		// Files that already existed when a pattern is first watched are read from
+		// the end, so history is not reported as new. Files that show up later are new.
+		patternKey := followKey(owner, pattern)
+		startAtEnd := !f.knownPatterns[patternKey]
+		f.knownPatterns[patternKey] = true
+
+		for _, path := range paths {
+			key := followKey(owner, path)
+			f.seen[key] = true
+			t, ok := f.tails[key]
+			if !ok {
+				var saved *Position
+				if off, found := f.savedOffsets[key]; found {
+					saved = &off
+				}
+				t, err = openTail(path, saved, startAtEnd)
+				if err != nil {
+					log.Printf("logwatch: %v", err)
+					continue
+				}
+				f.tails[key] = t
+			}
+			path := path
+			if err := t.poll(func(line string, pos Position) { fn(path, line, pos) }); err != nil {
+				log.Printf("logwatch: %v", err)
+			}
+		}
+
+		// A followed file that no longer matches was rotated away (and not yet
+		// recreated) or deleted. Read the lines written to it since the last
+		// poll; Commit then stops following it.
+		for key, t := range f.tails {
+			if f.seen[key] || !strings.HasPrefix(key, followKey(owner, "")) {
+				continue
+			}
+			if ok, _ := filepath.Match(pattern, t.path); ok {
+				path := t.path
+				if err := t.readLines(func(line string, pos Position) { fn(path, line, pos) }); err != nil {
+					log.Printf("logwatch: %v", err)
+				}
+			}
+		}
+	}
+}
+
+// Hold keeps the offset Commit persists for owner's file at pos, if pos is
+// before the current read position: the lines from pos on are read again
+// after a restart. It applies to the next Commit only.
+func (f *Follower) Hold(owner, path string, pos Position) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	key := followKey(owner, path)
+	if held, ok := f.held[key]; !ok || held.Inode == pos.Inode && pos.Offset < held.Offset {
+		f.held[key] = pos
+	}
+}
+
+// Commit stops following files that were not polled since the previous
+// Commit (no longer configured or no longer matching) and persists the offsets.
+func (f *Follower) Commit() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for key, t := range f.tails {
+		if !f.seen[key] {
+			t.close()
+			delete(f.tails, key)
+		}
+	}
+	f.seen = make(map[string]bool)
+
+	f.savedOffsets = make(map[string]Position, len(f.tails))
+	for key, t := range f.tails {
+		f.savedOffsets[key] = Position{Inode: t.inode, Offset: t.offset}
+		if held, ok := f.held[key]; ok && (held.Inode != t.inode || held.Offset < t.offset) {
+			f.savedOffsets[key] = held
+		}
+	}
+	f.held = make(map[string]Position)
+	if err := saveOffsets(f.statePath, f.savedOffsets); err != nil {
+		log.Printf("logwatch: failed to persist log offsets: %v", err)
+	}
+}
+
+var (
+	follower      *Follower
+	followerMutex = &sync.Mutex{}
+)
+
+// Collect reads new lines from every project's log files, evaluates the
+// project's rules and returns a Report per project with a `logs` section.
+func Collect(cfg *config.Config) map[string]Report {
+	followerMutex.Lock()
+	defer followerMutex.Unlock()
+
+	statePath := filepath.Join(cfg.AgentSettings.StateDir, stateFileName)
+	if follower == nil || follower.statePath != statePath {
+		follower = NewFollower(statePath)
+	}
+
+	reports := make(map[string]Report)
+	for _, proj := range cfg.Projects {
+		if proj.Logs == nil {
+			continue
+		}
+		matcher := NewMatcher(proj.Logs.Rules, proj.Logs.Samples)
+		follower.Poll(proj.Name, proj.Logs.Files, func(_, line string, _ Position) { matcher.Line(line) })
+		reports[proj.Name] = matcher.Report()
+	}
+	follower.Commit()
+	return reports
+}
+
+func loadOffsets(path string) map[string]Position {
+	offsets := make(map[string]Position)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("logwatch: could not read offsets from %s: %v", path, err)
+		}
+		return offsets
+	}
+	if err := json.Unmarshal(data, &offsets); err != nil {
+		log.Printf("logwatch: ignoring corrupt offsets file %s: %v", path, err)
+		return make(map[string]Position)
+	}
+	return offsets
+}
+
+// saveOffsets writes the offsets atomically, so a crash never leaves a half-written file.
+func saveOffsets(path string, offsets map[string]Position) error {
+	data, err := json.Marshal(offsets)
+	if err != nil {
+		return fmt.Errorf("failed to marshal offsets: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create state dir: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, path)
+}
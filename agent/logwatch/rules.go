@@ -0,0 +1,101 @@
+package logwatch
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"vps-screener/agent/config"
+)
+
+const (
+	defaultSamples = 3
+	maxSampleLen   = 512 // Sample lines are truncated to keep the payload small
+)
+
+// Report summarises the log lines read for one project during one tick.
+type Report struct {
+	LinesRead int64               `json:"lines_read"`
+	Counters  map[string]int64    `json:"counters"`          // Rule name -> matching lines this tick
+	Samples   map[string][]string `json:"samples,omitempty"` // Rule name -> first few matching lines this tick
+}
+
+var (
+	regexCache      = make(map[string]*regexp.Regexp)
+	regexCacheMutex = &sync.Mutex{}
+)
+
+// compile returns a cached compiled regex. Patterns are validated by
+// config.LoadConfig, so an error here means the config bypassed validation.
+func compile(pattern string) (*regexp.Regexp, error) {
+	regexCacheMutex.Lock()
+	defer regexCacheMutex.Unlock()
+	if re, ok := regexCache[pattern]; ok {
+		return re, nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log rule pattern %q: %w", pattern, err)
+	}
+	regexCache[pattern] = re
+	return re, nil
+}
+
+type compiledRule struct {
+	name string
+	re   *regexp.Regexp
+}
+
+// Matcher evaluates a project's log rules against lines and accumulates a Report.
+// It is shared by every log source of the project (files, journald).
+type Matcher struct {
+	rules   []compiledRule
+	samples int
+	report  Report
+}
+
+// NewMatcher compiles rules into a Matcher. Rules with invalid patterns are skipped.
+func NewMatcher(rules []config.LogRule, samples int) *Matcher {
+	if samples <= 0 {
+		samples = defaultSamples
+	}
+	m := &Matcher{
+		samples: samples,
+		report:  Report{Counters: make(map[string]int64, len(rules))},
+	}
+	for _, rule := range rules {
+		re, err := compile(rule.Pattern)
+		if err != nil {
+			continue
+		}
+		m.rules = append(m.rules, compiledRule{name: rule.Name, re: re})
+		m.report.Counters[rule.Name] = 0 // Report zero counts too, so series stay continuous
+	}
+	return m
+}
+
+// Line evaluates a single log line (without its trailing newline).
+func (m *Matcher) Line(line string) {
+	m.report.LinesRead++
+	for _, rule := range m.rules {
+		if !rule.re.MatchString(line) {
+			continue
+		}
+		m.report.Counters[rule.name]++
+		if len(m.report.Samples[rule.name]) < m.samples {
+			if m.report.Samples == nil {
+				m.report.Samples = make(map[string][]string)
+			}
+			sample := line
+			if len(sample) > maxSampleLen {
+				sample = sample[:maxSampleLen]
+			}
+			m.report.Samples[rule.name] = append(m.report.Samples[rule.name], sample)
+		}
+	}
+}
+
+// Report returns the accumulated counters and samples.
+func (m *Matcher) Report() Report {
+	return m.report
+}
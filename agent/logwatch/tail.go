@@ -0,0 +1,125 @@
+package logwatch
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+)
+
+const (
+	maxReadPerTick = 16 << 20 // Bytes read per file per tick; the rest is picked up on the next tick
+	maxLineLen     = 1 << 20  // A partial line longer than this is consumed even without a newline
+)
+
+// tail follows a single log file across rotation and truncation.
+type tail struct {
+	path   string
+	file   *os.File
+	inode  uint64
+	offset int64 // Offset of the first unread byte in file
+}
+
+func inodeOf(info os.FileInfo) uint64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
+
+// openTail opens path and positions it. A saved offset is reused when it refers
+// to the same inode and still fits the file; otherwise reading starts at the
+// end of the file (startAtEnd) or at its beginning.
+func openTail(path string, saved *Position, startAtEnd bool) (*tail, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("error opening log file %s: %w", path, err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("error reading log file %s: %w", path, err)
+	}
+
+	t := &tail{path: path, file: file, inode: inodeOf(info)}
+	switch {
+	case saved != nil && saved.Inode == t.inode && saved.Offset <= info.Size():
+		t.offset = saved.Offset
+	case saved == nil && startAtEnd:
+		t.offset = info.Size()
+	}
+	return t, nil
+}
+
+// poll reads all complete lines appended since the last call and passes them
+// to fn with the position they start at.
+func (t *tail) poll(fn func(string, Position)) error {
+	// Drain the file we already have open first, so lines written just
+	// before a rotation are not lost.
+	if err := t.readLines(fn); err != nil {
+		return err
+	}
+
+	info, err := os.Stat(t.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil // Rotated away and not yet recreated; keep the old file until it is
+		}
+		return fmt.Errorf("error reading log file %s: %w", t.path, err)
+	}
+
+	if inode := inodeOf(info); inode != t.inode {
+		// Rotated: switch to the new file and read it from the start.
+		file, err := os.Open(t.path)
+		if err != nil {
+			return fmt.Errorf("error reopening rotated log file %s: %w", t.path, err)
+		}
+		t.file.Close()
+		t.file, t.inode, t.offset = file, inode, 0
+		return t.readLines(fn)
+	}
+
+	if info.Size() < t.offset {
+		// Truncated in place (e.g. logrotate copytruncate).
+		t.offset = 0
+		return t.readLines(fn)
+	}
+	return nil
+}
+
+func (t *tail) readLines(fn func(string, Position)) error {
+	if _, err := t.file.Seek(t.offset, io.SeekStart); err != nil {
+		return fmt.Errorf("error seeking log file %s: %w", t.path, err)
+	}
+
+	reader := bufio.NewReaderSize(t.file, 64*1024)
+	var consumed int64
+	for consumed < maxReadPerTick {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			// Leave a trailing partial line for the next tick unless it is absurdly long.
+			if len(line) < maxLineLen {
+				break
+			}
+		} else if err != nil {
+			t.offset += consumed
+			return fmt.Errorf("error reading log file %s: %w", t.path, err)
+		}
+		pos := Position{Inode: t.inode, Offset: t.offset + consumed}
+		consumed += int64(len(line))
+		fn(strings.TrimRight(line, "\r\n"), pos)
+		if err == io.EOF {
+			break
+		}
+	}
+	t.offset += consumed
+	return nil
+}
+
+func (t *tail) close() {
+	if t.file != nil {
+		t.file.Close()
+	}
+}
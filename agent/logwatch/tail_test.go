@@ -0,0 +1,236 @@
+package logwatch
+
+import (
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"vps-screener/agent/config"
+)
+
+func writeLog(t *testing.T, path, data string, flag int) {
+	t.Helper()
+	f, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendLog(t *testing.T, path, data string) {
+	t.Helper()
+	writeLog(t, path, data, os.O_APPEND)
+}
+
+// pollLines polls the follower for owner "app" and returns the lines read.
+func pollLines(f *Follower, pattern string) []string {
+	var lines []string
+	f.Poll("app", []string{pattern}, func(_, line string, _ Position) { lines = append(lines, line) })
+	f.Commit()
+	return lines
+}
+
+func newTestFollower(t *testing.T) (*Follower, string, string) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	dir := t.TempDir()
+	return NewFollower(filepath.Join(dir, "state", stateFileName)), filepath.Join(dir, "app.log"), dir
+}
+
+func TestTailStartsAtEnd(t *testing.T) {
+	f, path, dir := newTestFollower(t)
+	appendLog(t, path, "history\n")
+	if lines := pollLines(f, path); lines != nil {
+		t.Errorf("existing content read as new: %q", lines)
+	}
+	appendLog(t, path, "one\n")
+	if got := pollLines(f, path); !reflect.DeepEqual(got, []string{"one"}) {
+		t.Errorf("lines = %q", got)
+	}
+
+	// A file showing up later for a known pattern is read from its start.
+	other := filepath.Join(dir, "other.log")
+	pattern := filepath.Join(dir, "*.log")
+	pollLines(f, pattern)
+	appendLog(t, other, "first\n")
+	if got := pollLines(f, pattern); !reflect.DeepEqual(got, []string{"first"}) {
+		t.Errorf("new file lines = %q", got)
+	}
+}
+
+func TestTailRotation(t *testing.T) {
+	f, path, _ := newTestFollower(t)
+	appendLog(t, path, "")
+	pollLines(f, path)
+
+	// Renamed and recreated between two polls: the old file is drained first.
+	appendLog(t, path, "old 1\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path+".1", "old 2\n")
+	appendLog(t, path, "new 1\n")
+	if got, want := pollLines(f, path), []string{"old 1", "old 2", "new 1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("after rotation = %q, want %q", got, want)
+	}
+	appendLog(t, path, "new 2\n")
+	if got := pollLines(f, path); !reflect.DeepEqual(got, []string{"new 2"}) {
+		t.Errorf("following the new file = %q", got)
+	}
+
+	// Renamed and recreated only after a poll: the lines written just before
+	// the rename still come from the old file, and the new one is read in full.
+	appendLog(t, path, "before\n")
+	if err := os.Rename(path, path+".2"); err != nil {
+		t.Fatal(err)
+	}
+	if got := pollLines(f, path); !reflect.DeepEqual(got, []string{"before"}) {
+		t.Errorf("rotated away, not recreated: %q", got)
+	}
+	appendLog(t, path, "recreated\n")
+	if got := pollLines(f, path); !reflect.DeepEqual(got, []string{"recreated"}) {
+		t.Errorf("recreated file = %q", got)
+	}
+
+	// The same with a glob that only matches the current file.
+	dir := filepath.Dir(path)
+	pattern := filepath.Join(dir, "*.log")
+	pollLines(f, pattern)
+	appendLog(t, path, "globbed\n")
+	if err := os.Rename(path, filepath.Join(dir, "app.log.3")); err != nil {
+		t.Fatal(err)
+	}
+	if got := pollLines(f, pattern); !reflect.DeepEqual(got, []string{"globbed"}) {
+		t.Errorf("glob, rotated away: %q", got)
+	}
+}
+
+func TestTailTruncation(t *testing.T) {
+	f, path, _ := newTestFollower(t)
+	appendLog(t, path, "")
+	pollLines(f, path)
+	appendLog(t, path, "a long line before copytruncate\n")
+	pollLines(f, path)
+
+	// logrotate's copytruncate keeps the inode and empties the file.
+	writeLog(t, path, "short\n", os.O_TRUNC)
+	if got := pollLines(f, path); !reflect.DeepEqual(got, []string{"short"}) {
+		t.Errorf("after truncation = %q", got)
+	}
+}
+
+func TestTailPartialLines(t *testing.T) {
+	f, path, _ := newTestFollower(t)
+	appendLog(t, path, "")
+	pollLines(f, path)
+
+	appendLog(t, path, "complete\nparti")
+	if got := pollLines(f, path); !reflect.DeepEqual(got, []string{"complete"}) {
+		t.Errorf("lines = %q, want the partial line held back", got)
+	}
+	appendLog(t, path, "al\r\n")
+	if got := pollLines(f, path); !reflect.DeepEqual(got, []string{"partial"}) {
+		t.Errorf("completed line = %q", got)
+	}
+
+	// A partial line over maxLineLen is read without waiting for its newline.
+	long := strings.Repeat("x", maxLineLen)
+	appendLog(t, path, long)
+	if got := pollLines(f, path); len(got) != 1 || got[0] != long {
+		t.Errorf("overlong partial line: %d lines", len(got))
+	}
+}
+
+func TestOffsetsSurviveRestart(t *testing.T) {
+	f, path, _ := newTestFollower(t)
+	appendLog(t, path, "")
+	pollLines(f, path)
+	appendLog(t, path, "one\ntwo\n")
+	pollLines(f, path)
+
+	// Written while the agent is down.
+	appendLog(t, path, "three\n")
+	restarted := NewFollower(f.statePath)
+	if got := pollLines(restarted, path); !reflect.DeepEqual(got, []string{"three"}) {
+		t.Errorf("after restart = %q, want only the line written meanwhile", got)
+	}
+
+	// Rotated while the agent is down: the saved offset belongs to another inode.
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path, "fresh\n")
+	restarted = NewFollower(f.statePath)
+	if got := pollLines(restarted, path); !reflect.DeepEqual(got, []string{"fresh"}) {
+		t.Errorf("after rotation while down = %q", got)
+	}
+
+	// A corrupt state file is ignored: reading starts at the end.
+	if err := os.WriteFile(f.statePath, []byte("{"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	appendLog(t, path, "unseen\n")
+	restarted = NewFollower(f.statePath)
+	if lines := pollLines(restarted, path); lines != nil {
+		t.Errorf("with a corrupt state file = %q", lines)
+	}
+}
+
+func TestFollowerHold(t *testing.T) {
+	f, path, _ := newTestFollower(t)
+	appendLog(t, path, "")
+	pollLines(f, path)
+	appendLog(t, path, "one\ntwo\n")
+	var positions []Position
+	f.Poll("app", []string{path}, func(_, _ string, pos Position) { positions = append(positions, pos) })
+	if len(positions) != 2 || positions[1].Offset != 4 {
+		t.Fatalf("positions = %+v", positions)
+	}
+	f.Hold("app", path, positions[1])
+	f.Commit()
+
+	if got := pollLines(NewFollower(f.statePath), path); !reflect.DeepEqual(got, []string{"two"}) {
+		t.Errorf("after restart = %q, want the held line again", got)
+	}
+	// The hold applies to one Commit only.
+	f.Commit()
+	if lines := pollLines(NewFollower(f.statePath), path); lines != nil {
+		t.Errorf("second restart = %q", lines)
+	}
+}
+
+func TestCollect(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	follower = nil
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendLog(t, path, "")
+	cfg := &config.Config{
+		AgentSettings: config.AgentSettings{StateDir: filepath.Join(dir, "state")},
+		Projects: []config.ProjectConfig{{Name: "app", Logs: &config.LogWatch{
+			Files: []string{path},
+			Rules: []config.LogRule{{Name: "error_lines", Pattern: `(?i)\berror\b`}, {Name: "http_5xx", Pattern: `" 5\d\d `}},
+		}}},
+	}
+	Collect(cfg)
+	appendLog(t, path, "ERROR one\nok\n\"GET /\" 502 0\nerror two\n")
+	report := Collect(cfg)["app"]
+	if report.LinesRead != 4 || report.Counters["error_lines"] != 2 || report.Counters["http_5xx"] != 1 {
+		t.Errorf("report = %+v", report)
+	}
+	if got := report.Samples["error_lines"]; !reflect.DeepEqual(got, []string{"ERROR one", "error two"}) {
+		t.Errorf("samples = %q", got)
+	}
+	if report := Collect(cfg)["app"]; report.LinesRead != 0 || report.Counters["error_lines"] != 0 {
+		t.Errorf("next tick = %+v", report)
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"vps-screener/agent/collector"
 	"vps-screener/agent/config"
 	"vps-screener/agent/executor"
+	"vps-screener/agent/logship"
 	"vps-screener/agent/sender"
 	// "vps-screener/agent/executor"
 )
@@ -18,51 +19,106 @@ var (
 	cfg *config.Config
 )
 
+// defaultConfigPath returns AGENT_CONFIG_PATH, or config.yaml relative to the working directory.
+func defaultConfigPath() string {
+	if envPath := os.Getenv("AGENT_CONFIG_PATH"); envPath != "" {
+		return envPath
+	}
+	return "config.yaml"
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "explain":
+			os.Exit(runExplain(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "validate":
+			os.Exit(runValidate(os.Args[2:]))
+		}
+	}
+
 	log.Println("Starting VPS Screener Agent (Go version)...")
 
 	var err error
-	// Attempt to load config using default path first, then allow override by env var
-	configPath := "config.yaml" // Default relative to agent executable
-	if envPath := os.Getenv("AGENT_CONFIG_PATH"); envPath != "" {
-		configPath = envPath
-	}
+	configPath := defaultConfigPath()
 
 	cfg, err = config.LoadConfig(configPath)
 	if err != nil {
 		log.Fatalf("Failed to load configuration from %s: %v", configPath, err)
 	}
 	log.Printf("Configuration loaded. Agent settings: %+v", cfg.AgentSettings)
-
-	sigs := make(chan os.Signal, 1)
-	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	logPluginProblems(cfg)
 
 	ticker := time.NewTicker(time.Duration(cfg.AgentSettings.CollectionInterval) * time.Second)
 	defer ticker.Stop()
 
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+
+	// SIGHUP and, with agent_settings.watch_config, changes to the file reload
+	// the configuration. Reloads run in this loop, so a new configuration
+	// takes effect between two ticks and never in the middle of one.
+	reloads := make(chan struct{}, 1)
+	watcher := updateWatcher(nil, cfg, configPath, reloads)
+	apply := func(next *config.Config) {
+		if next == cfg {
+			return
+		}
+		if next.AgentSettings.CollectionInterval != cfg.AgentSettings.CollectionInterval {
+			ticker.Reset(time.Duration(next.AgentSettings.CollectionInterval) * time.Second)
+		}
+		watcher = updateWatcher(watcher, next, configPath, reloads)
+		cfg = next // The mapper drops its cached mappings when it sees a new config
+	}
+	reload := func() {
+		apply(reloadConfig(configPath, cfg))
+	}
+
+	// With remote_config, the project list from the gateway is fetched now and
+	// then every refresh_interval, checked at the start of a tick.
+	remote := &remoteSync{configPath: configPath}
+	apply(remote.run(cfg))
+
 Loop:
 	for {
 		select {
 		case <-ticker.C:
+			apply(remote.run(cfg))
+
 			log.Println("Agent tick: Collecting metrics...")
 			collectedMetrics := collector.CollectMetrics(cfg)
 			if len(collectedMetrics) > 0 {
-				log.Printf("Collected data for %d projects/entities", len(collectedMetrics)-1) // -1 for _system
+				log.Printf("Collected data for %d projects/entities", collectedMetrics.ProjectCount())
 				err := sender.SendMetrics(cfg, collectedMetrics)
 				if err != nil {
 					log.Printf("Error sending metrics: %v", err)
 				}
 			}
 
+			log.Println("Agent tick: Shipping logs...")
+			logship.Ship(cfg)
+
 			log.Println("Agent tick: Checking for tasks...")
 			executor.ProcessTasks(cfg)
 
 			log.Println("Agent tick: Cycle complete.")
+		case <-reloads:
+			reload()
 		case sig := <-sigs:
+			if sig == syscall.SIGHUP {
+				log.Println("Received SIGHUP, reloading configuration...")
+				reload()
+				continue
+			}
 			log.Printf("Received signal: %s, shutting down...", sig)
 			break Loop
 		}
 	}
+	if watcher != nil {
+		watcher.Close()
+	}
 
 	log.Println("VPS Screener Agent stopped.")
 } 
\ No newline at end of file
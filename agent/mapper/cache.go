@@ -0,0 +1,77 @@
+package mapper
+
+import (
+	"log"
+	"os/user"
+	"sync"
+	"time"
+
+	"vps-screener/agent/config"
+)
+
+// procKey identifies a process across ticks. The start time tells a reused
+// PID apart from the process that had it before.
+type procKey struct {
+	pid   int
+	start int64 // Unix nanoseconds
+}
+
+func newProcKey(pid int, start time.Time) procKey {
+	return procKey{pid: pid, start: start.UnixNano()}
+}
+
+type cachedMapping struct {
+	mapping Mapping // Zero if the process matched no project
+	tick    uint64  // Last tick the process was seen in
+}
+
+// The mapping cache holds each live process's direct match, so rules are
+// evaluated once per process instead of on every tick. Attributes that change
+// during a process's life (command line rewrites, setuid) are not picked up.
+// It is dropped when MapProcesses is called with a different config, i.e.
+// after a reload, and when cached container or pod data changed.
+var (
+	mappingCache           = make(map[procKey]cachedMapping)
+	mappingCacheConfig     *config.Config
+	mappingCacheContainers uint64 // containerGeneration the cache was built with
+	mappingCacheKube       uint64 // kubeGeneration the cache was built with
+	mappingCacheTick       uint64
+	mappingCacheMutex      = &sync.Mutex{}
+
+	usernameCache      = make(map[string]string) // UID -> username, "" if the lookup failed
+	usernameCacheMutex = &sync.RWMutex{}
+)
+
+// ResetCache drops all cached process mappings and usernames.
+func ResetCache() {
+	mappingCacheMutex.Lock()
+	mappingCache = make(map[procKey]cachedMapping)
+	mappingCacheConfig = nil
+	mappingCacheMutex.Unlock()
+
+	usernameCacheMutex.Lock()
+	usernameCache = make(map[string]string)
+	usernameCacheMutex.Unlock()
+}
+
+// lookupUsername resolves a UID to a username. Failed lookups (e.g. UIDs that
+// only exist inside a container) are cached too, and logged once.
+func lookupUsername(uid string) string {
+	usernameCacheMutex.RLock()
+	name, found := usernameCache[uid]
+	usernameCacheMutex.RUnlock()
+	if found {
+		return name
+	}
+
+	u, err := user.LookupId(uid)
+	if err != nil {
+		log.Printf("mapper: could not lookup username for UID %s: %v", uid, err)
+	} else {
+		name = u.Username
+	}
+	usernameCacheMutex.Lock()
+	usernameCache[uid] = name
+	usernameCacheMutex.Unlock()
+	return name
+}
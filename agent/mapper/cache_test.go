@@ -0,0 +1,137 @@
+package mapper
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-sysinfo/types"
+
+	"vps-screener/agent/config"
+)
+
+// fakeProcess is a synthetic process. Its PID is above the kernel's pid_max,
+// so /proc lookups (cgroup, environ) find nothing, like for a process that exited.
+type fakeProcess struct {
+	info types.ProcessInfo
+	uid  string
+}
+
+func (p *fakeProcess) Info() (types.ProcessInfo, error)  { return p.info, nil }
+func (p *fakeProcess) Memory() (types.MemoryInfo, error) { return types.MemoryInfo{}, nil }
+func (p *fakeProcess) CPUTime() (types.CPUTimes, error)  { return types.CPUTimes{}, nil }
+func (p *fakeProcess) User() (types.UserInfo, error)     { return types.UserInfo{UID: p.uid}, nil }
+func (p *fakeProcess) Parent() (types.Process, error)    { return nil, types.ErrNotImplemented }
+func (p *fakeProcess) PID() int                          { return p.info.PID }
+
+const fakePIDBase = 5000000
+
+func syntheticProcesses(n int) []types.Process {
+	boot := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	names := []string{"nginx", "node", "postgres", "python3", "bash", "cron", "sshd", "java"}
+	uids := []string{"0", "33", "65534", fmt.Sprint(os.Getuid())}
+	procs := make([]types.Process, n)
+	for i := range procs {
+		name := names[i%len(names)]
+		ppid := 1
+		if i > 0 && i%4 != 0 {
+			ppid = fakePIDBase + i - 1
+		}
+		procs[i] = &fakeProcess{
+			info: types.ProcessInfo{
+				Name:      name,
+				PID:       fakePIDBase + i,
+				PPID:      ppid,
+				Exe:       "/usr/bin/" + name,
+				Args:      []string{name, "--worker", fmt.Sprint(i)},
+				StartTime: boot.Add(time.Duration(i) * time.Millisecond),
+			},
+			uid: uids[i%len(uids)],
+		}
+	}
+	return procs
+}
+
+func syntheticConfig(t testing.TB) *config.Config {
+	cfg := &config.Config{Projects: []config.ProjectConfig{
+		{Name: "web", Match: config.MatchRules{SystemdUnit: "web.service"}},
+		{Name: "db", Match: config.MatchRules{All: []config.MatchRules{{User: "nobody"}, {Cmdline: "--worker 1"}}}},
+		{Name: "app", Match: config.MatchRules{ProcessNamePattern: config.ProcessPattern{Pattern: "^(node|java)$"}}},
+		{Name: "billing", Match: config.MatchRules{Env: "PROJECT=billing"}},
+		{Name: "data", Match: config.MatchRules{Exe: "/usr/bin/postgres"}},
+	}}
+	for i := range cfg.Projects {
+		if err := cfg.Projects[i].Match.Compile("match"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return cfg
+}
+
+func TestMapProcessesCache(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	ResetCache()
+
+	cfg := syntheticConfig(t)
+	procs := syntheticProcesses(16)
+	first := MapProcesses(procs, cfg)
+	if got := first[fakePIDBase+1].Project; got != "app" {
+		t.Fatalf("node process mapped to %q, want app", got)
+	}
+	if len(mappingCache) != len(procs) {
+		t.Fatalf("cache has %d entries, want %d", len(mappingCache), len(procs))
+	}
+
+	// A reused PID (new start time) must be evaluated again.
+	reused := procs[1].(*fakeProcess)
+	reused.info.Name = "postgres"
+	reused.info.Exe = "/usr/bin/postgres"
+	reused.info.StartTime = reused.info.StartTime.Add(time.Hour)
+	if got := MapProcesses(procs, cfg)[reused.info.PID].Project; got != "data" {
+		t.Errorf("reused PID mapped to %q, want data", got)
+	}
+	if len(mappingCache) != len(procs) {
+		t.Errorf("cache has %d entries after PID reuse, want %d", len(mappingCache), len(procs))
+	}
+
+	// Exited processes are forgotten.
+	MapProcesses(procs[:4], cfg)
+	if len(mappingCache) != 4 {
+		t.Errorf("cache has %d entries after processes exited, want 4", len(mappingCache))
+	}
+
+	// A new config (reload) drops the cache.
+	reloaded := syntheticConfig(t)
+	reloaded.Projects = reloaded.Projects[3:]
+	if got := MapProcesses(procs, reloaded)[fakePIDBase+9].Project; got != "" {
+		t.Errorf("after reload node process mapped to %q, want no project", got)
+	}
+}
+
+// benchmarkMapProcesses measures one tick of mapping 5,000 processes.
+func benchmarkMapProcesses(b *testing.B, cached bool) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	cfg := syntheticConfig(b)
+	procs := syntheticProcesses(5000)
+	ResetCache()
+	MapProcesses(procs, cfg)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !cached {
+			b.StopTimer()
+			ResetCache()
+			b.StartTimer()
+		}
+		MapProcesses(procs, cfg)
+	}
+}
+
+func BenchmarkMapProcesses5000Uncached(b *testing.B) { benchmarkMapProcesses(b, false) }
+func BenchmarkMapProcesses5000Cached(b *testing.B)   { benchmarkMapProcesses(b, true) }
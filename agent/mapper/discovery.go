@@ -0,0 +1,69 @@
+package mapper
+
+import (
+	"log"
+	"os"
+	"strings"
+
+	"vps-screener/agent/config"
+)
+
+const composeProjectLabel = "com.docker.compose.project"
+
+// discoverProject applies the discovery rules, in order, to a process that
+// matched no configured project. It returns the project name and the value it
+// was discovered from, e.g. "compose shop".
+func discoverProject(rules []config.DiscoveryRule, f *processFacts) (string, string) {
+	for _, rule := range rules {
+		if name, from := evalDiscovery(rule, f); name != "" {
+			return name, from
+		}
+	}
+	return "", ""
+}
+
+// evalDiscovery returns the project a single rule creates for a process, or "".
+func evalDiscovery(rule config.DiscoveryRule, f *processFacts) (string, string) {
+	var values []string
+	switch rule.Source {
+	case config.DiscoverySourceCompose:
+		if c := f.runtimeContainer(); c != nil && c.Labels[composeProjectLabel] != "" {
+			values = []string{c.Labels[composeProjectLabel]}
+		}
+	case config.DiscoverySourceSlice:
+		slices := f.systemdCgroup().Slices
+		for i := len(slices) - 1; i >= 0; i-- { // Innermost, i.e. most specific, first
+			values = append(values, slices[i])
+		}
+	case config.DiscoverySourceUnit:
+		if unit := f.systemdCgroup().Unit(); unit != "" {
+			values = []string{unit}
+		}
+	case config.DiscoverySourceK8s:
+		if pod := f.kubePod(); pod != nil && pod.Namespace != "" {
+			values = []string{pod.Namespace}
+		}
+	}
+
+	for _, value := range values {
+		vars := map[string]string{"name": value}
+		if !rule.Pattern.IsZero() {
+			groups := rule.Pattern.NamedGroups(value)
+			if groups == nil {
+				continue
+			}
+			for k, v := range groups {
+				vars[k] = v
+			}
+		}
+		name := strings.TrimSpace(os.Expand(rule.Name, func(key string) string { return vars[key] }))
+		if config.ReservedProjectName(name) {
+			log.Printf("mapper: ignoring project %s discovered from %s %s, the name is reserved for the agent's own metrics", name, rule.Source, value)
+			continue
+		}
+		if name != "" {
+			return name, rule.Source + " " + value
+		}
+	}
+	return "", ""
+}
@@ -0,0 +1,56 @@
+package mapper
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+
+	"vps-screener/agent/config"
+)
+
+func TestDiscoverProject(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	f := webFacts()
+	f.cgroup = &systemdCgroup{
+		Path:   "/projects.slice/projects-billing.slice/app-invoices.service",
+		Slices: []string{"projects.slice", "projects-billing.slice"},
+		Units:  []string{"app-invoices.service"},
+	}
+	f.container = &containerInfo{Labels: map[string]string{composeProjectLabel: "shop"}}
+	f.pod = &kubePod{Namespace: "payments", Labels: map[string]string{}}
+
+	rule := func(source, pattern, name string) config.DiscoveryRule {
+		r := config.DiscoveryRule{Source: source, Pattern: config.Regexp{Pattern: pattern}, Name: name}
+		if pattern != "" {
+			if err := r.Pattern.Compile(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return r
+	}
+	tests := []struct {
+		name  string
+		rules []config.DiscoveryRule
+		want  string
+	}{
+		{"compose", []config.DiscoveryRule{rule("compose", "", "$name")}, "shop"},
+		{"compose template", []config.DiscoveryRule{rule("compose", "", "compose-${name}")}, "compose-shop"},
+		{"compose filtered out", []config.DiscoveryRule{rule("compose", "^internal-", "$name")}, ""},
+		{"slice innermost first", []config.DiscoveryRule{rule("slice", `^projects-(?P<name>.+)\.slice$`, "$name")}, "billing"},
+		{"unit template", []config.DiscoveryRule{rule("unit", `^app-(?P<name>.+)\.service$`, "app/$name")}, "app/invoices"},
+		{"unit other group", []config.DiscoveryRule{rule("unit", `^(?P<kind>[a-z]+)-(?P<svc>.+)\.service$`, "${kind}_${svc}")}, "app_invoices"},
+		{"first rule wins", []config.DiscoveryRule{rule("unit", `^web-`, "$name"), rule("slice", `^projects-(?P<name>.+)\.slice$`, "$name"), rule("compose", "", "$name")}, "billing"},
+		{"k8s namespace", []config.DiscoveryRule{rule("k8s_namespace", `^(kube-|default$)`, "$name"), rule("k8s_namespace", "", "k8s-$name")}, "k8s-payments"},
+		{"empty name skipped", []config.DiscoveryRule{rule("unit", `^app-`, "$missing"), rule("compose", "", "$name")}, "shop"},
+		{"reserved name skipped", []config.DiscoveryRule{rule("slice", "", "_system"), rule("compose", "", "$name")}, "shop"},
+		{"underscore prefix allowed", []config.DiscoveryRule{rule("compose", "", "_${name}"), rule("unit", "", "_agent")}, "_shop"},
+		{"reserved names dropped", []config.DiscoveryRule{rule("compose", "", "_groups"), rule("unit", "", "_agent")}, ""},
+	}
+	for _, tt := range tests {
+		if got, _ := discoverProject(tt.rules, f); got != tt.want {
+			t.Errorf("%s: discoverProject() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package mapper
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	containerCacheTTL          = 5 * time.Minute // Cached containers are re-inspected after this, on lookup or reconcile, picking up label changes
+	containerReconcileInterval = time.Minute     // How often cached containers are checked against `<cli> ps`
+	cliRetryInterval           = 5 * time.Minute // How long to wait before looking for a missing CLI again
+)
+
+type containerCacheEntry struct {
+	container containerInfo
+	fetched   time.Time
+}
+
+var (
+	containerCache         = make(map[ContainerRef]containerCacheEntry)
+	containerCacheMutex    = &sync.RWMutex{}
+	cliMissingSince        = make(map[string]time.Time) // CLI -> when it was found missing; guarded by containerCacheMutex
+	containerLastReconcile time.Time                    // Guarded by containerCacheMutex
+
+	containerCacheHits      atomic.Uint64
+	containerCacheMisses    atomic.Uint64
+	containerCacheRefreshes atomic.Uint64
+	containerCacheEvictions atomic.Uint64
+
+	// containerGeneration changes whenever cached container data changed in a way
+	// that can change process mappings, so MapProcesses drops its cache.
+	containerGeneration atomic.Uint64
+
+	// runCLI and lookPath run and find runtime CLIs; tests replace them with a fake.
+	runCLI   = func(cmd *exec.Cmd) ([]byte, error) { return cmd.Output() }
+	lookPath = exec.LookPath
+)
+
+// DockerCacheStats are the container inspect cache's counters since the agent
+// started. They cover every runtime inspected through a CLI (docker, podman, nerdctl).
+type DockerCacheStats struct {
+	Hits         uint64 `json:"hits"`
+	Misses       uint64 `json:"misses"`    // `<cli> inspect` runs, refreshes included
+	Refreshes    uint64 `json:"refreshes"` // Entries re-inspected after their TTL
+	Evictions    uint64 `json:"evictions"` // Entries dropped because their container is gone
+	Entries      int    `json:"entries"`
+	CLIAvailable bool   `json:"cli_available"` // The docker CLI
+}
+
+// GetDockerCacheStats returns the container inspect cache's counters.
+func GetDockerCacheStats() DockerCacheStats {
+	containerCacheMutex.RLock()
+	defer containerCacheMutex.RUnlock()
+	return DockerCacheStats{
+		Hits:         containerCacheHits.Load(),
+		Misses:       containerCacheMisses.Load(),
+		Refreshes:    containerCacheRefreshes.Load(),
+		Evictions:    containerCacheEvictions.Load(),
+		Entries:      len(containerCache),
+		CLIAvailable: cliMissingSince["docker"].IsZero(),
+	}
+}
+
+// cliBackend inspects containers with a docker-compatible CLI: docker, podman
+// and nerdctl all print `inspect` output with Name and Config.Labels. Results
+// are cached per container and re-inspected after containerCacheTTL.
+type cliBackend struct {
+	cli           string // e.g. "podman"
+	namespaceFlag string // Flag selecting the containerd namespace, for runtimes that have them
+}
+
+func (b cliBackend) command(ref ContainerRef, args ...string) *exec.Cmd {
+	if b.namespaceFlag != "" && ref.Namespace != "" {
+		args = append([]string{b.namespaceFlag, ref.Namespace}, args...)
+	}
+	return exec.Command(b.cli, args...)
+}
+
+func (b cliBackend) inspect(ref ContainerRef) (containerInfo, error) {
+	if !cliAvailable(b.cli) {
+		return containerInfo{}, fmt.Errorf("%s CLI not found, skipping inspect", b.cli)
+	}
+	containerCacheMutex.RLock()
+	entry, found := containerCache[ref]
+	containerCacheMutex.RUnlock()
+	if found && time.Since(entry.fetched) < containerCacheTTL {
+		containerCacheHits.Add(1)
+		return entry.container, nil
+	}
+	containerCacheMisses.Add(1)
+	if found {
+		containerCacheRefreshes.Add(1)
+	}
+	return b.fetch(ref)
+}
+
+// fetch runs `<cli> inspect` for ref and caches the result. The CLI runs
+// without containerCacheMutex held, so a slow runtime does not hold up
+// lookups of cached containers; concurrent misses may inspect the same
+// container twice. A changed name or labels bump containerGeneration.
+func (b cliBackend) fetch(ref ContainerRef) (containerInfo, error) {
+	output, err := runCLI(b.command(ref, "inspect", ref.ID))
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			log.Printf("%s inspect for %s failed: %s, stderr: %s", b.cli, ref.ID, err, string(exitErr.Stderr))
+		} else if errors.Is(err, exec.ErrNotFound) {
+			log.Printf("%s CLI not found. Label and container name matching for %s containers are disabled, retrying in %s.", b.cli, ref.Runtime, cliRetryInterval)
+			containerCacheMutex.Lock()
+			cliMissingSince[b.cli] = time.Now()
+			containerCacheMutex.Unlock()
+			return containerInfo{}, fmt.Errorf("%s CLI not found: %w", b.cli, err)
+		} else {
+			log.Printf("%s inspect for %s failed: %s", b.cli, ref.ID, err)
+		}
+		return containerInfo{}, fmt.Errorf("%s inspect command failed for %s: %w", b.cli, ref.ID, err)
+	}
+	container, err := parseInspectOutput(output)
+	if err != nil {
+		return containerInfo{}, fmt.Errorf("failed to unmarshal %s inspect output for %s: %w", b.cli, ref.ID, err)
+	}
+
+	containerCacheMutex.Lock()
+	defer containerCacheMutex.Unlock()
+	if entry, found := containerCache[ref]; found && !sameContainer(entry.container, container) {
+		log.Printf("mapper: container %s changed name or labels, re-mapping processes", ref)
+		containerGeneration.Add(1)
+	}
+	containerCache[ref] = containerCacheEntry{container: container, fetched: time.Now()}
+	return container, nil
+}
+
+// parseInspectOutput reads the name and labels from docker-compatible inspect output.
+func parseInspectOutput(output []byte) (containerInfo, error) {
+	var inspectOutput []struct {
+		Name   string `json:"Name"`
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"Config"`
+	}
+	if err := json.Unmarshal(output, &inspectOutput); err != nil {
+		return containerInfo{}, err
+	}
+
+	container := containerInfo{Labels: map[string]string{}} // Cache empty labels if there are none
+	if len(inspectOutput) > 0 {
+		container.Name = strings.TrimPrefix(inspectOutput[0].Name, "/") // Docker prefixes names with "/", podman does not
+		if inspectOutput[0].Config.Labels != nil {
+			container.Labels = inspectOutput[0].Config.Labels
+		}
+	}
+	return container, nil
+}
+
+// cliAvailable reports whether a runtime CLI can be used. After it was found
+// missing, it is looked for again every cliRetryInterval, so a runtime
+// installed after the agent started is picked up without a restart.
+func cliAvailable(cli string) bool {
+	containerCacheMutex.RLock()
+	missingSince := cliMissingSince[cli]
+	containerCacheMutex.RUnlock()
+	if missingSince.IsZero() {
+		return true
+	}
+	if time.Since(missingSince) < cliRetryInterval {
+		return false
+	}
+
+	containerCacheMutex.Lock()
+	defer containerCacheMutex.Unlock()
+	if cliMissingSince[cli].IsZero() {
+		return true
+	}
+	if _, err := lookPath(cli); err != nil {
+		cliMissingSince[cli] = time.Now()
+		return false
+	}
+	log.Printf("%s CLI found. Label and container name matching for its containers are enabled.", cli)
+	delete(cliMissingSince, cli)
+	containerGeneration.Add(1) // Processes mapped without container data must be mapped again
+	return true
+}
+
+// reconcileContainerCache evicts cached containers that are no longer
+// running, listing the containers of each runtime (and containerd namespace)
+// with cached entries once, and re-inspects running ones older than
+// containerCacheTTL. Processes keep their cached mapping and never look their
+// container up again, so this is what picks up label changes for them. It
+// runs at most once per containerReconcileInterval and only if something is
+// cached.
+func reconcileContainerCache() {
+	containerCacheMutex.Lock()
+	if len(containerCache) == 0 || time.Since(containerLastReconcile) < containerReconcileInterval {
+		containerCacheMutex.Unlock()
+		return
+	}
+	containerLastReconcile = time.Now()
+	listings := make(map[ContainerRef]bool) // Runtime and namespace only
+	for ref := range containerCache {
+		listings[ContainerRef{Runtime: ref.Runtime, Namespace: ref.Namespace}] = true
+	}
+	containerCacheMutex.Unlock()
+
+	for listing := range listings {
+		b, ok := containerBackends[listing.Runtime].(cliBackend)
+		if !ok || !cliAvailable(b.cli) {
+			continue
+		}
+		output, err := runCLI(b.command(listing, "ps", "--quiet", "--no-trunc"))
+		if err != nil {
+			log.Printf("mapper: %s ps failed, keeping cached containers: %v", b.cli, err)
+			continue
+		}
+		running := strings.Fields(string(output))
+
+		var expired []ContainerRef
+		containerCacheMutex.Lock()
+		maps.DeleteFunc(containerCache, func(ref ContainerRef, entry containerCacheEntry) bool {
+			if ref.Runtime != listing.Runtime || ref.Namespace != listing.Namespace {
+				return false
+			}
+			for _, r := range running {
+				if strings.HasPrefix(r, ref.ID) { // Cgroup paths may hold a short ID
+					if time.Since(entry.fetched) >= containerCacheTTL {
+						expired = append(expired, ref)
+					}
+					return false
+				}
+			}
+			containerCacheEvictions.Add(1)
+			return true
+		})
+		containerCacheMutex.Unlock()
+
+		for _, ref := range expired {
+			containerCacheMisses.Add(1)
+			containerCacheRefreshes.Add(1)
+			b.fetch(ref) // On failure the entry is kept and tried again next time
+		}
+	}
+}
+
+// sameContainer reports whether two inspections returned the same name and labels.
+func sameContainer(a, b containerInfo) bool {
+	return a.Name == b.Name && maps.Equal(a.Labels, b.Labels)
+}
@@ -0,0 +1,310 @@
+package mapper
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"vps-screener/agent/config"
+)
+
+// fakeDocker stands in for the docker CLI: it answers `inspect` and `ps` from
+// containers and counts the commands run.
+type fakeDocker struct {
+	containers map[string]string // Running container ID -> compose project label; IDs can be abbreviated
+	missing    bool              // The CLI is not installed
+	psFails    bool
+	commands   int
+}
+
+func (d *fakeDocker) run(cmd *exec.Cmd) ([]byte, error) {
+	if d.missing {
+		return nil, &exec.Error{Name: cmd.Args[0], Err: exec.ErrNotFound}
+	}
+	d.commands++
+	switch cmd.Args[1] {
+	case "inspect":
+		for id, project := range d.containers {
+			if strings.HasPrefix(id, cmd.Args[2]) {
+				return []byte(fmt.Sprintf(`[{"Name":"/%s_web_1","Config":{"Labels":{%q:%q}}}]`, project, composeProjectLabel, project)), nil
+			}
+		}
+		return nil, errors.New("exit status 1")
+	case "ps":
+		if d.psFails {
+			return nil, errors.New("exit status 1")
+		}
+		var ids []string
+		for id := range d.containers {
+			ids = append(ids, id)
+		}
+		return []byte(strings.Join(ids, "\n") + "\n"), nil
+	}
+	return nil, fmt.Errorf("unexpected command %q", cmd.Args)
+}
+
+func (d *fakeDocker) lookPath(file string) (string, error) {
+	if d.missing {
+		return "", &exec.Error{Name: file, Err: exec.ErrNotFound}
+	}
+	return "/usr/bin/" + file, nil
+}
+
+// useFakeDocker replaces the docker CLI with a fake and empties the container cache.
+func useFakeDocker(t *testing.T) *fakeDocker {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	d := &fakeDocker{containers: map[string]string{testContainerID: "shop"}}
+	runCLI, lookPath = d.run, d.lookPath
+	resetContainerCache()
+	t.Cleanup(func() {
+		runCLI = func(cmd *exec.Cmd) ([]byte, error) { return cmd.Output() }
+		lookPath = exec.LookPath
+		resetContainerCache()
+		log.SetOutput(os.Stderr)
+	})
+	return d
+}
+
+func resetContainerCache() {
+	containerCacheMutex.Lock()
+	defer containerCacheMutex.Unlock()
+	containerCache = make(map[ContainerRef]containerCacheEntry)
+	cliMissingSince = make(map[string]time.Time)
+	containerLastReconcile = time.Time{}
+}
+
+// backdate makes the cache entry of ref look fetched age ago.
+func backdate(ref ContainerRef, age time.Duration) {
+	containerCacheMutex.Lock()
+	defer containerCacheMutex.Unlock()
+	entry := containerCache[ref]
+	entry.fetched = time.Now().Add(-age)
+	containerCache[ref] = entry
+}
+
+var testDockerRef = ContainerRef{Runtime: RuntimeDocker, ID: testContainerID}
+
+func TestDockerCacheHitsAndMisses(t *testing.T) {
+	d := useFakeDocker(t)
+	before := GetDockerCacheStats()
+	for i := 0; i < 3; i++ {
+		c, err := inspectContainer(testDockerRef)
+		if err != nil || c.Name != "shop_web_1" || c.Labels[composeProjectLabel] != "shop" {
+			t.Fatalf("inspectContainer = %+v, %v", c, err)
+		}
+	}
+	stats := GetDockerCacheStats()
+	if d.commands != 1 || stats.Misses-before.Misses != 1 || stats.Hits-before.Hits != 2 || stats.Entries != 1 || !stats.CLIAvailable {
+		t.Errorf("after 3 lookups: %d commands, stats %+v (before %+v)", d.commands, stats, before)
+	}
+
+	// Failed inspections are not cached.
+	if _, err := inspectContainer(ContainerRef{Runtime: RuntimeDocker, ID: "gone"}); err == nil {
+		t.Errorf("inspecting an unknown container succeeded")
+	}
+	if stats := GetDockerCacheStats(); stats.Entries != 1 {
+		t.Errorf("entries = %d after a failed inspect, want 1", stats.Entries)
+	}
+}
+
+func TestDockerCacheRefresh(t *testing.T) {
+	d := useFakeDocker(t)
+	if _, err := inspectContainer(testDockerRef); err != nil {
+		t.Fatal(err)
+	}
+	before, generation := GetDockerCacheStats(), containerGeneration.Load()
+
+	// Unchanged labels after the TTL: re-inspected, mappings are kept.
+	backdate(testDockerRef, containerCacheTTL)
+	if _, err := inspectContainer(testDockerRef); err != nil {
+		t.Fatal(err)
+	}
+	stats := GetDockerCacheStats()
+	if d.commands != 2 || stats.Refreshes-before.Refreshes != 1 || stats.Misses-before.Misses != 1 || containerGeneration.Load() != generation {
+		t.Errorf("refresh: %d commands, stats %+v (before %+v)", d.commands, stats, before)
+	}
+
+	// Changed labels: picked up after the TTL, and mappings are dropped.
+	d.containers[testContainerID] = "billing"
+	if c, _ := inspectContainer(testDockerRef); c.Labels[composeProjectLabel] != "shop" {
+		t.Errorf("label changed before the TTL: %v", c.Labels)
+	}
+	backdate(testDockerRef, containerCacheTTL)
+	if c, _ := inspectContainer(testDockerRef); c.Labels[composeProjectLabel] != "billing" {
+		t.Errorf("label after the TTL = %v, want billing", c.Labels)
+	}
+	if containerGeneration.Load() == generation {
+		t.Errorf("label change did not bump containerGeneration")
+	}
+}
+
+func TestDockerCacheReconcile(t *testing.T) {
+	d := useFakeDocker(t)
+	const other = "0123456789ab" // Short ID from a cgroupfs path
+	d.containers[other+strings.Repeat("0", 52)] = "blog"
+	for _, id := range []string{testContainerID, other} {
+		if _, err := inspectContainer(ContainerRef{Runtime: RuntimeDocker, ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	before := GetDockerCacheStats()
+
+	// Nothing stopped: both stay, the short ID matched by prefix.
+	reconcileContainerCache()
+	if stats := GetDockerCacheStats(); stats.Entries != 2 || stats.Evictions != before.Evictions {
+		t.Errorf("nothing stopped: stats %+v", stats)
+	}
+
+	// Not again before containerReconcileInterval passed.
+	delete(d.containers, testContainerID)
+	commands := d.commands
+	reconcileContainerCache()
+	if d.commands != commands {
+		t.Errorf("ps ran again within containerReconcileInterval")
+	}
+
+	// A failing ps keeps everything.
+	d.psFails = true
+	containerLastReconcile = time.Time{}
+	reconcileContainerCache()
+	if stats := GetDockerCacheStats(); stats.Entries != 2 {
+		t.Errorf("after ps failed: %d entries, want 2", stats.Entries)
+	}
+
+	d.psFails = false
+	containerLastReconcile = time.Time{}
+	reconcileContainerCache()
+	stats := GetDockerCacheStats()
+	if stats.Entries != 1 || stats.Evictions-before.Evictions != 1 {
+		t.Errorf("after a container stopped: stats %+v (before %+v)", stats, before)
+	}
+	containerCacheMutex.RLock()
+	_, cached := containerCache[ContainerRef{Runtime: RuntimeDocker, ID: other}]
+	containerCacheMutex.RUnlock()
+	if !cached {
+		t.Errorf("running container evicted")
+	}
+}
+
+func TestDockerInspectDoesNotBlockCachedLookups(t *testing.T) {
+	d := useFakeDocker(t)
+	if _, err := inspectContainer(testDockerRef); err != nil {
+		t.Fatal(err)
+	}
+
+	// A slow inspect of another container is in progress.
+	started, release := make(chan struct{}), make(chan struct{})
+	runCLI = func(cmd *exec.Cmd) ([]byte, error) {
+		close(started)
+		<-release
+		return d.run(cmd)
+	}
+	d.containers["feedbeef"] = "blog"
+	done := make(chan struct{})
+	go func() {
+		inspectContainer(ContainerRef{Runtime: RuntimeDocker, ID: "feedbeef"})
+		close(done)
+	}()
+	<-started
+	defer func() {
+		close(release)
+		<-done
+	}()
+
+	cached := make(chan containerInfo, 1)
+	go func() {
+		c, _ := inspectContainer(testDockerRef)
+		cached <- c
+	}()
+	select {
+	case c := <-cached:
+		if c.Name != "shop_web_1" {
+			t.Errorf("cached lookup = %+v", c)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("cached lookup blocked by a running inspect")
+	}
+}
+
+func TestDockerLabelChangeRemapsMappedProcess(t *testing.T) {
+	d := useFakeDocker(t)
+	ResetCache()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, fmt.Sprint(fakePIDBase)), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	cgroup := "0::/system.slice/docker-" + testContainerID + ".scope\n"
+	if err := os.WriteFile(filepath.Join(dir, fmt.Sprint(fakePIDBase), "cgroup"), []byte(cgroup), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	pattern := cgroupPathPattern
+	cgroupPathPattern = filepath.Join(dir, "%d", "cgroup")
+	t.Cleanup(func() { cgroupPathPattern = pattern })
+
+	cfg := &config.Config{Projects: []config.ProjectConfig{
+		{Name: "shop", Match: config.MatchRules{DockerLabel: composeProjectLabel + "=shop"}},
+		{Name: "billing", Match: config.MatchRules{DockerLabel: composeProjectLabel + "=billing"}},
+	}}
+	procs := syntheticProcesses(1)
+	if got := MapProcesses(procs, cfg)[fakePIDBase].Project; got != "shop" {
+		t.Fatalf("container process mapped to %q, want shop", got)
+	}
+
+	// The process keeps running, so its mapping stays cached and it never
+	// looks its container up again; reconcile re-inspects the container.
+	d.containers[testContainerID] = "billing"
+	containerLastReconcile = time.Time{}
+	if got := MapProcesses(procs, cfg)[fakePIDBase].Project; got != "shop" {
+		t.Errorf("label change picked up before the TTL: mapped to %q", got)
+	}
+	backdate(testDockerRef, containerCacheTTL)
+	containerLastReconcile = time.Time{}
+	if got := MapProcesses(procs, cfg)[fakePIDBase].Project; got != "billing" {
+		t.Errorf("after the label changed, process mapped to %q, want billing", got)
+	}
+}
+
+func TestDockerCLIRetry(t *testing.T) {
+	d := useFakeDocker(t)
+	d.missing = true
+	if _, err := inspectContainer(testDockerRef); !errors.Is(err, exec.ErrNotFound) {
+		t.Fatalf("inspect without a CLI: %v", err)
+	}
+	if GetDockerCacheStats().CLIAvailable {
+		t.Errorf("CLI reported available")
+	}
+
+	// Installed meanwhile, but not looked for before cliRetryInterval.
+	d.missing = false
+	if _, err := inspectContainer(testDockerRef); err == nil || d.commands != 0 {
+		t.Errorf("CLI used within cliRetryInterval: %v, %d commands", err, d.commands)
+	}
+
+	generation := containerGeneration.Load()
+	containerCacheMutex.Lock()
+	cliMissingSince["docker"] = time.Now().Add(-cliRetryInterval)
+	containerCacheMutex.Unlock()
+	if c, err := inspectContainer(testDockerRef); err != nil || c.Name != "shop_web_1" {
+		t.Errorf("after cliRetryInterval: %+v, %v", c, err)
+	}
+	if !GetDockerCacheStats().CLIAvailable || containerGeneration.Load() == generation {
+		t.Errorf("found CLI not reported, or mappings kept")
+	}
+
+	// Still missing after the retry: another cliRetryInterval to wait.
+	d.missing = true
+	containerCacheMutex.Lock()
+	cliMissingSince["docker"] = time.Now().Add(-cliRetryInterval)
+	containerCacheMutex.Unlock()
+	if cliAvailable("docker") || cliAvailable("docker") {
+		t.Errorf("missing CLI reported available")
+	}
+}
@@ -0,0 +1,189 @@
+package mapper
+
+import (
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/elastic/go-sysinfo/types"
+	"vps-screener/agent/config"
+)
+
+// Explain writes a report of how processes are mapped: for each process (or
+// only the one with the given PID, if pid is non-zero) the observed attributes,
+// every project's rules with their outcome, and the project that won. It ends
+// with the configured projects that no process on the host maps to.
+func Explain(w io.Writer, processes []types.Process, cfg *config.Config, pid int) error {
+	sort.Slice(processes, func(i, j int) bool { return processes[i].PID() < processes[j].PID() })
+	mappings := MapProcesses(processes, cfg)
+
+	sockets := newListenSockets()
+	found := false
+	for _, p := range processes {
+		if pid != 0 && p.PID() != pid {
+			continue
+		}
+		found = true
+		info, infoErr := p.Info()
+		facts := newProcessFacts(p, info, infoErr)
+		facts.sockets = sockets
+		facts.kubernetes = cfg.AgentSettings.Kubernetes
+		explainProcess(w, facts, infoErr, cfg, mappings[p.PID()])
+	}
+	if pid != 0 && !found {
+		return fmt.Errorf("no process with PID %d", pid)
+	}
+
+	used := make(map[string]bool)
+	for _, m := range mappings {
+		used[m.Project] = true
+	}
+	var unused []string
+	for _, proj := range cfg.Projects {
+		if !used[proj.Name] {
+			unused = append(unused, proj.Name)
+		}
+	}
+	if len(unused) == 0 {
+		fmt.Fprintf(w, "Every project matches at least one of %d processes.\n", len(processes))
+	} else {
+		fmt.Fprintf(w, "Projects matching none of %d processes:\n", len(processes))
+		for _, name := range unused {
+			fmt.Fprintf(w, "  %s\n", name)
+		}
+	}
+	return nil
+}
+
+func explainProcess(w io.Writer, f *processFacts, infoErr error, cfg *config.Config, mapping Mapping) {
+	fmt.Fprintf(w, "PID %d (%s)\n", f.PID, orNone(f.Name, "<unknown>"))
+	if infoErr != nil {
+		fmt.Fprintf(w, "  warning: process info incomplete: %v\n", infoErr)
+	}
+	fmt.Fprintf(w, "  user:         %s\n", orNone(f.Username, "<unknown>"))
+	fmt.Fprintf(w, "  exe:          %s\n", orNone(f.Exe, "<unknown>"))
+	fmt.Fprintf(w, "  cmdline:      %s\n", orNone(f.CmdLine, "<none>"))
+	fmt.Fprintf(w, "  cwd:          %s\n", orNone(f.Cwd, "<unknown>"))
+	if f.socketTable() == nil {
+		fmt.Fprintf(w, "  listening:    <sockets not readable>\n")
+	} else {
+		fmt.Fprintf(w, "  listening:    %s\n", orNone(formatPorts(f.listenPorts()), "<none>"))
+	}
+	fmt.Fprintf(w, "  systemd unit: %s\n", orNone(f.systemdCgroup().Unit(), "<none>"))
+	fmt.Fprintf(w, "  cgroup:       %s\n", orNone(f.systemdCgroup().Path, "<unknown>"))
+	if c := f.runtimeContainer(); c != nil {
+		fmt.Fprintf(w, "  container:    %s (%s)\n", f.containerRef, orNone(c.Name, "<unnamed>"))
+		keys := make([]string, 0, len(c.Labels))
+		for k := range c.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "    label %s=%s\n", k, c.Labels[k])
+		}
+	} else if !f.containerRef.IsZero() {
+		fmt.Fprintf(w, "  container:    %s (inspect failed)\n", f.containerRef)
+	} else {
+		fmt.Fprintf(w, "  container:    <none>\n")
+	}
+	if pod := f.kubePod(); pod != nil {
+		fmt.Fprintf(w, "  pod:          %s/%s (%s)\n", orNone(pod.Namespace, "<unknown namespace>"), orNone(pod.Name, "<unknown name>"), pod.UID)
+		keys := make([]string, 0, len(pod.Labels))
+		for k := range pod.Labels {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(w, "    label %s=%s\n", k, pod.Labels[k])
+		}
+	} else if f.podUID != "" {
+		fmt.Fprintf(w, "  pod:          %s (not found via kubelet)\n", f.podUID)
+	}
+
+	for _, proj := range cfg.Projects {
+		ok, _ := evalMatch(proj.Match, f)
+		fmt.Fprintf(w, "  project %s: %s\n", proj.Name, matchWord(ok))
+		if proj.Match.IsZero() {
+			fmt.Fprintf(w, "    (no match rules)\n")
+		}
+		explainNode(w, proj.Match, f, "    ")
+	}
+
+	for i, rule := range cfg.Discovery {
+		name, from := evalDiscovery(rule, f)
+		if name == "" {
+			fmt.Fprintf(w, "  discovery[%d] %s: nothing discovered\n", i, rule.Source)
+		} else {
+			fmt.Fprintf(w, "  discovery[%d] %s: project %s from %s\n", i, rule.Source, name, from)
+		}
+	}
+
+	for _, group := range cfg.Groups {
+		ok := slices.Contains(mapping.Groups, group.Name)
+		fmt.Fprintf(w, "  group %s: %s\n", group.Name, matchWord(ok))
+		explainNode(w, group.Match, f, "    ")
+		if len(group.Projects) > 0 {
+			inProject := mapping.Project != "" && slices.Contains(group.Projects, mapping.Project)
+			fmt.Fprintf(w, "    projects %q: %s, observed %s\n", strings.Join(group.Projects, ", "), matchWord(inProject), orNone(mapping.Project, "<no project>"))
+		}
+	}
+
+	switch {
+	case mapping.Project == "":
+		fmt.Fprintf(w, "  => no project\n")
+	case mapping.Inherited:
+		fmt.Fprintf(w, "  => %s (inherited from PID %d)\n", mapping.Project, mapping.FromPID)
+	case mapping.Discovered:
+		fmt.Fprintf(w, "  => %s (discovered)\n", mapping.Project)
+	default:
+		fmt.Fprintf(w, "  => %s (first matching project)\n", mapping.Project)
+	}
+	if len(mapping.Groups) > 0 {
+		fmt.Fprintf(w, "  => groups: %s\n", strings.Join(mapping.Groups, ", "))
+	}
+	fmt.Fprintln(w)
+}
+
+// explainNode writes the outcome of each part of a match expression.
+func explainNode(w io.Writer, m config.MatchRules, f *processFacts, indent string) {
+	var criteria []criterion
+	eachCriterion(m, f, func(c criterion) bool {
+		criteria = append(criteria, c)
+		return true
+	})
+	for i, c := range criteria {
+		prefix := ""
+		if i > 0 {
+			prefix = "and "
+		}
+		fmt.Fprintf(w, "%s%s%s %q: %s, observed %s\n", indent, prefix, c.Field, c.Want, matchWord(c.Matched), c.Observed)
+	}
+	for i, sub := range m.All {
+		ok, _ := evalMatch(sub, f)
+		fmt.Fprintf(w, "%sall[%d]: %s\n", indent, i, matchWord(ok))
+		explainNode(w, sub, f, indent+"  ")
+	}
+	for i, sub := range m.Any {
+		ok, _ := evalMatch(sub, f)
+		fmt.Fprintf(w, "%sany[%d]: %s\n", indent, i, matchWord(ok))
+		explainNode(w, sub, f, indent+"  ")
+	}
+	if m.Not != nil {
+		ok, _ := evalMatch(*m.Not, f)
+		if ok {
+			fmt.Fprintf(w, "%snot: no match, the excluded expression matches\n", indent)
+		} else {
+			fmt.Fprintf(w, "%snot: match, the excluded expression does not match\n", indent)
+		}
+		explainNode(w, *m.Not, f, indent+"  ")
+	}
+}
+
+func matchWord(ok bool) string {
+	if ok {
+		return "match"
+	}
+	return "no match"
+}
@@ -0,0 +1,92 @@
+package mapper
+
+import (
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"vps-screener/agent/config"
+)
+
+func TestExplainProcess(t *testing.T) {
+	cfg := &config.Config{Projects: []config.ProjectConfig{
+		{Name: "api", Match: parseMatch(t, "user: api")},
+		{Name: "web", Match: parseMatch(t, "systemd_unit: web.service\nuser: webapp")},
+		{Name: "billing", Match: parseMatch(t, "any:\n  - env: PROJECT=billing\n  - listen_port: 9000")},
+	}}
+	var b strings.Builder
+	explainProcess(&b, webFacts(), nil, cfg, Mapping{Project: "web"})
+	want := `PID 1234 (node)
+  user:         webapp
+  exe:          /usr/bin/node
+  cmdline:      node /srv/web/server.js
+  cwd:          /srv/web/current
+  listening:    8080
+  systemd unit: web.service
+  cgroup:       /system.slice/web.service
+  container:    <none>
+  project api: no match
+    user "api": no match, observed webapp
+  project web: match
+    systemd_unit "web.service": match, observed /system.slice/web.service
+    and user "webapp": match, observed webapp
+  project billing: match
+    any[0]: match
+      env "PROJECT=billing": match, observed PROJECT=billing
+    any[1]: no match
+      listen_port "9000": no match, observed <no listener on these ports>
+  => web (first matching project)
+
+`
+	if got := b.String(); got != want {
+		t.Errorf("explain output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestExplain(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	ResetCache()
+	cfg := syntheticConfig(t)
+
+	// One process: its report ends with the project MapProcesses chose, and
+	// the projects no process on the host maps to follow.
+	var b strings.Builder
+	if err := Explain(&b, syntheticProcesses(16), cfg, fakePIDBase+1); err != nil {
+		t.Fatal(err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"PID 5000001 (node)\n",
+		"  cmdline:      node --worker 1\n",
+		"  project web: no match\n",
+		"  project app: match\n    process_name_pattern \"^(node|java)$\": match, observed name node\n",
+		"  => app (first matching project)\n",
+		"Projects matching none of 16 processes:\n  web\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("explain output lacks %q:\n%s", want, out)
+		}
+	}
+	if n := strings.Count(out, "PID "); n != 1 {
+		t.Errorf("%d processes explained, want 1", n)
+	}
+	if strings.Contains(out, "  app\n") || strings.Contains(out, "  data\n") {
+		t.Errorf("projects in use listed as matching nothing:\n%s", out)
+	}
+
+	b.Reset()
+	cfg.Projects = cfg.Projects[2:3] // app
+	if err := Explain(&b, syntheticProcesses(16), cfg, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out := b.String(); strings.Count(out, "PID ") != 16 || !strings.HasSuffix(out, "Every project matches at least one of 16 processes.\n") {
+		t.Errorf("explain of all processes:\n%s", out)
+	}
+
+	if err := Explain(io.Discard, syntheticProcesses(16), cfg, 42); err == nil || err.Error() != "no process with PID 42" {
+		t.Errorf("unknown PID: error %v", err)
+	}
+}
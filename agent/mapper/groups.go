@@ -0,0 +1,34 @@
+package mapper
+
+import (
+	"slices"
+
+	"vps-screener/agent/config"
+)
+
+// matchGroups returns the groups whose match rules the process matches, in
+// config order. Unlike projects, every matching group counts.
+func matchGroups(groups []config.GroupConfig, f *processFacts) []string {
+	var names []string
+	for _, group := range groups {
+		if group.Match.IsZero() {
+			continue
+		}
+		if ok, _ := evalMatch(group.Match, f); ok {
+			names = append(names, group.Name)
+		}
+	}
+	return names
+}
+
+// withProjectGroups returns the groups of a process mapped to project: the
+// groups it matched by rules plus the groups listing the project, in config order.
+func withProjectGroups(groups []config.GroupConfig, project string, matched []string) []string {
+	var names []string
+	for _, group := range groups {
+		if slices.Contains(matched, group.Name) || (project != "" && slices.Contains(group.Projects, project)) {
+			names = append(names, group.Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,72 @@
+package mapper
+
+import (
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"vps-screener/agent/config"
+)
+
+func TestMapProcessesGroups(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	ResetCache()
+
+	cfg := &config.Config{
+		AgentSettings: config.AgentSettings{ProcessInheritance: true},
+		Projects: []config.ProjectConfig{
+			{Name: "app", Match: config.MatchRules{ProcessNamePattern: config.ProcessPattern{Pattern: "^node$"}}},
+			{Name: "data", Match: config.MatchRules{Exe: "/usr/bin/postgres"}},
+		},
+		Groups: []config.GroupConfig{
+			{Name: "tier:db", Match: config.MatchRules{Exe: "/usr/bin/postgres"}},
+			{Name: "team:platform", Projects: []string{"app", "data"}},
+			{Name: "lang:shell", Match: config.MatchRules{ProcessNamePattern: config.ProcessPattern{Pattern: "^bash$"}}},
+			{Name: "team:data", Projects: []string{"data"}},
+		},
+	}
+	for i := range cfg.Projects {
+		if err := cfg.Projects[i].Match.Compile("match"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := range cfg.Groups {
+		if err := cfg.Groups[i].Match.Compile("match"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 0 nginx (init's child), 1 node <- 0, 2 postgres <- 1, 3 python3 <- 2,
+	// 4 bash (init's child), 5 cron <- 4
+	mappings := MapProcesses(syntheticProcesses(6), cfg)
+	tests := []struct {
+		pid     int
+		project string
+		groups  []string
+	}{
+		{fakePIDBase + 1, "app", []string{"team:platform"}},
+		{fakePIDBase + 2, "data", []string{"tier:db", "team:platform", "team:data"}},
+		{fakePIDBase + 3, "data", []string{"team:platform", "team:data"}}, // Inherited project, but not the rule-based group
+		{fakePIDBase + 4, "", []string{"lang:shell"}},
+	}
+	for _, tt := range tests {
+		m, ok := mappings[tt.pid]
+		if !ok {
+			t.Errorf("PID %d not mapped", tt.pid)
+			continue
+		}
+		if m.Project != tt.project || !reflect.DeepEqual(m.Groups, tt.groups) {
+			t.Errorf("PID %d = %q %v, want %q %v", tt.pid, m.Project, m.Groups, tt.project, tt.groups)
+		}
+	}
+	// A group-only process passes nothing on to its children.
+	if m, ok := mappings[fakePIDBase+5]; ok {
+		t.Errorf("child of a group-only process mapped to %+v", m)
+	}
+	if _, ok := mappings[fakePIDBase]; ok {
+		t.Errorf("nginx mapped without a matching project or group")
+	}
+}
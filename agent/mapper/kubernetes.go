@@ -0,0 +1,243 @@
+package mapper
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"vps-screener/agent/config"
+)
+
+const (
+	defaultKubeletDir    = "/var/lib/kubelet"
+	kubePodCacheTTL      = 5 * time.Minute  // Cached pods are looked up again after this, picking up label changes
+	kubeletFetchInterval = 30 * time.Second // Least time between two requests to the kubelet's /pods
+)
+
+// kubePod is the part of a pod's metadata the mapper uses.
+type kubePod struct {
+	UID       string
+	Namespace string
+	Name      string
+	Labels    map[string]string // Never nil
+}
+
+type kubePodCacheEntry struct {
+	pod     kubePod
+	fetched time.Time
+}
+
+var (
+	kubePodCache      = make(map[string]kubePodCacheEntry) // Pod UID -> pod
+	kubePodCacheMutex = &sync.Mutex{}
+	kubeletLastFetch  time.Time // Guarded by kubePodCacheMutex
+	kubeLastPrune     time.Time // Guarded by kubePodCacheMutex
+
+	// kubeGeneration changes whenever a cached pod's labels changed, so
+	// MapProcesses drops its cache like for containerGeneration.
+	kubeGeneration atomic.Uint64
+
+	kubeletClient = &http.Client{Timeout: 5 * time.Second}
+)
+
+var (
+	// Pod UIDs appear with dashes (cgroupfs driver) or underscores (systemd driver).
+	kubePodUIDPattern      = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+	kubeContainerIDPattern = regexp.MustCompile(`([0-9a-f]{64})(?:\.scope)?$`)
+)
+
+// parseKubePodCgroup returns the pod UID and container ID from the lines of
+// /proc/<pid>/cgroup of a process in a Kubernetes pod, or "" if it is not in one:
+//
+//	cgroupfs driver: /kubepods/burstable/pod<uid>/<container id>
+//	systemd driver:  /kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod<uid with _>.slice/cri-containerd-<container id>.scope
+//
+// The container ID is "" for a process directly in the pod's cgroup.
+func parseKubePodCgroup(lines []string) (podUID, containerID string) {
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 || !strings.Contains(parts[2], "kubepods") {
+			continue
+		}
+		m := kubePodUIDPattern.FindStringSubmatch(parts[2])
+		if m == nil {
+			continue
+		}
+		if c := kubeContainerIDPattern.FindStringSubmatch(path.Base(parts[2])); c != nil {
+			containerID = c[1]
+		}
+		return strings.ReplaceAll(m[1], "_", "-"), containerID
+	}
+	return "", ""
+}
+
+// lookupKubePod returns the pod with the given UID. It asks the kubelet's
+// read-only endpoint, if configured, and falls back to the kubelet's pod
+// directory. Pods are cached for kubePodCacheTTL.
+func lookupKubePod(uid string, settings config.Kubernetes) (kubePod, bool) {
+	kubePodCacheMutex.Lock()
+	defer kubePodCacheMutex.Unlock()
+	pruneKubePodCache()
+
+	entry, found := kubePodCache[uid]
+	if found && time.Since(entry.fetched) < kubePodCacheTTL {
+		return entry.pod, true
+	}
+
+	if settings.KubeletURL != "" && time.Since(kubeletLastFetch) >= kubeletFetchInterval {
+		kubeletLastFetch = time.Now()
+		pods, err := fetchKubeletPods(settings.KubeletURL)
+		if err != nil {
+			log.Printf("mapper: could not list pods from kubelet: %v", err)
+		}
+		for _, pod := range pods {
+			storeKubePod(pod)
+		}
+		if entry, ok := kubePodCache[uid]; ok && time.Since(entry.fetched) < kubePodCacheTTL {
+			return entry.pod, true
+		}
+	}
+
+	dir := settings.KubeletDir
+	if dir == "" {
+		dir = defaultKubeletDir
+	}
+	if pod, ok := readKubeletPodDir(dir, uid); ok {
+		storeKubePod(pod)
+		return pod, true
+	}
+	if found {
+		return entry.pod, true // Stale, but better than nothing
+	}
+	return kubePod{}, false
+}
+
+// storeKubePod caches a pod. The caller holds kubePodCacheMutex.
+func storeKubePod(pod kubePod) {
+	if old, ok := kubePodCache[pod.UID]; ok && !maps.Equal(old.pod.Labels, pod.Labels) {
+		log.Printf("mapper: pod %s/%s changed labels, re-mapping processes", pod.Namespace, pod.Name)
+		kubeGeneration.Add(1)
+	}
+	kubePodCache[pod.UID] = kubePodCacheEntry{pod: pod, fetched: time.Now()}
+}
+
+// pruneKubePodCache drops pods that were not looked up again for a while,
+// i.e. pods that are gone. The caller holds kubePodCacheMutex.
+func pruneKubePodCache() {
+	if time.Since(kubeLastPrune) < kubePodCacheTTL {
+		return
+	}
+	kubeLastPrune = time.Now()
+	maps.DeleteFunc(kubePodCache, func(_ string, entry kubePodCacheEntry) bool {
+		return time.Since(entry.fetched) > 2*kubePodCacheTTL
+	})
+}
+
+// fetchKubeletPods lists the pods of this node from the kubelet's read-only endpoint.
+func fetchKubeletPods(kubeletURL string) ([]kubePod, error) {
+	resp, err := kubeletClient.Get(strings.TrimSuffix(kubeletURL, "/") + "/pods")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("kubelet returned %s", resp.Status)
+	}
+
+	var podList struct {
+		Items []struct {
+			Metadata struct {
+				UID       string            `json:"uid"`
+				Namespace string            `json:"namespace"`
+				Name      string            `json:"name"`
+				Labels    map[string]string `json:"labels"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&podList); err != nil {
+		return nil, fmt.Errorf("failed to decode kubelet pod list: %w", err)
+	}
+	pods := make([]kubePod, 0, len(podList.Items))
+	for _, item := range podList.Items {
+		pod := kubePod{UID: item.Metadata.UID, Namespace: item.Metadata.Namespace, Name: item.Metadata.Name, Labels: item.Metadata.Labels}
+		if pod.Labels == nil {
+			pod.Labels = map[string]string{}
+		}
+		pods = append(pods, pod)
+	}
+	return pods, nil
+}
+
+// readKubeletPodDir reads what the kubelet's pod directory tells about a pod:
+// the namespace from the service account volume, the name from the managed
+// hosts file and, if the pod mounts them, labels from a downward API volume.
+func readKubeletPodDir(dir, uid string) (kubePod, bool) {
+	podDir := filepath.Join(dir, "pods", uid)
+	if _, err := os.Stat(podDir); err != nil {
+		return kubePod{}, false
+	}
+	pod := kubePod{UID: uid, Labels: map[string]string{}}
+	if files, _ := filepath.Glob(filepath.Join(podDir, "volumes", "kubernetes.io~projected", "*", "namespace")); len(files) > 0 {
+		if data, err := os.ReadFile(files[0]); err == nil {
+			pod.Namespace = strings.TrimSpace(string(data))
+		}
+	}
+	pod.Name = readPodHostname(filepath.Join(podDir, "etc-hosts"))
+	if files, _ := filepath.Glob(filepath.Join(podDir, "volumes", "kubernetes.io~downward-api", "*", "labels")); len(files) > 0 {
+		if data, err := os.ReadFile(files[0]); err == nil {
+			pod.Labels = parseDownwardLabels(string(data))
+		}
+	}
+	return pod, pod.Namespace != "" || pod.Name != ""
+}
+
+// readPodHostname returns the pod's hostname, which is its name unless the
+// pod sets spec.hostname, from the hosts file the kubelet writes for the pod.
+// Host network pods get a copy of the node's hosts file, which is ignored.
+func readPodHostname(hostsFile string) string {
+	file, err := os.Open(hostsFile)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	if !scanner.Scan() || strings.TrimSpace(scanner.Text()) != "# Kubernetes-managed hosts file." {
+		return ""
+	}
+	name := ""
+	for scanner.Scan() {
+		// The pod's own entry comes last: "<pod IP>\t<hostname>[.<subdomain>...]"
+		if fields := strings.Fields(scanner.Text()); len(fields) > 1 && !strings.HasPrefix(fields[0], "#") {
+			name = fields[len(fields)-1]
+		}
+	}
+	return name
+}
+
+// parseDownwardLabels parses a downward API labels file: one key="value" per line.
+func parseDownwardLabels(data string) map[string]string {
+	labels := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		key, quoted, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		if value, err := strconv.Unquote(quoted); err == nil {
+			labels[key] = value
+		}
+	}
+	return labels
+}
@@ -0,0 +1,180 @@
+package mapper
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"vps-screener/agent/config"
+)
+
+const (
+	testPodUID       = "3f1c2a0e-9d8b-4c6a-b5f4-e3d2c1b0a9f8"
+	testContainerID  = "4f1c2a0e9d8b7c6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a"
+	testHostNetPodID = "8a7b6c5d-4e3f-4a1b-9c8d-7e6f5a4b3c2d"
+)
+
+func TestParseKubePodCgroup(t *testing.T) {
+	underscored := strings.ReplaceAll(testPodUID, "-", "_")
+	tests := []struct {
+		name      string
+		cgroup    string
+		pod       string
+		container string
+	}{
+		{
+			name: "cgroupfs v1",
+			cgroup: "12:pids:/kubepods/burstable/pod" + testPodUID + "/" + testContainerID + "\n" +
+				"1:name=systemd:/kubepods/burstable/pod" + testPodUID + "/" + testContainerID,
+			pod: testPodUID, container: testContainerID,
+		},
+		{
+			name:   "cgroupfs v2 (k3s), guaranteed QoS",
+			cgroup: "0::/kubepods/pod" + testPodUID + "/" + testContainerID,
+			pod:    testPodUID, container: testContainerID,
+		},
+		{
+			name:   "systemd driver, containerd",
+			cgroup: "0::/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod" + underscored + ".slice/cri-containerd-" + testContainerID + ".scope",
+			pod:    testPodUID, container: testContainerID,
+		},
+		{
+			name:   "systemd driver, CRI-O",
+			cgroup: "0::/kubepods.slice/kubepods-besteffort.slice/kubepods-besteffort-pod" + underscored + ".slice/crio-" + testContainerID + ".scope",
+			pod:    testPodUID, container: testContainerID,
+		},
+		{
+			name:   "systemd driver, dockershim",
+			cgroup: "1:name=systemd:/kubepods.slice/kubepods-pod" + underscored + ".slice/docker-" + testContainerID + ".scope",
+			pod:    testPodUID, container: testContainerID,
+		},
+		{
+			name:   "pod cgroup itself",
+			cgroup: "0::/kubepods/besteffort/pod" + testPodUID,
+			pod:    testPodUID,
+		},
+		{
+			name:   "plain Docker container",
+			cgroup: "0::/system.slice/docker-" + testContainerID + ".scope",
+		},
+		{
+			name:   "systemd service",
+			cgroup: "0::/system.slice/kubelet.service",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod, container := parseKubePodCgroup(strings.Split(tt.cgroup, "\n"))
+			if pod != tt.pod || container != tt.container {
+				t.Errorf("parseKubePodCgroup = %q, %q, want %q, %q", pod, container, tt.pod, tt.container)
+			}
+		})
+	}
+}
+
+func TestReadKubeletPodDir(t *testing.T) {
+	pod, ok := readKubeletPodDir("testdata/kubelet", testPodUID)
+	want := kubePod{
+		UID:       testPodUID,
+		Namespace: "shop",
+		Name:      "checkout-7d9f8c6b5-qx2lm",
+		Labels: map[string]string{
+			"app.kubernetes.io/name":    "checkout",
+			"app.kubernetes.io/part-of": "shop",
+			"pod-template-hash":         "7d9f8c6b5",
+		},
+	}
+	if !ok || !reflect.DeepEqual(pod, want) {
+		t.Errorf("readKubeletPodDir = %+v, %v, want %+v", pod, ok, want)
+	}
+
+	// Host network pods get the node's hosts file, which does not name the pod.
+	pod, ok = readKubeletPodDir("testdata/kubelet", testHostNetPodID)
+	if !ok || pod.Namespace != "kube-system" || pod.Name != "" || len(pod.Labels) != 0 {
+		t.Errorf("host network pod = %+v, %v, want namespace kube-system only", pod, ok)
+	}
+
+	if _, ok := readKubeletPodDir("testdata/kubelet", "00000000-0000-0000-0000-000000000000"); ok {
+		t.Errorf("unknown pod found")
+	}
+}
+
+func TestLookupKubePodFromKubelet(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	tier := "web"
+	requests := 0
+	kubelet := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/pods" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"kind":"PodList","items":[{"metadata":{"uid":%q,"namespace":"payments","name":"api-0","labels":{"tier":%q}}}]}`, testPodUID, tier)
+	}))
+	defer kubelet.Close()
+
+	kubePodCacheMutex.Lock()
+	kubePodCache = make(map[string]kubePodCacheEntry)
+	kubeletLastFetch = time.Time{}
+	kubePodCacheMutex.Unlock()
+	settings := config.Kubernetes{KubeletURL: kubelet.URL + "/", KubeletDir: t.TempDir()}
+
+	pod, ok := lookupKubePod(testPodUID, settings)
+	if !ok || pod.Namespace != "payments" || pod.Name != "api-0" || pod.Labels["tier"] != "web" {
+		t.Fatalf("lookupKubePod = %+v, %v", pod, ok)
+	}
+	if _, ok := lookupKubePod(testPodUID, settings); !ok || requests != 1 {
+		t.Errorf("cached lookup made %d requests, want 1", requests)
+	}
+	// Unknown pods do not hit the kubelet more than once per kubeletFetchInterval.
+	if _, ok := lookupKubePod(testHostNetPodID, settings); ok || requests != 1 {
+		t.Errorf("unknown pod: found %v after %d requests, want not found after 1", ok, requests)
+	}
+
+	// A label change is picked up once the entry expires and invalidates mappings.
+	tier = "db"
+	generation := kubeGeneration.Load()
+	kubePodCacheMutex.Lock()
+	entry := kubePodCache[testPodUID]
+	entry.fetched = time.Now().Add(-kubePodCacheTTL)
+	kubePodCache[testPodUID] = entry
+	kubeletLastFetch = time.Time{}
+	kubePodCacheMutex.Unlock()
+	if pod, _ := lookupKubePod(testPodUID, settings); pod.Labels["tier"] != "db" {
+		t.Errorf("label after refresh = %q, want db", pod.Labels["tier"])
+	}
+	if kubeGeneration.Load() == generation {
+		t.Errorf("label change did not bump kubeGeneration")
+	}
+}
+
+func TestEvalMatchPod(t *testing.T) {
+	f := webFacts()
+	f.podUID = testPodUID
+	f.pod = &kubePod{UID: testPodUID, Namespace: "payments", Name: "api-0", Labels: map[string]string{"tier": "web"}}
+
+	for src, want := range map[string]bool{
+		`k8s_namespace: payments`: true,
+		`k8s_namespace: shop`:     false,
+		`k8s_label: tier=web`:     true,
+		`k8s_label: tier`:         true,
+		`k8s_label: tier=db`:      false,
+		"all:\n  - k8s_namespace: payments\n  - not:\n      k8s_label: tier=db": true,
+	} {
+		if ok, _ := evalMatch(parseMatch(t, src), f); ok != want {
+			t.Errorf("evalMatch(%q) = %v, want %v", src, ok, want)
+		}
+	}
+	if ok, _ := evalMatch(parseMatch(t, `k8s_namespace: payments`), webFacts()); ok {
+		t.Errorf("k8s_namespace matched a process outside a pod")
+	}
+}
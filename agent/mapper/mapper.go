@@ -2,28 +2,16 @@ package mapper
 
 import (
 	"bufio"
-	"encoding/json"
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
-	"os/user"
-	"regexp"
 	"strings"
-	"sync"
 
-	"github.com/elastic/go-sysinfo"
 	"github.com/elastic/go-sysinfo/types"
 	"vps-screener/agent/config" // Importing our own config package
 )
 
-const cgroupPathPattern = "/proc/%d/cgroup"
-
-var (
-	dockerLabelsCache      = make(map[string]map[string]string)
-	dockerLabelsCacheMutex = &sync.RWMutex{}
-	dockerCliNotFound      = false
-)
+var cgroupPathPattern = "/proc/%d/cgroup" // Tests point it at fixtures
 
 func readCgroupFile(pid int32) ([]string, error) {
 	cgroupFile := fmt.Sprintf(cgroupPathPattern, pid)
@@ -44,232 +32,136 @@ func readCgroupFile(pid int32) ([]string, error) {
 	return lines, scanner.Err()
 }
 
-// GetSystemdServiceForPid attempts to find the systemd service name for a PID.
+// GetSystemdServiceForPid returns the systemd unit a PID runs in, e.g.
+// "my-app.service", or "" if it is not part of a unit. See systemdCgroup.Unit.
 func GetSystemdServiceForPid(pid int32) (string, error) {
-	lines, err := readCgroupFile(pid)
+	cg, err := readSystemdCgroup(pid)
 	if err != nil {
 		return "", err
 	}
-
-	// Regex to find systemd service names. Examples:
-	// 0::/system.slice/docker.service
-	// 0::/system.slice/my-app.service
-	// 0::/user.slice/user-1000.slice/user@1000.service/app.slice/some-gui.service
-	re := regexp.MustCompile(`.*/(system|user)\.slice/.*([a-zA-Z0-9_.\-]+)\.service`)
-
-	for _, line := range lines {
-		matches := re.FindStringSubmatch(line)
-		// The last submatch is usually the most specific service name
-		if len(matches) > 2 {
-			serviceName := matches[len(matches)-1] // e.g., my-app.service or user@1000.service
-			// If it's a user slice like user@1000.service/app.slice/sub.service, get sub.service
-			if strings.HasPrefix(serviceName, "user@") && strings.Contains(line, "/app.slice/") {
-				parts := strings.Split(line, "/")
-				if len(parts) > 0 && strings.HasSuffix(parts[len(parts)-1], ".service") {
-					serviceName = parts[len(parts)-1]
-				}
-			}
-			// Avoid generic session or user slice managers themselves unless explicitly targeted
-			if serviceName != "user.slice" && !strings.HasPrefix(serviceName, "session-") && serviceName != fmt.Sprintf("user@%s.service", procUsername(pid)) {
-				return serviceName, nil
-			}
-		}
-	}
-	return "", nil // Not found or not a systemd managed process in a typical way
+	return cg.Unit(), nil
 }
 
-// Helper to get username, used to filter out generic user services
-func procUsername(pid int32) string {
-	p, err := sysinfo.Process(int(pid))
-	if err != nil {
-		log.Printf("mapper: failed to get process for PID %d: %v", pid, err)
-		return ""
-	}
-	userInfo, err := p.User()
+func readSystemdCgroup(pid int32) (systemdCgroup, error) {
+	lines, err := readCgroupFile(pid)
 	if err != nil {
-		log.Printf("mapper: failed to get user info for PID %d: %v", pid, err)
-		return ""
+		return systemdCgroup{}, err
 	}
-	u, err := user.LookupId(userInfo.UID)
+	return parseSystemdCgroup(lines), nil
+}
+
+// GetContainerForPid returns the container a PID runs in, tagged with its
+// runtime, or a zero ContainerRef if it is not in a container.
+func GetContainerForPid(pid int32) (ContainerRef, error) {
+	lines, err := readCgroupFile(pid)
 	if err != nil {
-		log.Printf("mapper: could not lookup username for UID %s (PID %d): %v", userInfo.UID, pid, err)
-		return ""
+		return ContainerRef{}, err
 	}
-	return u.Username
+	return parseContainerCgroup(lines), nil
 }
 
 // GetDockerContainerIDForPid attempts to find the Docker container ID for a PID.
+// See GetContainerForPid for other runtimes.
 func GetDockerContainerIDForPid(pid int32) (string, error) {
-	lines, err := readCgroupFile(pid)
-	if err != nil {
+	ref, err := GetContainerForPid(pid)
+	if err != nil || ref.Runtime != RuntimeDocker {
 		return "", err
 	}
-	// Example: 12:pids:/docker/ab358028849698799098f07999e89a148318139af341950e831950e7dd059085
-	// Example: 8:memory:/docker/actions_job_12345 // (from within actions runner)
-	re := regexp.MustCompile(`:/docker/([0-9a-fA-F]{12,64})(?:\.scope)?$`)
-	for _, line := range lines {
-		matches := re.FindStringSubmatch(line)
-		if len(matches) > 1 {
-			return matches[1], nil // Return the full or partial container ID
-		}
-	}
-	return "", nil
+	return ref.ID, nil // The full or partial container ID
 }
 
 // GetDockerLabels fetches labels for a given container ID using `docker inspect`.
 func GetDockerLabels(containerID string) (map[string]string, error) {
-	if containerID == "" {
-		return nil, fmt.Errorf("containerID cannot be empty")
-	}
-	dockerLabelsCacheMutex.RLock()
-	if dockerCliNotFound {
-		dockerLabelsCacheMutex.RUnlock()
-		return nil, fmt.Errorf("docker CLI not found, skipping label fetch")
-	}
-	labels, found := dockerLabelsCache[containerID]
-	dockerLabelsCacheMutex.RUnlock()
-	if found {
-		return labels, nil
-	}
-
-	dockerLabelsCacheMutex.Lock()
-	defer dockerLabelsCacheMutex.Unlock()
-	// Re-check after acquiring write lock
-	labels, found = dockerLabelsCache[containerID]
-	if found {
-		return labels, nil
-	}
-
-	cmd := exec.Command("docker", "inspect", containerID)
-	output, err := cmd.Output()
+	container, err := inspectContainer(ContainerRef{Runtime: RuntimeDocker, ID: containerID})
 	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			log.Printf("Docker inspect for %s failed: %s, stderr: %s", containerID, err, string(exitErr.Stderr))
-		} else if err.Error() == "exec: \"docker\": executable file not found in $PATH" {
-			log.Println("Docker CLI not found. Docker label matching will be disabled.")
-			dockerCliNotFound = true
-			return nil, fmt.Errorf("docker CLI not found: %w", err)
-		} else {
-			log.Printf("Docker inspect for %s failed: %s", containerID, err)
-		}
-		return nil, fmt.Errorf("docker inspect command failed for %s: %w", containerID, err)
-	}
-
-	var inspectOutput []struct {
-		Config struct {
-			Labels map[string]string `json:"Labels"`
-		} `json:"Config"`
-	}
-	if err := json.Unmarshal(output, &inspectOutput); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal docker inspect output for %s: %w", containerID, err)
+		return nil, err
 	}
+	return container.Labels, nil
+}
 
-	if len(inspectOutput) > 0 && inspectOutput[0].Config.Labels != nil {
-		dockerLabelsCache[containerID] = inspectOutput[0].Config.Labels
-		return inspectOutput[0].Config.Labels, nil
+// GetDockerContainerName returns the name of a container, e.g. "project_b_node_1".
+func GetDockerContainerName(containerID string) (string, error) {
+	container, err := inspectContainer(ContainerRef{Runtime: RuntimeDocker, ID: containerID})
+	if err != nil {
+		return "", err
 	}
-	dockerLabelsCache[containerID] = map[string]string{} // Cache empty if no labels
-	return map[string]string{}, nil
+	return container.Name, nil
 }
 
-// MapPIDToProject determines the project for a given process.
-func MapPIDToProject(p types.Process, projectsConfig []config.ProjectConfig) string {
-	baseInfo, baseInfoErr := p.Info()
-
+// newProcessFacts collects the attributes the match rules need from a process.
+func newProcessFacts(p types.Process, baseInfo types.ProcessInfo, baseInfoErr error) *processFacts {
 	userName := ""
 	userInfo, userErr := p.User()
 	if userErr != nil {
 		log.Printf("mapper: could not get user info for process PID %d: %v", p.PID(), userErr)
 	} else {
-		u, lookupErr := user.LookupId(userInfo.UID)
-		if lookupErr != nil {
-			log.Printf("mapper: could not lookup username for UID %s (PID %d): %v", userInfo.UID, p.PID(), lookupErr)
-		} else {
-			userName = u.Username
-		}
+		userName = lookupUsername(userInfo.UID)
 	}
 
 	processName := ""
+	processExe := ""
+	processCwd := ""
 	var processArgs []string
 	if baseInfoErr == nil {
 		processName = baseInfo.Name
+		processExe = baseInfo.Exe
+		processCwd = baseInfo.CWD
 		processArgs = baseInfo.Args
 	} else {
 		log.Printf("mapper: failed to get basic process info for PID %d: %v", p.PID(), baseInfoErr)
 	}
 
-	pinfo := struct {
-		Name     string
-		Username string
-		CmdLine  string
-	}{
+	facts := &processFacts{
+		PID:      int32(p.PID()),
 		Name:     processName,
+		Exe:      processExe,
 		Username: userName,
 		CmdLine:  strings.Join(processArgs, " "),
+		Cwd:      processCwd,
 	}
-
-	currentPID := p.PID()
 	if baseInfoErr == nil {
-		currentPID = baseInfo.PID
+		facts.PID = int32(baseInfo.PID)
 	}
+	if e, ok := p.(types.Environment); ok {
+		facts.environ = e.Environment
+	}
+	return facts
+}
 
-	for _, proj := range projectsConfig {
-		match := proj.Match
-		// 1. Systemd Unit
-		if match.SystemdUnit != "" {
-			systemdService, _ := GetSystemdServiceForPid(int32(currentPID))
-			if systemdService == match.SystemdUnit {
-				log.Printf("PID %d (%s) matched project '%s' by systemd unit: %s", currentPID, pinfo.Name, proj.Name, systemdService)
-				return proj.Name
-			}
-		}
-
-		// 2. Docker Label
-		if match.DockerLabel != "" {
-			containerID, _ := GetDockerContainerIDForPid(int32(currentPID))
-			if containerID != "" {
-				labels, err := GetDockerLabels(containerID)
-				if err == nil && labels != nil {
-					labelKeyVal := strings.SplitN(match.DockerLabel, "=", 2)
-					labelKey := labelKeyVal[0]
-					expectedValue := ""
-					if len(labelKeyVal) > 1 {
-						expectedValue = labelKeyVal[1]
-					}
-					if val, ok := labels[labelKey]; ok && (len(labelKeyVal) == 1 || val == expectedValue) {
-						log.Printf("PID %d (%s) matched project '%s' by Docker label: %s=%s", currentPID, pinfo.Name, proj.Name, labelKey, val)
-						return proj.Name
-					}
-				}
-			}
-		}
+// MapPIDToProject determines the project for a given process.
+func MapPIDToProject(p types.Process, projectsConfig []config.ProjectConfig) string {
+	baseInfo, baseInfoErr := p.Info()
+	return mapProcess(newProcessFacts(p, baseInfo, baseInfoErr), &config.Config{Projects: projectsConfig}).Project
+}
 
-		// 3. Process Name
-		if match.ProcessNamePattern != "" {
-			if pinfo.Name == match.ProcessNamePattern {
-				log.Printf("PID %d (%s) matched project '%s' by process name pattern", currentPID, pinfo.Name, proj.Name)
-				return proj.Name
-			}
-		}
+// mapProcess maps a process to its project and to the groups it matches by rules.
+func mapProcess(facts *processFacts, cfg *config.Config) Mapping {
+	facts.kubernetes = cfg.AgentSettings.Kubernetes
+	mapping := mapProject(facts, cfg)
+	mapping.Groups = matchGroups(cfg.Groups, facts)
+	return mapping
+}
 
-		// 4. Username
-		if match.User != "" {
-			if pinfo.Username == match.User {
-				log.Printf("PID %d (%s) matched project '%s' by username: %s", currentPID, pinfo.Name, proj.Name, pinfo.Username)
-				return proj.Name
-			}
+// mapProject evaluates the project rules, then the discovery rules, for a process.
+func mapProject(facts *processFacts, cfg *config.Config) Mapping {
+	for _, proj := range cfg.Projects {
+		if ok, reason := evalMatch(proj.Match, facts); ok {
+			log.Printf("PID %d (%s) matched project '%s' by %s", facts.PID, facts.Name, proj.Name, reason)
+			return Mapping{Project: proj.Name}
 		}
+	}
 
-		// 5. Command Line (Field match.CmdLine does not exist in config.ProjectMatch)
-		/*
-		if match.CmdLine != "" { 
-			if strings.Contains(pinfo.CmdLine, match.CmdLine) {
-				log.Printf("PID %d (%s) matched project '%s' by command line: %s", info.PID, pinfo.Name, proj.Name, match.CmdLine)
-				return proj.Name
+	if name, from := discoverProject(cfg.Discovery, facts); name != "" {
+		// A discovered name that is also configured counts as the configured project.
+		discovered := true
+		for _, proj := range cfg.Projects {
+			if proj.Name == name {
+				discovered = false
+				break
 			}
 		}
-		*/
+		log.Printf("PID %d (%s) mapped to project '%s' discovered from %s", facts.PID, facts.Name, name, from)
+		return Mapping{Project: name, Discovered: discovered}
 	}
-
-	return ""
-} 
\ No newline at end of file
+	return Mapping{}
+}
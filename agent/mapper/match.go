@@ -0,0 +1,416 @@
+package mapper
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"vps-screener/agent/config"
+)
+
+// processFacts are the attributes of a process that match rules are evaluated
+// against. The systemd unit, container, pod, environment and listening ports
+// need /proc, `docker inspect` or the kubelet and are looked up on first use,
+// at most once per process.
+type processFacts struct {
+	PID      int32
+	Name     string
+	Exe      string
+	CmdLine  string
+	Username string
+	Cwd      string
+
+	cgroupLines   []string
+	cgroupRead    bool
+	cgroup        *systemdCgroup
+	containerRef  ContainerRef
+	container     *containerInfo // nil if the process is not in a (known) container
+	containerDone bool
+	environ       func() (map[string]string, error)
+	env           map[string]string // nil if the environment could not be read
+	envDone       bool
+	sockets       *listenSockets // Shared by the processes of a tick; created on first use if nil
+	netTable      map[uint64]int
+	netDone       bool
+	ports         []int
+	portsDone     bool
+	portsChecked  bool // A listen_port criterion was evaluated, see MapProcesses
+	kubernetes    config.Kubernetes
+	podUID        string
+	pod           *kubePod // nil if the process is not in a (known) pod
+	podDone       bool
+}
+
+// readCgroup returns the lines of /proc/<pid>/cgroup.
+func (f *processFacts) readCgroup() []string {
+	if !f.cgroupRead {
+		f.cgroupRead = true
+		f.cgroupLines, _ = readCgroupFile(f.PID)
+	}
+	return f.cgroupLines
+}
+
+func (f *processFacts) systemdCgroup() *systemdCgroup {
+	if f.cgroup == nil {
+		cg := parseSystemdCgroup(f.readCgroup())
+		f.cgroup = &cg
+	}
+	return f.cgroup
+}
+
+func (f *processFacts) kubePod() *kubePod {
+	if !f.podDone {
+		f.podDone = true
+		f.podUID, _ = parseKubePodCgroup(f.readCgroup())
+		if f.podUID != "" {
+			if pod, ok := lookupKubePod(f.podUID, f.kubernetes); ok {
+				f.pod = &pod
+			}
+		}
+	}
+	return f.pod
+}
+
+// podPlaceholder describes why a process has no pod metadata.
+func (f *processFacts) podPlaceholder() string {
+	if f.kubePod() == nil && f.podUID != "" {
+		return "<pod " + f.podUID + " not found>"
+	}
+	return "<not in a pod>"
+}
+
+func (f *processFacts) runtimeContainer() *containerInfo {
+	if !f.containerDone {
+		f.containerDone = true
+		f.containerRef = parseContainerCgroup(f.readCgroup())
+		if !f.containerRef.IsZero() {
+			if c, err := inspectContainer(f.containerRef); err == nil {
+				f.container = &c
+			}
+		}
+	}
+	return f.container
+}
+
+func (f *processFacts) containerName() string {
+	if c := f.runtimeContainer(); c != nil {
+		return orNone(c.Name, "<unnamed container>")
+	}
+	return "<not in a container>"
+}
+
+// environment reads /proc/<pid>/environ. Reading another user's environment
+// needs root (or CAP_SYS_PTRACE); in that case env criteria do not match.
+func (f *processFacts) environment() map[string]string {
+	if !f.envDone {
+		f.envDone = true
+		if f.environ != nil {
+			if env, err := f.environ(); err == nil {
+				f.env = env
+			}
+		}
+	}
+	return f.env
+}
+
+// socketTable returns the listening sockets of the process's network
+// namespace, or nil if it cannot be read.
+func (f *processFacts) socketTable() map[uint64]int {
+	if !f.netDone {
+		f.netDone = true
+		if f.sockets == nil {
+			f.sockets = newListenSockets()
+		}
+		f.netTable = f.sockets.table(f.PID)
+	}
+	return f.netTable
+}
+
+// listenPorts returns the ports the process has listening sockets on.
+func (f *processFacts) listenPorts() []int {
+	if !f.portsDone {
+		f.portsDone = true
+		if table := f.socketTable(); len(table) > 0 {
+			f.ports = listeningPorts(table, socketInodes(f.PID))
+		}
+	}
+	return f.ports
+}
+
+// evalMatch evaluates a match expression against a process. On a match it
+// returns a description of what matched, e.g. "systemd unit: web.service and username: webapp".
+func evalMatch(m config.MatchRules, f *processFacts) (bool, string) {
+	var reasons []string
+	if m.HasCriteria() {
+		ok, reason := evalCriteria(m, f)
+		if !ok {
+			return false, ""
+		}
+		reasons = append(reasons, reason)
+	}
+	for _, sub := range m.All {
+		ok, reason := evalMatch(sub, f)
+		if !ok {
+			return false, ""
+		}
+		reasons = append(reasons, reason)
+	}
+	if len(m.Any) > 0 {
+		matched := false
+		for _, sub := range m.Any {
+			if ok, reason := evalMatch(sub, f); ok {
+				reasons = append(reasons, reason)
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, ""
+		}
+	}
+	if m.Not != nil {
+		if ok, _ := evalMatch(*m.Not, f); ok {
+			return false, ""
+		}
+		reasons = append(reasons, "not excluded by 'not'")
+	}
+	if len(reasons) == 0 {
+		return false, "" // Empty match rules never match
+	}
+	return true, strings.Join(reasons, " and ")
+}
+
+// evalCriteria checks the flat criteria of a node. All of them must match, as
+// if each were an entry of an all: block.
+func evalCriteria(m config.MatchRules, f *processFacts) (bool, string) {
+	matched := true
+	var reasons []string
+	eachCriterion(m, f, func(c criterion) bool {
+		if !c.Matched {
+			matched = false
+			return false
+		}
+		reasons = append(reasons, c.Reason)
+		return true
+	})
+	if !matched {
+		return false, ""
+	}
+	return true, strings.Join(reasons, " and ")
+}
+
+// criterion is the outcome of one flat match criterion for a process.
+type criterion struct {
+	Field    string // YAML key, e.g. "systemd_unit"
+	Want     string // Configured value
+	Observed string // What the process has, or a <placeholder> if it has nothing to compare
+	Matched  bool
+	Reason   string // Set if Matched, e.g. "systemd unit: web.service"
+}
+
+// eachCriterion evaluates the configured flat criteria of a node in order and
+// passes each outcome to fn until fn returns false. Expensive lookups only
+// happen when a criterion needs them.
+func eachCriterion(m config.MatchRules, f *processFacts, fn func(criterion) bool) {
+	// 1. Systemd Unit
+	if m.SystemdUnit != "" {
+		cg := f.systemdCgroup()
+		c := criterion{Field: "systemd_unit", Want: m.SystemdUnit, Observed: orNone(cg.Path, "<no systemd cgroup>")}
+		if cg.MatchesUnit(m.SystemdUnit) {
+			c.Matched, c.Reason = true, "systemd unit: "+m.SystemdUnit
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 2. Docker Label
+	if m.DockerLabel != "" {
+		c := criterion{Field: "docker_label", Want: m.DockerLabel, Observed: "<not in a container>"}
+		if container := f.runtimeContainer(); container != nil {
+			labelKey, expectedValue, hasValue := strings.Cut(m.DockerLabel, "=")
+			c.Observed = "<" + labelKey + " not set>"
+			if val, ok := container.Labels[labelKey]; ok {
+				c.Observed = labelKey + "=" + val
+				if !hasValue || val == expectedValue {
+					c.Matched, c.Reason = true, fmt.Sprintf("Docker label: %s=%s", labelKey, val)
+				}
+			}
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 3. Docker container name, exact or by regex (e.g. "^project_b_" for Compose-style names)
+	if m.DockerContainerName != "" {
+		c := criterion{Field: "docker_container_name", Want: m.DockerContainerName, Observed: f.containerName()}
+		if container := f.runtimeContainer(); container != nil && container.Name == m.DockerContainerName {
+			c.Matched, c.Reason = true, "container name: "+container.Name
+		}
+		if !fn(c) {
+			return
+		}
+	}
+	if !m.ContainerNamePattern.IsZero() {
+		c := criterion{Field: "container_name_pattern", Want: m.ContainerNamePattern.Pattern, Observed: f.containerName()}
+		if container := f.runtimeContainer(); container != nil && container.Name != "" && m.ContainerNamePattern.MatchString(container.Name) {
+			c.Matched, c.Reason = true, "container name: "+container.Name
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 4. Process Name (or exe path / command line, depending on the pattern's target)
+	if !m.ProcessNamePattern.IsZero() {
+		subject := f.Name
+		switch m.ProcessNamePattern.Target {
+		case config.PatternTargetExe:
+			subject = f.Exe
+		case config.PatternTargetCmdline:
+			subject = f.CmdLine
+		}
+		c := criterion{Field: "process_name_pattern", Want: m.ProcessNamePattern.Pattern, Observed: m.ProcessNamePattern.Target + " " + subject}
+		if subject != "" && m.ProcessNamePattern.MatchString(subject) {
+			c.Matched, c.Reason = true, fmt.Sprintf("process %s pattern %q", m.ProcessNamePattern.Target, m.ProcessNamePattern.Pattern)
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 5. Username
+	if m.User != "" {
+		c := criterion{Field: "user", Want: m.User, Observed: orNone(f.Username, "<unknown user>")}
+		if f.Username == m.User {
+			c.Matched, c.Reason = true, "username: "+f.Username
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 6. Command line substring
+	if m.Cmdline != "" {
+		c := criterion{Field: "cmdline", Want: m.Cmdline, Observed: orNone(f.CmdLine, "<no command line>")}
+		if strings.Contains(f.CmdLine, m.Cmdline) {
+			c.Matched, c.Reason = true, fmt.Sprintf("command line containing %q", m.Cmdline)
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 7. Executable path, exact or glob. The kernel appends " (deleted)" when the binary was replaced.
+	if m.Exe != "" {
+		exe := strings.TrimSuffix(f.Exe, " (deleted)")
+		c := criterion{Field: "exe", Want: m.Exe, Observed: orNone(exe, "<unknown executable>")}
+		if ok, _ := filepath.Match(m.Exe, exe); ok && exe != "" {
+			c.Matched, c.Reason = true, "executable: "+exe
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 8. Working directory, or a directory above it
+	if m.Cwd != "" {
+		c := criterion{Field: "cwd", Want: m.Cwd, Observed: orNone(f.Cwd, "<unknown working directory>")}
+		if f.Cwd != "" && underDir(f.Cwd, m.Cwd) {
+			c.Matched, c.Reason = true, "working directory: "+f.Cwd
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 9. Environment variable
+	if m.Env != "" {
+		key, value, hasValue := strings.Cut(m.Env, "=")
+		c := criterion{Field: "env", Want: m.Env, Observed: "<environment not readable>"}
+		if env := f.environment(); env != nil {
+			c.Observed = "<" + key + " not set>"
+			if val, ok := env[key]; ok {
+				c.Observed = key + "=" + val
+				if !hasValue || val == value {
+					c.Matched, c.Reason = true, fmt.Sprintf("environment: %s=%s", key, val)
+				}
+			}
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 10. Listening port. The process's fds are only read if something in its
+	// network namespace listens on one of the ports.
+	if !m.ListenPort.IsZero() {
+		f.portsChecked = true
+		c := criterion{Field: "listen_port", Want: m.ListenPort.String(), Observed: "<sockets not readable>"}
+		if table := f.socketTable(); table != nil {
+			c.Observed = "<no listener on these ports>"
+			if anyListening(table, m.ListenPort) {
+				ports := f.listenPorts()
+				c.Observed = orNone(formatPorts(ports), "<no listening sockets>")
+				for _, port := range ports {
+					if m.ListenPort.Contains(port) {
+						c.Matched, c.Reason = true, fmt.Sprintf("listening port: %d", port)
+						break
+					}
+				}
+			}
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 11. Kubernetes namespace
+	if m.K8sNamespace != "" {
+		c := criterion{Field: "k8s_namespace", Want: m.K8sNamespace, Observed: f.podPlaceholder()}
+		if pod := f.kubePod(); pod != nil {
+			c.Observed = orNone(pod.Namespace, "<unknown namespace>")
+			if pod.Namespace == m.K8sNamespace {
+				c.Matched, c.Reason = true, "Kubernetes namespace: "+pod.Namespace
+			}
+		}
+		if !fn(c) {
+			return
+		}
+	}
+
+	// 12. Kubernetes pod label
+	if m.K8sLabel != "" {
+		c := criterion{Field: "k8s_label", Want: m.K8sLabel, Observed: f.podPlaceholder()}
+		if pod := f.kubePod(); pod != nil {
+			labelKey, expectedValue, hasValue := strings.Cut(m.K8sLabel, "=")
+			c.Observed = "<" + labelKey + " not set>"
+			if val, ok := pod.Labels[labelKey]; ok {
+				c.Observed = labelKey + "=" + val
+				if !hasValue || val == expectedValue {
+					c.Matched, c.Reason = true, fmt.Sprintf("Kubernetes label: %s=%s", labelKey, val)
+				}
+			}
+		}
+		if !fn(c) {
+			return
+		}
+	}
+}
+
+func orNone(value, placeholder string) string {
+	if value == "" {
+		return placeholder
+	}
+	return value
+}
+
+// underDir reports whether p is dir or inside it.
+func underDir(p, dir string) bool {
+	dir = filepath.Clean(dir)
+	if p == dir || dir == "/" {
+		return true
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
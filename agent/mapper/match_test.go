@@ -0,0 +1,157 @@
+package mapper
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"vps-screener/agent/config"
+)
+
+func parseMatch(t *testing.T, src string) config.MatchRules {
+	t.Helper()
+	var m config.MatchRules
+	if err := yaml.Unmarshal([]byte(src), &m); err != nil {
+		t.Fatalf("unmarshal %q: %v", src, err)
+	}
+	if err := m.Compile("match"); err != nil {
+		t.Fatalf("compile %q: %v", src, err)
+	}
+	return m
+}
+
+// webFacts is a process of web.service running as webapp outside any container.
+func webFacts() *processFacts {
+	return &processFacts{
+		PID:           1234,
+		Name:          "node",
+		Exe:           "/usr/bin/node",
+		CmdLine:       "node /srv/web/server.js",
+		Username:      "webapp",
+		Cwd:           "/srv/web/current",
+		cgroup:        &systemdCgroup{Path: "/system.slice/web.service", Slices: []string{"system.slice"}, Units: []string{"web.service"}},
+		containerDone: true,
+		env:           map[string]string{"PROJECT": "billing", "EMPTY": ""},
+		envDone:       true,
+		netTable:      map[uint64]int{100: 8080, 101: 9001},
+		netDone:       true,
+		ports:         []int{8080},
+		portsDone:     true,
+		podDone:       true,
+	}
+}
+
+func TestEvalMatch(t *testing.T) {
+	tests := []struct {
+		name  string
+		match string
+		want  bool
+	}{
+		{"empty never matches", `{}`, false},
+		{"flat single", `systemd_unit: web.service`, true},
+		{"flat fields are all required", "systemd_unit: web.service\nuser: other", false},
+		{"flat fields all match", "systemd_unit: web.service\nuser: webapp", true},
+		{"flat fields none match", "systemd_unit: other.service\nuser: other", false},
+		{"all both match", "all:\n  - systemd_unit: web.service\n  - user: webapp", true},
+		{"all one fails", "all:\n  - systemd_unit: web.service\n  - user: other", false},
+		{"any one matches", "any:\n  - user: other\n  - process_name_pattern: ^node$", true},
+		{"any none match", "any:\n  - user: other\n  - process_name_pattern: ^python", false},
+		{"not excludes", "user: webapp\nnot:\n  process_name_pattern: ^node$", false},
+		{"not passes", "user: webapp\nnot:\n  process_name_pattern: ^python", true},
+		{"not alone", "not:\n  user: other", true},
+		{"flat ANDed with all", "systemd_unit: web.service\nall:\n  - user: other", false},
+		{"flat ANDed with any", "user: webapp\nany:\n  - process_name_pattern: {pattern: ^/srv/, target: exe}\n  - process_name_pattern: {pattern: /srv/web/, target: cmdline}", true},
+		{"nested", "any:\n  - all:\n      - user: other\n  - all:\n      - user: webapp\n      - not:\n          docker_label: app=web", true},
+		{"container criteria outside a container", `docker_label: app=web`, false},
+		{"cmdline substring", `cmdline: /srv/web/server.js`, true},
+		{"cmdline substring missing", `cmdline: worker.js`, false},
+		{"exe exact", `exe: /usr/bin/node`, true},
+		{"exe glob", `exe: /usr/bin/*`, true},
+		{"exe glob no match", `exe: /opt/*/node`, false},
+		{"cwd exact", `cwd: /srv/web/current`, true},
+		{"cwd parent", `cwd: /srv/web`, true},
+		{"cwd sibling prefix", `cwd: /srv/we`, false},
+		{"env key and value", `env: PROJECT=billing`, true},
+		{"env other value", `env: PROJECT=web`, false},
+		{"env key only", `env: PROJECT`, true},
+		{"env empty value", `env: EMPTY=`, true},
+		{"env missing key", `env: TEAM`, false},
+		{"env ANDed with user", "all:\n  - user: webapp\n  - env: PROJECT=billing", true},
+		{"listen_port single", `listen_port: 8080`, true},
+		{"listen_port list", `listen_port: [80, 8080]`, true},
+		{"listen_port range", `listen_port: "8000-8099"`, true},
+		{"listen_port other process's port", `listen_port: 9001`, false},
+		{"listen_port nobody listens", `listen_port: [80, "443-444"]`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := parseMatch(t, tt.match)
+			got, reason := evalMatch(m, webFacts())
+			if got != tt.want {
+				t.Fatalf("evalMatch(%q) = %v (%q), want %v", tt.match, got, reason, tt.want)
+			}
+			if got && reason == "" {
+				t.Errorf("evalMatch(%q) matched without a reason", tt.match)
+			}
+		})
+	}
+}
+
+func TestEvalMatchContainer(t *testing.T) {
+	f := webFacts()
+	f.container = &containerInfo{Name: "project_b_web_1", Labels: map[string]string{"app": "web"}}
+
+	for _, src := range []string{
+		`docker_label: app=web`,
+		`docker_label: app`,
+		`docker_container_name: project_b_web_1`,
+		`container_name_pattern: ^project_b_`,
+		"all:\n  - container_name_pattern: ^project_b_\n  - not:\n      docker_label: app=db",
+	} {
+		if ok, _ := evalMatch(parseMatch(t, src), f); !ok {
+			t.Errorf("evalMatch(%q) = false, want true", src)
+		}
+	}
+	if ok, _ := evalMatch(parseMatch(t, `docker_label: app=db`), f); ok {
+		t.Errorf("docker_label app=db matched a container labelled app=web")
+	}
+}
+
+func TestEvalMatchReason(t *testing.T) {
+	m := parseMatch(t, "systemd_unit: web.service\nall:\n  - user: webapp")
+	_, reason := evalMatch(m, webFacts())
+	if want := "systemd unit: web.service and username: webapp"; reason != want {
+		t.Errorf("reason = %q, want %q", reason, want)
+	}
+	// Flat criteria are a shorthand for the same all: block.
+	_, reason = evalMatch(parseMatch(t, "systemd_unit: web.service\nuser: webapp"), webFacts())
+	if want := "systemd unit: web.service and username: webapp"; reason != want {
+		t.Errorf("flat reason = %q, want %q", reason, want)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	tests := []struct {
+		match   string
+		wantErr string
+	}{
+		{"all:\n  - {}", "match.all[0] has no match criteria"},
+		{"not: {}", "match.not has no match criteria"},
+		{"any:\n  - user: a\n  - process_name_pattern: '('", "invalid match.any[1].process_name_pattern"},
+		{"all:\n  - not:\n      container_name_pattern: '['", "invalid match.all[0].not.container_name_pattern"},
+		{"exe: '/opt/[bin'", "invalid match.exe"},
+		{"cwd: srv/web", "invalid match.cwd"},
+		{"env: =billing", "invalid match.env"},
+	}
+	for _, tt := range tests {
+		var m config.MatchRules
+		if err := yaml.Unmarshal([]byte(tt.match), &m); err != nil {
+			t.Fatalf("unmarshal %q: %v", tt.match, err)
+		}
+		err := m.Compile("match")
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Compile(%q) error = %v, want %q", tt.match, err, tt.wantErr)
+		}
+	}
+}
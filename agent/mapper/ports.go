@@ -0,0 +1,146 @@
+package mapper
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"vps-screener/agent/config"
+)
+
+// Socket states in /proc/net/{tcp,udp}: TCP_LISTEN, and TCP_CLOSE, which is
+// what a bound but unconnected UDP socket shows.
+const (
+	tcpListen = "0A"
+	udpBound  = "07"
+)
+
+// listenSockets resolves the ports processes listen on. The socket tables of
+// each network namespace are read at most once, so one instance is shared by
+// all processes of a tick and checking a process only costs reading its fds.
+type listenSockets struct {
+	tables map[string]map[uint64]int // Network namespace -> socket inode -> port
+}
+
+func newListenSockets() *listenSockets {
+	return &listenSockets{tables: make(map[string]map[uint64]int)}
+}
+
+// table returns the listening sockets of pid's network namespace, or nil if
+// the namespace cannot be read (another user's process without root).
+func (l *listenSockets) table(pid int32) map[uint64]int {
+	ns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		return nil
+	}
+	t, ok := l.tables[ns]
+	if !ok {
+		t = readListenSockets(fmt.Sprintf("/proc/%d/net", pid))
+		l.tables[ns] = t
+	}
+	return t
+}
+
+// readListenSockets parses the TCP and UDP socket tables in dir (e.g.
+// /proc/<pid>/net) and returns the inodes of listening sockets with their port.
+func readListenSockets(dir string) map[uint64]int {
+	sockets := make(map[uint64]int)
+	for _, proto := range []struct{ file, state string }{
+		{"tcp", tcpListen}, {"tcp6", tcpListen}, {"udp", udpBound}, {"udp6", udpBound},
+	} {
+		file, err := os.Open(dir + "/" + proto.file)
+		if err != nil {
+			continue // e.g. no IPv6
+		}
+		parseSocketTable(bufio.NewScanner(file), proto.state, sockets)
+		file.Close()
+	}
+	return sockets
+}
+
+// parseSocketTable adds the sockets in the given state to sockets. Lines look like
+//
+//	sl  local_address rem_address   st ... uid  timeout inode
+//	0: 00000000:1F90 00000000:0000 0A ... 0        0 12345 ...
+func parseSocketTable(scanner *bufio.Scanner, state string, sockets map[uint64]int) {
+	scanner.Scan() // Header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[3] != state {
+			continue
+		}
+		// A UDP socket with a remote port is connected, i.e. a client.
+		if state == udpBound && !strings.HasSuffix(fields[2], ":0000") {
+			continue
+		}
+		_, portHex, ok := strings.Cut(fields[1], ":")
+		if !ok {
+			continue
+		}
+		port, err := strconv.ParseUint(portHex, 16, 16)
+		if err != nil || port == 0 {
+			continue
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil || inode == 0 {
+			continue
+		}
+		sockets[inode] = int(port)
+	}
+}
+
+// socketInodes returns the inodes of the sockets pid has open.
+func socketInodes(pid int32) []uint64 {
+	dir := fmt.Sprintf("/proc/%d/fd", pid)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil
+	}
+	var inodes []uint64
+	for _, entry := range entries {
+		target, err := os.Readlink(dir + "/" + entry.Name())
+		if err != nil || !strings.HasPrefix(target, "socket:[") {
+			continue
+		}
+		if inode, err := strconv.ParseUint(strings.TrimSuffix(target[len("socket:["):], "]"), 10, 64); err == nil {
+			inodes = append(inodes, inode)
+		}
+	}
+	return inodes
+}
+
+// listeningPorts returns the sorted ports of the listening sockets among inodes.
+func listeningPorts(table map[uint64]int, inodes []uint64) []int {
+	seen := make(map[int]bool)
+	var ports []int
+	for _, inode := range inodes {
+		if port, ok := table[inode]; ok && !seen[port] {
+			seen[port] = true
+			ports = append(ports, port)
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// anyListening reports whether any socket in table listens on a port in want.
+func anyListening(table map[uint64]int, want config.PortSet) bool {
+	for _, port := range table {
+		if want.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// formatPorts returns ports as "80, 443".
+func formatPorts(ports []int) string {
+	s := make([]string, len(ports))
+	for i, port := range ports {
+		s[i] = strconv.Itoa(port)
+	}
+	return strings.Join(s, ", ")
+}
@@ -0,0 +1,119 @@
+package mapper
+
+import (
+	"bufio"
+	"io"
+	"log"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"vps-screener/agent/config"
+)
+
+func TestParseSocketTable(t *testing.T) {
+	tcp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode
+   0: 00000000:1F90 00000000:0000 0A 00000000:00000000 00:00000000 00000000     0        0 1001 1 0000000000000000 100 0 0 10 0
+   1: 0100007F:0CEA 00000000:0000 0A 00000000:00000000 00:00000000 00000000   999        0 1002 1 0000000000000000 100 0 0 10 0
+   2: 0100007F:C61A 0100007F:1F90 01 00000000:00000000 02:000007E0 00000000     0        0 1003 2 0000000000000000 20 4 0 21 -1
+`
+	udp := `  sl  local_address rem_address   st tx_queue rx_queue tr tm->when retrnsmt   uid  timeout inode ref pointer drops
+  10: 00000000:0035 00000000:0000 07 00000000:00000000 00:00000000 00000000     0        0 2001 2 0000000000000000 0
+  11: 0A00000F:D431 08080808:0035 01 00000000:00000000 00:00000000 00000000     0        0 2002 2 0000000000000000 0
+  12: 0A00000F:D432 08080808:0035 07 00000000:00000000 00:00000000 00000000     0        0 2003 2 0000000000000000 0
+`
+	sockets := make(map[uint64]int)
+	parseSocketTable(bufio.NewScanner(strings.NewReader(tcp)), tcpListen, sockets)
+	parseSocketTable(bufio.NewScanner(strings.NewReader(udp)), udpBound, sockets)
+
+	want := map[uint64]int{1001: 8080, 1002: 3306, 2001: 53}
+	if !reflect.DeepEqual(sockets, want) {
+		t.Errorf("sockets = %v, want %v", sockets, want)
+	}
+	if got := listeningPorts(sockets, []uint64{2001, 1001, 1003, 1001}); !reflect.DeepEqual(got, []int{53, 8080}) {
+		t.Errorf("listeningPorts = %v, want [53 8080]", got)
+	}
+}
+
+func TestListenPortOwnProcess(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("cannot listen: %v", err)
+	}
+	defer ln.Close()
+	port := ln.Addr().(*net.TCPAddr).Port
+
+	f := &processFacts{PID: int32(os.Getpid()), sockets: newListenSockets()}
+	if f.socketTable() == nil {
+		t.Skip("/proc socket tables not readable")
+	}
+	m := config.MatchRules{ListenPort: config.PortSet{Ranges: [][2]int{{port, port}}}}
+	if ok, reason := evalMatch(m, f); !ok {
+		t.Errorf("listen_port %d did not match the listening test process (ports %v)", port, f.listenPorts())
+	} else if !strings.Contains(reason, "listening port") {
+		t.Errorf("reason = %q", reason)
+	}
+}
+
+func TestPortSetYAML(t *testing.T) {
+	tests := map[string]string{
+		`8080`:                   "8080",
+		`"9000-9010"`:            "9000-9010",
+		`[80, 443, "9000-9010"]`: "80, 443, 9000-9010",
+		`[" 22 - 23 "]`:          "22-23",
+	}
+	for src, want := range tests {
+		var s config.PortSet
+		if err := yaml.Unmarshal([]byte(src), &s); err != nil {
+			t.Errorf("unmarshal %s: %v", src, err)
+			continue
+		}
+		if got := s.String(); got != want {
+			t.Errorf("unmarshal %s = %q, want %q", src, got, want)
+		}
+	}
+	for _, src := range []string{`0`, `65536`, `"9010-9000"`, `http`, `[]`, `{port: 80}`, `[[80]]`} {
+		var s config.PortSet
+		if err := yaml.Unmarshal([]byte(src), &s); err == nil {
+			t.Errorf("unmarshal %s = %v, want an error", src, s)
+		}
+	}
+}
+
+// A process that checked a listen_port rule and missed a group is evaluated
+// again next tick, but keeps its project and groups in the meantime.
+func TestMapProcessesListenPortMiss(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	ResetCache()
+
+	cfg := &config.Config{
+		Projects: []config.ProjectConfig{
+			{Name: "api", Match: config.MatchRules{ListenPort: config.PortSet{Ranges: [][2]int{{9999, 9999}}}}},
+			{Name: "app", Match: config.MatchRules{ProcessNamePattern: config.ProcessPattern{Pattern: "^node$"}}},
+		},
+		Groups: []config.GroupConfig{
+			{Name: "team:db", Match: config.MatchRules{User: "postgres"}},
+		},
+	}
+	for i := range cfg.Projects {
+		if err := cfg.Projects[i].Match.Compile("match"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	procs := syntheticProcesses(2) // 0 nginx, 1 node
+	for tick := 1; tick <= 2; tick++ {
+		mappings := MapProcesses(procs, cfg)
+		if m := mappings[fakePIDBase+1]; m.Project != "app" || len(m.Groups) != 0 {
+			t.Errorf("tick %d: node process mapped to %+v, want project app", tick, m)
+		}
+		if len(mappingCache) != 0 {
+			t.Errorf("tick %d: %d cached mappings, want none while listen_port may still match", tick, len(mappingCache))
+		}
+	}
+}
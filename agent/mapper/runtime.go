@@ -0,0 +1,185 @@
+package mapper
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Container runtimes recognised from cgroup paths.
+const (
+	RuntimeDocker     = "docker"
+	RuntimePodman     = "podman"
+	RuntimeContainerd = "containerd" // Including nerdctl and Kubernetes on containerd (k3s)
+	RuntimeCRIO       = "cri-o"
+	RuntimeLXC        = "lxc"    // Including LXD/Incus
+	RuntimeNspawn     = "nspawn" // Machines registered with systemd-machined
+)
+
+// ContainerRef identifies a container and the runtime that runs it.
+type ContainerRef struct {
+	Runtime   string
+	ID        string // Container ID; the container name for LXC and nspawn, which have no IDs
+	Namespace string // containerd namespace, e.g. "default" or "k8s.io"
+}
+
+// IsZero reports whether the process is not in a recognised container.
+func (r ContainerRef) IsZero() bool {
+	return r.ID == ""
+}
+
+func (r ContainerRef) String() string {
+	s := r.Runtime + " " + r.ID
+	if r.Namespace != "" {
+		s += " (namespace " + r.Namespace + ")"
+	}
+	return s
+}
+
+// containerScopes are the systemd scopes runtimes using the systemd cgroup
+// driver put containers in, e.g. "libpod-<id>.scope".
+var containerScopes = []struct {
+	prefix    string
+	runtime   string
+	namespace string
+}{
+	{"docker-", RuntimeDocker, ""},
+	{"libpod-", RuntimePodman, ""},
+	{"nerdctl-", RuntimeContainerd, "default"},
+	{"cri-containerd-", RuntimeContainerd, "k8s.io"},
+	{"crio-", RuntimeCRIO, ""},
+}
+
+var (
+	containerIDPattern      = regexp.MustCompile(`^[0-9a-f]{64}$`)
+	shortContainerIDPattern = regexp.MustCompile(`^[0-9a-f]{12,64}$`) // Docker's cgroupfs paths may hold a short ID
+)
+
+// parseContainerCgroup finds the container a process runs in from the lines of
+// /proc/<pid>/cgroup. Path components are checked innermost first, so a
+// process in a nested cgroup below the container still counts. Layouts:
+//
+//	docker:     /docker/<id>, /system.slice/docker-<id>.scope
+//	podman:     /machine.slice/libpod-<id>.scope[/container], .../user@1000.service/user.slice/libpod-<id>.scope (rootless),
+//	            /libpod_parent/libpod-<id> (cgroupfs)
+//	containerd: /system.slice/nerdctl-<id>.scope, /<namespace>/<id> (cgroupfs), cri-containerd-<id>.scope and
+//	            /kubepods/.../pod<uid>/<id> (Kubernetes, namespace k8s.io)
+//	cri-o:      crio-<id>.scope
+//	lxc:        /lxc/<name> (cgroup v1), /lxc.payload.<name> (LXC 4+, LXD, Incus)
+//	nspawn:     /machine.slice/machine-<name>.scope/payload, /machine.slice/systemd-nspawn@<name>.service/payload
+//
+// Runtime helpers (conmon, lxc.monitor, the nspawn supervisor) are not part of
+// the container. It returns a zero ContainerRef if the process is not in a container.
+func parseContainerCgroup(lines []string) ContainerRef {
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if ref := parseContainerPath(parts[2]); !ref.IsZero() {
+			return ref
+		}
+	}
+	return ContainerRef{}
+}
+
+func parseContainerPath(cgroupPath string) ContainerRef {
+	names := strings.Split(strings.TrimSuffix(cgroupPath, " (deleted)"), "/")
+	for i := len(names) - 1; i > 0; i-- {
+		name, parent := names[i], names[i-1]
+		var child string
+		if i+1 < len(names) {
+			child = names[i+1]
+		}
+
+		if scope, ok := strings.CutSuffix(name, ".scope"); ok {
+			for _, cs := range containerScopes {
+				if id, ok := strings.CutPrefix(scope, cs.prefix); ok && containerIDPattern.MatchString(id) {
+					return ContainerRef{Runtime: cs.runtime, ID: id, Namespace: cs.namespace}
+				}
+			}
+			if machine, ok := strings.CutPrefix(scope, "machine-"); ok && child != "supervisor" {
+				return ContainerRef{Runtime: RuntimeNspawn, ID: unescapeUnitName(machine)}
+			}
+		}
+		if machine, ok := strings.CutPrefix(name, "systemd-nspawn@"); ok && strings.HasSuffix(machine, ".service") && child != "supervisor" {
+			return ContainerRef{Runtime: RuntimeNspawn, ID: unescapeUnitName(strings.TrimSuffix(machine, ".service"))}
+		}
+		if container, ok := strings.CutPrefix(name, "lxc.payload."); ok && container != "" {
+			return ContainerRef{Runtime: RuntimeLXC, ID: container}
+		}
+		if id, ok := strings.CutPrefix(name, "libpod-"); ok && containerIDPattern.MatchString(id) {
+			return ContainerRef{Runtime: RuntimePodman, ID: id}
+		}
+
+		switch {
+		case parent == "docker" && shortContainerIDPattern.MatchString(name):
+			return ContainerRef{Runtime: RuntimeDocker, ID: name}
+		case parent == "lxc" && i == 2: // /lxc/<name>, cgroup v1
+			return ContainerRef{Runtime: RuntimeLXC, ID: name}
+		case containerIDPattern.MatchString(name) && strings.HasPrefix(parent, "pod") && strings.Contains(cgroupPath, "kubepods"):
+			return ContainerRef{Runtime: RuntimeContainerd, ID: name, Namespace: "k8s.io"}
+		case containerIDPattern.MatchString(name) && i == 2 && parent != "":
+			return ContainerRef{Runtime: RuntimeContainerd, ID: name, Namespace: parent}
+		}
+	}
+	return ContainerRef{}
+}
+
+// unescapeUnitName undoes systemd's escaping of unit names, e.g. "my\x2dbox" -> "my-box".
+func unescapeUnitName(name string) string {
+	var b strings.Builder
+	for i := 0; i < len(name); i++ {
+		if name[i] == '\\' && i+3 < len(name) && name[i+1] == 'x' {
+			if c, err := strconv.ParseUint(name[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(name[i])
+	}
+	return b.String()
+}
+
+// containerInfo is the part of a container's metadata the mapper uses.
+type containerInfo struct {
+	Name   string            // Without the leading "/", e.g. "project_b_node_1"
+	Labels map[string]string // Never nil
+}
+
+// containerBackend looks up the name and labels of one runtime's containers.
+type containerBackend interface {
+	inspect(ref ContainerRef) (containerInfo, error)
+}
+
+// containerBackends are the backends by runtime. CRI-O only runs Kubernetes
+// containers, whose metadata comes from the pod (k8s_* criteria), so it has none.
+var containerBackends = map[string]containerBackend{
+	RuntimeDocker:     cliBackend{cli: "docker"},
+	RuntimePodman:     cliBackend{cli: "podman"},
+	RuntimeContainerd: cliBackend{cli: "nerdctl", namespaceFlag: "--namespace"},
+	RuntimeLXC:        nameBackend{},
+	RuntimeNspawn:     nameBackend{},
+}
+
+// inspectContainer returns a container's name and labels from its runtime's backend.
+func inspectContainer(ref ContainerRef) (containerInfo, error) {
+	if ref.IsZero() {
+		return containerInfo{}, fmt.Errorf("container ID cannot be empty")
+	}
+	backend, ok := containerBackends[ref.Runtime]
+	if !ok {
+		return containerInfo{}, fmt.Errorf("no lookup for %s containers", ref.Runtime)
+	}
+	return backend.inspect(ref)
+}
+
+// nameBackend serves runtimes whose containers are known by name and have no
+// labels (LXC, nspawn). The name is in the cgroup path, so nothing is looked up.
+type nameBackend struct{}
+
+func (nameBackend) inspect(ref ContainerRef) (containerInfo, error) {
+	return containerInfo{Name: ref.ID, Labels: map[string]string{}}, nil
+}
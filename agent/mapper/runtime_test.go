@@ -0,0 +1,97 @@
+package mapper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Each fixture in testdata/containers is a /proc/<pid>/cgroup file recorded
+// for a process in a container of the given runtime and cgroup layout.
+func TestParseContainerCgroup(t *testing.T) {
+	tests := map[string]ContainerRef{
+		"docker-cgroupfs-v1":         {Runtime: RuntimeDocker, ID: testContainerID},
+		"docker-systemd-v2":          {Runtime: RuntimeDocker, ID: testContainerID},
+		"docker-rootless-v2":         {Runtime: RuntimeDocker, ID: testContainerID},
+		"docker-in-lxc-v2":           {Runtime: RuntimeDocker, ID: testContainerID},
+		"podman-root-v2":             {Runtime: RuntimePodman, ID: testContainerID},
+		"podman-rootless-v2":         {Runtime: RuntimePodman, ID: testContainerID},
+		"podman-cgroupfs-v1":         {Runtime: RuntimePodman, ID: testContainerID},
+		"podman-conmon-v2":           {},
+		"nerdctl-systemd-v2":         {Runtime: RuntimeContainerd, ID: testContainerID, Namespace: "default"},
+		"containerd-cgroupfs-v1":     {Runtime: RuntimeContainerd, ID: testContainerID, Namespace: "default"},
+		"k3s-containerd-v2":          {Runtime: RuntimeContainerd, ID: testContainerID, Namespace: "k8s.io"},
+		"kube-systemd-containerd-v2": {Runtime: RuntimeContainerd, ID: testContainerID, Namespace: "k8s.io"},
+		"kube-crio-v2":               {Runtime: RuntimeCRIO, ID: testContainerID},
+		"lxc-v1":                     {Runtime: RuntimeLXC, ID: "web"},
+		"lxc-payload-v2":             {Runtime: RuntimeLXC, ID: "web"},
+		"lxc-monitor-v2":             {},
+		"nspawn-machine-v2":          {Runtime: RuntimeNspawn, ID: "my-box"},
+		"nspawn-service-v2":          {Runtime: RuntimeNspawn, ID: "db"},
+		"nspawn-supervisor-v2":       {},
+		"host-service-v1":            {},
+		"host-service-v2":            {},
+	}
+	fixtures, err := filepath.Glob(filepath.Join("testdata", "containers", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) != len(tests) {
+		t.Errorf("%d fixtures for %d test cases", len(fixtures), len(tests))
+	}
+	for name, want := range tests {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", "containers", name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := parseContainerCgroup(strings.Split(strings.TrimSpace(string(data)), "\n")); got != want {
+				t.Errorf("parseContainerCgroup = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestParseContainerCgroupShortDockerID(t *testing.T) {
+	got := parseContainerCgroup([]string{"12:pids:/docker/ab3580288496"})
+	if want := (ContainerRef{Runtime: RuntimeDocker, ID: "ab3580288496"}); got != want {
+		t.Errorf("parseContainerCgroup = %+v, want %+v", got, want)
+	}
+}
+
+func TestParseInspectOutput(t *testing.T) {
+	// docker and nerdctl prefix the name with "/", podman does not.
+	for _, output := range []string{
+		`[{"Id":"4f1c","Name":"/shop_web_1","Config":{"Labels":{"com.docker.compose.project":"shop"}}}]`,
+		`[{"Id":"4f1c","Name":"shop_web_1","Config":{"Labels":{"com.docker.compose.project":"shop"}}}]`,
+	} {
+		c, err := parseInspectOutput([]byte(output))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c.Name != "shop_web_1" || c.Labels[composeProjectLabel] != "shop" {
+			t.Errorf("parseInspectOutput(%s) = %+v", output, c)
+		}
+	}
+	c, err := parseInspectOutput([]byte(`[{"Name":"/bare","Config":{"Labels":null}}]`))
+	if err != nil || c.Labels == nil {
+		t.Errorf("labels of a container without labels = %v, %v, want an empty map", c.Labels, err)
+	}
+}
+
+func TestInspectContainerBackends(t *testing.T) {
+	for _, runtime := range []string{RuntimeLXC, RuntimeNspawn} {
+		c, err := inspectContainer(ContainerRef{Runtime: runtime, ID: "web"})
+		if err != nil || c.Name != "web" || c.Labels == nil {
+			t.Errorf("%s: inspectContainer = %+v, %v, want name web", runtime, c, err)
+		}
+	}
+	if _, err := inspectContainer(ContainerRef{Runtime: RuntimeCRIO, ID: testContainerID}); err == nil {
+		t.Errorf("cri-o container inspected without a backend")
+	}
+	args := cliBackend{cli: "nerdctl", namespaceFlag: "--namespace"}.command(ContainerRef{Runtime: RuntimeContainerd, ID: "abc", Namespace: "k8s.io"}, "inspect", "abc").Args
+	if got := strings.Join(args, " "); got != "nerdctl --namespace k8s.io inspect abc" {
+		t.Errorf("nerdctl command = %q", got)
+	}
+}
@@ -0,0 +1,114 @@
+package mapper
+
+import (
+	"strings"
+)
+
+// unitSuffixes are the systemd unit types that can contain processes.
+var unitSuffixes = []string{".service", ".scope", ".socket", ".mount", ".swap"}
+
+// systemdCgroup is a process's place in the cgroup tree systemd manages.
+type systemdCgroup struct {
+	Path   string   // e.g. "/system.slice/my-app.service"
+	Slices []string // Enclosing slices, outermost first, e.g. ["projects.slice", "projects-billing.slice"]
+	Units  []string // Enclosing units, outermost first, e.g. ["user@1000.service", "app-foo.service"]
+}
+
+// parseSystemdCgroup reads the systemd hierarchy from the lines of
+// /proc/<pid>/cgroup. It uses the name=systemd hierarchy on cgroup v1 and
+// hybrid hosts and the unified hierarchy ("0::") on cgroup v2 hosts:
+//
+//	v1:     1:name=systemd:/system.slice/my-app.service
+//	hybrid: 1:name=systemd:/system.slice/my-app.service and 0::/system.slice/my-app.service
+//	v2:     0::/system.slice/my-app.service
+func parseSystemdCgroup(lines []string) systemdCgroup {
+	var path, unified string
+	for _, line := range lines {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		switch {
+		case parts[1] == "name=systemd":
+			path = parts[2]
+		case parts[0] == "0" && parts[1] == "":
+			unified = parts[2]
+		}
+	}
+	if path == "" || path == "/" {
+		path = unified
+	}
+	// Cgroups of removed units are shown with this suffix until the process exits.
+	path = strings.TrimSuffix(path, " (deleted)")
+
+	cg := systemdCgroup{Path: path}
+	for _, name := range strings.Split(path, "/") {
+		switch {
+		case strings.HasSuffix(name, ".slice"):
+			cg.Slices = append(cg.Slices, name)
+		case isUnitName(name):
+			cg.Units = append(cg.Units, name)
+		}
+	}
+	return cg
+}
+
+func isUnitName(name string) bool {
+	for _, suffix := range unitSuffixes {
+		if strings.HasSuffix(name, suffix) && len(name) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Unit returns the unit the process runs in, e.g. "my-app.service" or
+// "app-foo.service" for a user unit, or "" if it is not in a unit. The user
+// manager itself (user@1000.service/init.scope) is reported as user@1000.service.
+func (c systemdCgroup) Unit() string {
+	n := len(c.Units)
+	if n == 0 {
+		return ""
+	}
+	if n > 1 && c.Units[n-1] == "init.scope" && strings.HasPrefix(c.Units[n-2], "user@") {
+		return c.Units[n-2]
+	}
+	return c.Units[n-1]
+}
+
+// MatchesUnit reports whether the process belongs to the given unit or slice:
+//   - "my-app.service" (or "my-app") matches processes in that unit or in a cgroup below it,
+//     including user units below a user manager such as "user@1000.service"
+//   - "worker@.service" matches every instance of the template, e.g. "worker@3.service";
+//     socket-activated per-connection services ("sshd@0-10.0.0.1:22-10.0.0.2:5000.service") too
+//   - "projects-billing.slice" matches processes anywhere below that slice
+func (c systemdCgroup) MatchesUnit(want string) bool {
+	if strings.HasSuffix(want, ".slice") {
+		for _, slice := range c.Slices {
+			if slice == want {
+				return true
+			}
+		}
+		return false
+	}
+	if !isUnitName(want) {
+		want += ".service" // Like systemctl, a name without a type is a service
+	}
+	for _, unit := range c.Units {
+		if unit == want || unitTemplate(unit) == want {
+			return true
+		}
+	}
+	return false
+}
+
+// unitTemplate returns the template of an instance unit, e.g. "worker@.service"
+// for "worker@3.service", or "" if the unit is not an instance.
+func unitTemplate(unit string) string {
+	at := strings.IndexByte(unit, '@')
+	dot := strings.LastIndexByte(unit, '.')
+	if at < 0 || dot < at || at+1 == dot {
+		return ""
+	}
+	return unit[:at+1] + unit[dot:]
+}
@@ -0,0 +1,128 @@
+package mapper
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Each fixture in testdata/cgroup/<layout>/<case> is a /proc/<pid>/cgroup file
+// for the same process on a cgroup v1, hybrid or v2 host, so every layout
+// must give the same result.
+var systemdCases = []struct {
+	name     string
+	unit     string
+	matches  []string
+	excludes []string
+}{
+	{
+		name:     "service",
+		unit:     "my-app.service",
+		matches:  []string{"my-app.service", "my-app", "system.slice"},
+		excludes: []string{"app.service", "p.service", "my-app.scope", "user.slice"},
+	},
+	{
+		name:     "template",
+		unit:     "worker@3.service",
+		matches:  []string{"worker@3.service", "worker@.service", "worker@3", "system-worker.slice"},
+		excludes: []string{"worker@4.service", "worker.service"},
+	},
+	{
+		name:     "socket-activated",
+		unit:     "sshd@0-10.0.0.1:22-10.0.0.2:51234.service",
+		matches:  []string{"sshd@.service", "system-sshd.slice"},
+		excludes: []string{"sshd.service", "sshd.socket"},
+	},
+	{
+		name:     "container-scope",
+		unit:     "docker-4f1c2a0e9d8b7c6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a.scope",
+		matches:  []string{"docker-4f1c2a0e9d8b7c6a5f4e3d2c1b0a9f8e7d6c5b4a3f2e1d0c9b8a7f6e5d4c3b2a.scope"},
+		excludes: []string{"docker.service"},
+	},
+	{
+		name:     "session-scope",
+		unit:     "session-3.scope",
+		matches:  []string{"session-3.scope", "user-1000.slice", "user.slice"},
+		excludes: []string{"user@1000.service"},
+	},
+	{
+		name:     "user-unit",
+		unit:     "app-foo.service",
+		matches:  []string{"app-foo.service", "user@1000.service", "user@.service", "app.slice", "user-1000.slice"},
+		excludes: []string{"user@1001.service", "system.slice"},
+	},
+	{
+		name:     "user-manager",
+		unit:     "user@1000.service",
+		matches:  []string{"user@1000.service", "user@.service"},
+		excludes: []string{"app-foo.service"},
+	},
+	{
+		name:     "project-slice",
+		unit:     "billing-api.service",
+		matches:  []string{"billing-api.service", "projects-billing.slice", "projects.slice"},
+		excludes: []string{"projects-web.slice", "system.slice"},
+	},
+	{
+		name:    "delegated",
+		unit:    "my-app.service",
+		matches: []string{"my-app.service"},
+	},
+	{
+		name:     "none",
+		unit:     "",
+		excludes: []string{"init.scope", "-.slice", ".service"},
+	},
+}
+
+func TestParseSystemdCgroup(t *testing.T) {
+	for _, layout := range []string{"v1", "hybrid", "v2"} {
+		for _, tc := range systemdCases {
+			t.Run(layout+"/"+tc.name, func(t *testing.T) {
+				data, err := os.ReadFile(filepath.Join("testdata", "cgroup", layout, tc.name))
+				if err != nil {
+					t.Fatal(err)
+				}
+				cg := parseSystemdCgroup(strings.Split(strings.TrimSpace(string(data)), "\n"))
+				if got := cg.Unit(); got != tc.unit {
+					t.Errorf("Unit() = %q, want %q (path %q)", got, tc.unit, cg.Path)
+				}
+				for _, want := range tc.matches {
+					if !cg.MatchesUnit(want) {
+						t.Errorf("MatchesUnit(%q) = false, want true (path %q)", want, cg.Path)
+					}
+				}
+				for _, want := range tc.excludes {
+					if cg.MatchesUnit(want) {
+						t.Errorf("MatchesUnit(%q) = true, want false (path %q)", want, cg.Path)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestParseSystemdCgroupPrefersSystemdHierarchy(t *testing.T) {
+	// Hybrid host where the unified hierarchy is not used for this process.
+	cg := parseSystemdCgroup([]string{"1:name=systemd:/system.slice/my-app.service", "0::/"})
+	if got := cg.Unit(); got != "my-app.service" {
+		t.Errorf("Unit() = %q, want my-app.service", got)
+	}
+}
+
+func TestUnitTemplate(t *testing.T) {
+	tests := map[string]string{
+		"worker@3.service":        "worker@.service",
+		"getty@tty1.service":      "getty@.service",
+		"user@1000.service":       "user@.service",
+		"worker@.service":         "",
+		"my-app.service":          "",
+		"sshd@0-1.2.3.4:22.scope": "sshd@.scope",
+	}
+	for unit, want := range tests {
+		if got := unitTemplate(unit); got != want {
+			t.Errorf("unitTemplate(%q) = %q, want %q", unit, got, want)
+		}
+	}
+}
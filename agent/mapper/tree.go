@@ -0,0 +1,151 @@
+package mapper
+
+import (
+	"log"
+
+	"github.com/elastic/go-sysinfo/types"
+	"vps-screener/agent/config"
+)
+
+// maxAncestors bounds the PPID walk, guarding against cycles from PID reuse.
+const maxAncestors = 64
+
+// Mapping is the project a process was assigned to.
+type Mapping struct {
+	Project    string   // "" if the process only belongs to groups
+	Discovered bool     // Created by a discovery rule rather than configured in 'projects'
+	Inherited  bool     // True if the process had no match of its own
+	FromPID    int      // For inherited mappings, the ancestor whose project was inherited
+	Groups     []string // Groups the process belongs to, in config order
+}
+
+// MapProcesses maps all processes of a tick to projects: configured projects
+// first, then discovery rules, and to every group it belongs to. Processes with
+// neither a project nor a group are left out. With agent_settings.process_inheritance, a process
+// without a match of its own inherits the project of its nearest matched
+// ancestor, unless that project sets inherit: false. init (PID 1) and kthreadd
+// (PID 2) are never inherited from. Groups are not inherited, but a group that
+// lists a project includes the processes that inherited it.
+//
+// Direct matches are cached per process (see ResetCache), so the rules are
+// evaluated, and a match is logged, only once in a process's life. Processes
+// that missed a project or group after a listen_port rule was checked are
+// mapped but not cached, so they are evaluated again next tick.
+func MapProcesses(processes []types.Process, cfg *config.Config) map[int]Mapping {
+	reconcileContainerCache()
+
+	mappingCacheMutex.Lock()
+	defer mappingCacheMutex.Unlock()
+	if mappingCacheConfig != cfg {
+		mappingCache = make(map[procKey]cachedMapping)
+		mappingCacheConfig = cfg
+		usernameCacheMutex.Lock()
+		usernameCache = make(map[string]string)
+		usernameCacheMutex.Unlock()
+	}
+	if generation := containerGeneration.Load(); generation != mappingCacheContainers {
+		mappingCache = make(map[procKey]cachedMapping)
+		mappingCacheContainers = generation
+	}
+	if generation := kubeGeneration.Load(); generation != mappingCacheKube {
+		mappingCache = make(map[procKey]cachedMapping)
+		mappingCacheKube = generation
+	}
+	mappingCacheTick++
+	sockets := newListenSockets()
+
+	direct := make(map[int]Mapping, len(processes))
+	parents := make(map[int]int, len(processes))
+	names := make(map[int]string, len(processes))
+	for _, p := range processes {
+		pid := p.PID()
+		info, err := p.Info()
+		if err != nil {
+			// Without a start time the process cannot be cached safely.
+			facts := newProcessFacts(p, info, err)
+			facts.sockets = sockets
+			if mapping := mapProcess(facts, cfg); mapping.Project != "" || len(mapping.Groups) > 0 {
+				direct[pid] = mapping
+			}
+			continue
+		}
+		parents[pid] = info.PPID
+		names[pid] = info.Name
+
+		key := newProcKey(pid, info.StartTime)
+		entry, cached := mappingCache[key]
+		if !cached {
+			facts := newProcessFacts(p, info, nil)
+			facts.sockets = sockets
+			entry.mapping = mapProcess(facts, cfg)
+			// A process may open its listening socket after it was first seen,
+			// so a miss that depended on listen_port is checked again next tick.
+			cached = !facts.portsChecked || (entry.mapping.Project != "" && len(entry.mapping.Groups) == len(cfg.Groups))
+		}
+		if cached {
+			entry.tick = mappingCacheTick
+			mappingCache[key] = entry
+		}
+		if entry.mapping.Project != "" || len(entry.mapping.Groups) > 0 {
+			direct[pid] = entry.mapping
+		}
+	}
+	// Forget processes that have exited.
+	for key, entry := range mappingCache {
+		if entry.tick != mappingCacheTick {
+			delete(mappingCache, key)
+		}
+	}
+
+	mappings := make(map[int]Mapping, len(direct))
+	for pid, mapping := range direct {
+		mappings[pid] = mapping
+	}
+	if cfg.AgentSettings.ProcessInheritance {
+		inheritProjects(processes, cfg, direct, parents, names, mappings)
+	}
+	if len(cfg.Groups) > 0 {
+		for pid, mapping := range mappings {
+			mapping.Groups = withProjectGroups(cfg.Groups, mapping.Project, mapping.Groups)
+			mappings[pid] = mapping
+		}
+	}
+	return mappings
+}
+
+// inheritProjects adds the inherited mappings of processes without a project
+// of their own to mappings.
+func inheritProjects(processes []types.Process, cfg *config.Config, direct map[int]Mapping, parents map[int]int, names map[int]string, mappings map[int]Mapping) {
+	noInherit := make(map[string]bool) // Discovered projects always pass on
+	for _, proj := range cfg.Projects {
+		noInherit[proj.Name] = !proj.Inheritable()
+	}
+	for _, p := range processes {
+		pid := p.PID()
+		if direct[pid].Project != "" {
+			continue
+		}
+		if ancestor, mapping := nearestMatchedAncestor(pid, parents, direct); mapping.Project != "" && !noInherit[mapping.Project] {
+			mapping.Inherited, mapping.FromPID = true, ancestor
+			mapping.Groups = direct[pid].Groups
+			mappings[pid] = mapping
+			log.Printf("PID %d (%s) inherited project '%s' from PID %d", pid, names[pid], mapping.Project, ancestor)
+		}
+	}
+}
+
+// nearestMatchedAncestor walks the PPID chain of pid and returns the first
+// ancestor with a project of its own, or a zero Mapping if there is none below init.
+func nearestMatchedAncestor(pid int, parents map[int]int, direct map[int]Mapping) (int, Mapping) {
+	for i := 0; i < maxAncestors; i++ {
+		ppid, ok := parents[pid]
+		if !ok || ppid <= 2 || ppid == pid {
+			return 0, Mapping{}
+		}
+		if mapping, ok := direct[ppid]; ok && mapping.Project != "" {
+			return ppid, mapping
+		}
+		pid = ppid
+	}
+	return 0, Mapping{}
+}
@@ -0,0 +1,108 @@
+package mapper
+
+import (
+	"io"
+	"log"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-sysinfo/types"
+
+	"vps-screener/agent/config"
+)
+
+// treeProcess is a synthetic process named name with parent ppid. PIDs are
+// offsets from fakePIDBase; a ppid below 10 is taken as is.
+func treeProcess(pid, ppid int, name string) types.Process {
+	if ppid >= 10 {
+		ppid += fakePIDBase
+	}
+	return &fakeProcess{info: types.ProcessInfo{
+		Name:      name,
+		PID:       fakePIDBase + pid,
+		PPID:      ppid,
+		Exe:       "/usr/bin/" + name,
+		Args:      []string{name},
+		StartTime: time.Date(2026, 1, 1, 0, 0, 0, pid, time.UTC),
+	}}
+}
+
+func TestInheritProjects(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	ResetCache()
+
+	noInherit := false
+	cfg := &config.Config{
+		AgentSettings: config.AgentSettings{ProcessInheritance: true},
+		Projects: []config.ProjectConfig{
+			{Name: "web", Match: config.MatchRules{ProcessNamePattern: config.ProcessPattern{Pattern: "^nginx$"}}},
+			{Name: "app", Match: config.MatchRules{ProcessNamePattern: config.ProcessPattern{Pattern: "^node$"}}},
+			{Name: "batch", Match: config.MatchRules{ProcessNamePattern: config.ProcessPattern{Pattern: "^cron$"}}, Inherit: &noInherit},
+		},
+	}
+	for i := range cfg.Projects {
+		if err := cfg.Projects[i].Match.Compile("match"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	procs := []types.Process{
+		treeProcess(10, 1, "nginx"),
+		treeProcess(11, 10, "sh"),
+		treeProcess(12, 11, "worker"),
+		treeProcess(13, 11, "node"),
+		treeProcess(14, 13, "sleep"),
+		treeProcess(15, 1, "cron"),
+		treeProcess(16, 15, "sh"),
+		treeProcess(17, 2, "kworker"),
+		treeProcess(18, 99, "orphan"), // Parent already exited
+	}
+
+	mappings := MapProcesses(procs, cfg)
+	tests := []struct {
+		name      string
+		pid       int
+		project   string
+		inherited bool
+		from      int
+	}{
+		{"direct match", 10, "web", false, 0},
+		{"child", 11, "web", true, 10},
+		{"nearest matched ancestor", 12, "web", true, 10},
+		{"direct match takes priority", 13, "app", false, 0},
+		{"below a nested match", 14, "app", true, 13},
+		{"inherit: false", 15, "batch", false, 0},
+		{"child of inherit: false", 16, "", false, 0},
+		{"child of kthreadd", 17, "", false, 0},
+		{"unknown parent", 18, "", false, 0},
+	}
+	for _, tt := range tests {
+		mapping, ok := mappings[fakePIDBase+tt.pid]
+		if tt.project == "" {
+			if ok {
+				t.Errorf("%s: mapped to %+v, want no mapping", tt.name, mapping)
+			}
+			continue
+		}
+		from := 0
+		if tt.from != 0 {
+			from = fakePIDBase + tt.from
+		}
+		if mapping.Project != tt.project || mapping.Inherited != tt.inherited || mapping.FromPID != from {
+			t.Errorf("%s: mapping = %+v, want project %q, inherited %v, from PID %d", tt.name, mapping, tt.project, tt.inherited, from)
+		}
+	}
+
+	// Without process_inheritance only direct matches are mapped.
+	cfg.AgentSettings.ProcessInheritance = false
+	mappings = MapProcesses(procs, cfg)
+	if len(mappings) != 3 {
+		t.Errorf("without inheritance: %d mappings, want 3", len(mappings))
+	}
+	for pid, mapping := range mappings {
+		if mapping.Inherited {
+			t.Errorf("without inheritance PID %d inherited %q", pid, mapping.Project)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+
+	"vps-screener/agent/config"
+	"vps-screener/agent/remoteconfig"
+)
+
+// reloadDebounce is how long the watcher waits after the last change before
+// asking for a reload, so an editor's write-rename-chmod sequence is one reload.
+const reloadDebounce = time.Second
+
+// reloadConfig loads configPath again, with the same validation as at
+// startup. If the file is invalid it logs why and returns current, so a bad
+// edit never stops a running agent.
+func reloadConfig(configPath string, current *config.Config) *config.Config {
+	next, err := config.LoadConfig(configPath)
+	if err != nil {
+		log.Printf("Config reload failed, keeping the current configuration: %v", err)
+		return current
+	}
+	log.Printf("Configuration reloaded from %s: %d projects, %d groups, collection interval %ds",
+		configPath, len(next.Projects), len(next.Groups), next.AgentSettings.CollectionInterval)
+	logPluginProblems(next)
+	return next
+}
+
+// configWatcher requests a reload whenever the configuration file or a file
+// in the projects directory changes. It watches the file's directory rather
+// than the file, because editors and configuration management tools usually
+// replace the file instead of writing to it.
+type configWatcher struct {
+	watcher     *fsnotify.Watcher
+	projectsDir string
+}
+
+// watchConfig starts watching configPath and projectsDir. Reload requests are
+// sent to reloads without blocking; one pending request is enough.
+func watchConfig(configPath, projectsDir string, reloads chan<- struct{}) (*configWatcher, error) {
+	path, err := filepath.Abs(configPath)
+	if err != nil {
+		return nil, err
+	}
+	dropIns, err := filepath.Abs(projectsDir)
+	if err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	_ = watcher.Add(dropIns) // May not exist yet; it is added once it is created next to the config file
+
+	go func() {
+		var debounce <-chan time.Time
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				name := filepath.Clean(event.Name)
+				if event.Has(fsnotify.Chmod) {
+					continue
+				}
+				switch {
+				case name == dropIns:
+					if event.Has(fsnotify.Create) {
+						_ = watcher.Add(dropIns)
+					}
+				case name != path && (filepath.Dir(name) != dropIns || !isYAML(name)):
+					continue
+				}
+				debounce = time.After(reloadDebounce)
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Printf("Config watcher: %v", err)
+			case <-debounce:
+				debounce = nil
+				log.Printf("%s or %s changed, reloading configuration", configPath, projectsDir)
+				select {
+				case reloads <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return &configWatcher{watcher: watcher, projectsDir: projectsDir}, nil
+}
+
+// isYAML reports whether a file in the projects directory is read by LoadConfig.
+func isYAML(name string) bool {
+	ext := filepath.Ext(name)
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// Close stops watching.
+func (w *configWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+// updateWatcher starts or stops the watcher to follow agent_settings.watch_config
+// and projects_dir, and returns the watcher now in use, if any.
+func updateWatcher(w *configWatcher, cfg *config.Config, configPath string, reloads chan<- struct{}) *configWatcher {
+	if w != nil && (!cfg.AgentSettings.WatchConfig || w.projectsDir != cfg.ProjectsDir()) {
+		w.Close()
+		w = nil
+	}
+	if !cfg.AgentSettings.WatchConfig || w != nil {
+		return w
+	}
+	w, err := watchConfig(configPath, cfg.ProjectsDir(), reloads)
+	if err != nil {
+		log.Printf("Could not watch %s for changes, reload with SIGHUP instead: %v", configPath, err)
+		return nil
+	}
+	log.Printf("Watching %s and %s for changes", configPath, cfg.ProjectsDir())
+	return w
+}
+
+// remoteSync fetches the remote project list every remote_config.refresh_interval
+// and reports the revision in use to the gateway whenever it changes.
+type remoteSync struct {
+	configPath  string
+	next        time.Time // When to fetch again
+	reported    string    // Revision last reported to the gateway
+	hasReported bool
+}
+
+// run fetches the remote project list if it is due and returns the
+// configuration to use: current, or a new one with the changed list. If the
+// gateway is unreachable, the cached list stays in use.
+func (s *remoteSync) run(current *config.Config) *config.Config {
+	if current.RemoteConfig == nil || time.Now().Before(s.next) {
+		return current
+	}
+	s.next = time.Now().Add(time.Duration(current.RemoteRefreshInterval()) * time.Second)
+
+	next, applyErr := current, error(nil)
+	update, err := remoteconfig.Fetch(current)
+	switch {
+	case err != nil:
+		log.Printf("Could not fetch the remote project list, keeping revision %q: %v", current.RemoteRevision(), err)
+		return current
+	case update != nil:
+		next, applyErr = applyRemoteConfig(s.configPath, current, update)
+	}
+
+	if applyErr != nil || !s.hasReported || next.RemoteRevision() != s.reported {
+		if err := remoteconfig.Report(next, applyErr); err != nil {
+			log.Printf("Could not report the remote project list revision: %v", err)
+		} else {
+			s.reported, s.hasReported = next.RemoteRevision(), true
+		}
+	}
+	return next
+}
+
+// applyRemoteConfig loads configPath with a fetched project list and caches
+// the list once the result is valid. Otherwise it logs why and returns current
+// with the error, like reloadConfig does for a bad edit.
+func applyRemoteConfig(configPath string, current *config.Config, update *remoteconfig.Update) (*config.Config, error) {
+	next, err := config.LoadConfigWithRemote(configPath, update.Data, update.Signature)
+	if err != nil {
+		log.Printf("Remote project list rejected, keeping revision %q: %v", current.RemoteRevision(), err)
+		return current, err
+	}
+	if err := next.SaveRemoteCache(update.Data, update.Signature); err != nil {
+		log.Printf("Could not cache the remote project list: %v", err)
+	}
+	log.Printf("Remote project list revision %s applied: %d projects in total", next.RemoteRevision(), len(next.Projects))
+	logPluginProblems(next)
+	return next, nil
+}
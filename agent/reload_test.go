@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"vps-screener/agent/config"
+)
+
+const testConfig = "version: 2\napi_gateway: {url: http://gw, token: t}\nagent_settings: {collection_interval: %d}\nprojects:\n  - name: a\n    match: {user: a}\n"
+
+func writeConfig(t *testing.T, path, src string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func configWithInterval(interval int) string {
+	return fmt.Sprintf(testConfig, interval)
+}
+
+func TestReloadConfig(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig(t, path, configWithInterval(10))
+	current, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An invalid edit keeps the running configuration as it is.
+	for _, src := range []string{"projects: [", configWithInterval(10) + "  - name: a\n    match: {user: b}\n", ""} {
+		writeConfig(t, path, src)
+		if got := reloadConfig(path, current); got != current {
+			t.Errorf("invalid config %q replaced the current one", src)
+		}
+	}
+	os.Remove(path)
+	if got := reloadConfig(path, current); got != current {
+		t.Errorf("missing config file replaced the current one")
+	}
+
+	// A valid edit is a new configuration; the old one is not modified, so a
+	// tick still holding it sees consistent settings.
+	writeConfig(t, path, configWithInterval(30)+"  - name: b\n    match: {user: b}\n")
+	next := reloadConfig(path, current)
+	if next == current || next.AgentSettings.CollectionInterval != 30 || len(next.Projects) != 2 {
+		t.Fatalf("reloaded config = %+v", next)
+	}
+	if current.AgentSettings.CollectionInterval != 10 || len(current.Projects) != 1 {
+		t.Errorf("current config modified by the reload: %+v", current)
+	}
+}
+
+// expectReloads waits up to wait and returns the number of reload requests seen.
+func expectReloads(reloads <-chan struct{}, wait time.Duration) int {
+	n := 0
+	timeout := time.After(wait)
+	for {
+		select {
+		case <-reloads:
+			n++
+		case <-timeout:
+			return n
+		}
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	projectsDir := filepath.Join(dir, "projects.d")
+	writeConfig(t, path, configWithInterval(10))
+	reloads := make(chan struct{}, 1)
+	w, err := watchConfig(path, projectsDir, reloads)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Other files next to the config file are ignored.
+	writeConfig(t, filepath.Join(dir, "notes.txt"), "x")
+	if n := expectReloads(reloads, reloadDebounce+500*time.Millisecond); n != 0 {
+		t.Errorf("unrelated file: %d reloads", n)
+	}
+
+	// A burst of changes, including replacing the file, is one reload after
+	// the last change has settled.
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		writeConfig(t, path, configWithInterval(20+i))
+		time.Sleep(100 * time.Millisecond)
+	}
+	writeConfig(t, path+".tmp", configWithInterval(30))
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-reloads:
+		if elapsed := time.Since(start); elapsed < reloadDebounce {
+			t.Errorf("reload requested after %s, before the debounce", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after the config file changed")
+	}
+	if n := expectReloads(reloads, reloadDebounce+500*time.Millisecond); n != 0 {
+		t.Errorf("%d more reloads after one burst of changes", n)
+	}
+
+	// The projects directory is watched once it is created, for YAML files only.
+	if err := os.Mkdir(projectsDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	expectReloads(reloads, reloadDebounce+500*time.Millisecond)
+	writeConfig(t, filepath.Join(projectsDir, "README"), "x")
+	if n := expectReloads(reloads, reloadDebounce+500*time.Millisecond); n != 0 {
+		t.Errorf("non-YAML drop-in: %d reloads", n)
+	}
+	writeConfig(t, filepath.Join(projectsDir, "10-b.yaml"), "projects:\n  - name: b\n    match: {user: b}\n")
+	if n := expectReloads(reloads, reloadDebounce+500*time.Millisecond); n != 1 {
+		t.Errorf("drop-in: %d reloads, want 1", n)
+	}
+}
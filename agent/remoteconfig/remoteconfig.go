@@ -0,0 +1,137 @@
+// Package remoteconfig fetches the centrally managed project list from the API
+// gateway and reports back which revision of it a node applied. Verifying,
+// merging and caching the list is up to the config package.
+package remoteconfig
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"vps-screener/agent/config"
+)
+
+// SignatureHeader carries the base64 Ed25519 signature of the response body.
+const SignatureHeader = "X-Signature"
+
+// maxDocumentSize bounds the project list read from the gateway.
+const maxDocumentSize = 4 << 20
+
+// Update is a project list fetched from the gateway that differs from the cached one.
+type Update struct {
+	Data      []byte // The YAML document, exactly as signed
+	Signature []byte // Base64 signature from SignatureHeader
+}
+
+// Status is the payload POSTed to the gateway's /config/status endpoint.
+type Status struct {
+	Timestamp    int64  `json:"timestamp"` // Unix timestamp (seconds)
+	NodeHostname string `json:"node_hostname"`
+	Revision     string `json:"revision"`        // Revision in use, "" if none
+	Error        string `json:"error,omitempty"` // Why the latest fetched list was rejected
+}
+
+// Fetch downloads the project list for this node from the gateway. It returns
+// nil if the gateway has none for it or it is the one already cached.
+// The signature is not checked here but by config.LoadConfigWithRemote.
+func Fetch(cfg *config.Config) (*Update, error) {
+	client := &http.Client{
+		Timeout: 15 * time.Second,
+	}
+
+	configEndpoint := fmt.Sprintf("%s/config?node=%s", cfg.APIGateway.URL, url.QueryEscape(getHostname(cfg)))
+	req, err := http.NewRequest("GET", configEndpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create new HTTP request to %s: %w", configEndpoint, err)
+	}
+	req.Header.Set("Authorization", "Bearer "+cfg.APIGateway.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send HTTP request to %s: %w", configEndpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil // Nothing published for this node (yet)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("API gateway at %s returned error status %s", configEndpoint, resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxDocumentSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response from %s: %w", configEndpoint, err)
+	}
+	if len(data) > maxDocumentSize {
+		return nil, fmt.Errorf("project list from %s is larger than %d bytes", configEndpoint, maxDocumentSize)
+	}
+	signature := []byte(resp.Header.Get(SignatureHeader))
+	if len(signature) == 0 {
+		return nil, fmt.Errorf("project list from %s has no %s header", configEndpoint, SignatureHeader)
+	}
+
+	cached, cachedSignature, err := cfg.ReadRemoteCache()
+	if err == nil && bytes.Equal(cached, data) && bytes.Equal(bytes.TrimSpace(cachedSignature), bytes.TrimSpace(signature)) {
+		return nil, nil
+	}
+	return &Update{Data: data, Signature: signature}, nil
+}
+
+// Report tells the gateway which revision of the project list cfg uses and,
+// if applyErr is set, why the latest one fetched was not applied.
+func Report(cfg *config.Config, applyErr error) error {
+	status := Status{
+		Timestamp:    time.Now().Unix(),
+		NodeHostname: getHostname(cfg),
+		Revision:     cfg.RemoteRevision(),
+	}
+	if applyErr != nil {
+		status.Error = applyErr.Error()
+	}
+	jsonData, err := json.Marshal(status)
+	if err != nil {
+		return fmt.Errorf("failed to marshal config status: %w", err)
+	}
+
+	client := &http.Client{
+		Timeout: 15 * time.Second,
+	}
+
+	statusEndpoint := fmt.Sprintf("%s/config/status", cfg.APIGateway.URL)
+	req, err := http.NewRequest("POST", statusEndpoint, bytes.NewReader(jsonData))
+	if err != nil {
+		return fmt.Errorf("failed to create new HTTP request to %s: %w", statusEndpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+cfg.APIGateway.Token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send HTTP request to %s: %w", statusEndpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("API gateway at %s returned error status %s", statusEndpoint, resp.Status)
+	}
+	return nil
+}
+
+func getHostname(cfg *config.Config) string {
+	nodeHostname := cfg.AgentSettings.NodeIdentifier
+	if nodeHostname == "" {
+		hn, err := os.Hostname()
+		if err != nil {
+			log.Printf("Warning: Could not determine OS hostname: %v. Using 'unknown-host'.", err)
+			return "unknown-host"
+		}
+		return hn
+	}
+	return nodeHostname
+}
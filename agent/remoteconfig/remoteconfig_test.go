@@ -0,0 +1,87 @@
+package remoteconfig
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"vps-screener/agent/config"
+)
+
+// gateway stands in for the API gateway's /config and /config/status endpoints.
+type gateway struct {
+	document, signature string
+	statuses            []Status
+}
+
+func (g *gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer secret" {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1/config" && r.URL.Query().Get("node") == "node-1":
+		if g.document == "" {
+			http.NotFound(w, r)
+			return
+		}
+		if g.signature != "" {
+			w.Header().Set(SignatureHeader, g.signature)
+		}
+		w.Write([]byte(g.document))
+	case r.Method == "POST" && r.URL.Path == "/v1/config/status":
+		var status Status
+		if err := json.NewDecoder(r.Body).Decode(&status); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		g.statuses = append(g.statuses, status)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestFetchAndReport(t *testing.T) {
+	gw := &gateway{}
+	server := httptest.NewServer(gw)
+	defer server.Close()
+	cfg := &config.Config{
+		APIGateway:    config.APIGatewaySettings{URL: server.URL + "/v1", Token: "secret"},
+		AgentSettings: config.AgentSettings{NodeIdentifier: "node-1", StateDir: t.TempDir()},
+	}
+
+	if update, err := Fetch(cfg); update != nil || err != nil {
+		t.Errorf("nothing published: Fetch = %v, %v", update, err)
+	}
+
+	gw.document = "revision: 1\nprojects: []\n"
+	if _, err := Fetch(cfg); err == nil {
+		t.Error("unsigned list was accepted")
+	}
+
+	gw.signature = "c2lnbmF0dXJl"
+	update, err := Fetch(cfg)
+	if err != nil || update == nil || string(update.Data) != gw.document || string(update.Signature) != gw.signature {
+		t.Fatalf("Fetch = %+v, %v", update, err)
+	}
+	if err := cfg.SaveRemoteCache(update.Data, update.Signature); err != nil {
+		t.Fatal(err)
+	}
+	if update, err := Fetch(cfg); update != nil || err != nil {
+		t.Errorf("cached list: Fetch = %v, %v", update, err)
+	}
+
+	if err := Report(cfg, errors.New("signature does not match")); err != nil {
+		t.Fatal(err)
+	}
+	if len(gw.statuses) != 1 || gw.statuses[0].NodeHostname != "node-1" || gw.statuses[0].Error != "signature does not match" {
+		t.Errorf("statuses = %+v", gw.statuses)
+	}
+
+	cfg.APIGateway.Token = "wrong"
+	if _, err := Fetch(cfg); err == nil {
+		t.Error("Fetch ignored a 401")
+	}
+}
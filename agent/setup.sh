@@ -19,10 +19,10 @@ apt-get install -y git
 
 # Install Go
 echo "Installing Go..."
-wget https://go.dev/dl/go1.21.10.linux-amd64.tar.gz
+wget https://go.dev/dl/go1.22.12.linux-amd64.tar.gz
 rm -rf /usr/local/go
-tar -C /usr/local -xzf go1.21.10.linux-amd64.tar.gz
-rm go1.21.10.linux-amd64.tar.gz
+tar -C /usr/local -xzf go1.22.12.linux-amd64.tar.gz
+rm go1.22.12.linux-amd64.tar.gz
 
 # Verify Go installation
 echo "Verifying Go installation..."
@@ -127,7 +127,7 @@ echo "Updating go.mod..."
 cat > go.mod << 'EOL'
 module vps-agent
 
-go 1.21
+go 1.22
 
 require (
 	github.com/elastic/go-sysinfo v1.11.1
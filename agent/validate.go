@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"vps-screener/agent/config"
+)
+
+// runValidate implements `agent validate [-c config.yaml]`: it loads the
+// configuration like the agent does at startup and reload, and also checks
+// the plugins. It exits 1 if there is any problem, so it can gate a deploy.
+func runValidate(args []string) int {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	configPath := fs.String("c", defaultConfigPath(), "Path to the agent configuration")
+	noPlugins := fs.Bool("no-plugins", false, "Do not check that plugin files exist and are executable")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s validate [-c config.yaml] [-no-plugins]\n\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "Checks the configuration: unknown keys, patterns, match rules, duplicate names and\n")
+		fmt.Fprintf(fs.Output(), "plugin files (relative to the working directory, like the agent). Exits 1 on problems.\n\n")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 0 {
+		fs.Usage()
+		return 2
+	}
+
+	log.SetFlags(0) // Deprecation warnings are part of the report
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if !*noPlugins {
+		if problems := cfg.CheckPlugins(); len(problems) > 0 {
+			fmt.Fprintf(os.Stderr, "invalid config file %s:\n", *configPath)
+			for _, problem := range problems {
+				fmt.Fprintln(os.Stderr, problem)
+			}
+			return 1
+		}
+	}
+	fmt.Printf("%s: OK, %d projects, %d groups, %d discovery rules\n", *configPath, len(cfg.Projects), len(cfg.Groups), len(cfg.Discovery))
+	return 0
+}
+
+// logPluginProblems logs plugins that cannot run. They are not fatal: the
+// plugin may be deployed after the configuration, and is retried every tick.
+func logPluginProblems(cfg *config.Config) {
+	for _, problem := range cfg.CheckPlugins() {
+		log.Printf("Warning: %v", problem)
+	}
+}
@@ -1,5 +1,6 @@
-import { Controller, Get, Post, Body, Query, HttpCode, Logger } from '@nestjs/common';
-import { AppService } from './app.service';
+import { Controller, Get, Post, Put, Body, Query, Res, HttpCode, Logger, BadRequestException, NotFoundException } from '@nestjs/common';
+import { FastifyReply } from 'fastify';
+import { AppService, LogBatch, PublishedConfig, ConfigStatus } from './app.service';
 
 // Define a simple interface for the structure of metrics we expect from agent
 // This should ideally be in a shared types file if agent and gateway are in a monorepo
@@ -40,6 +41,23 @@ export class AppController {
     return { message: 'Metrics received and stored successfully' }; // MODIFIED: More specific message
   }
 
+  // Endpoint to receive shipped log batches from agents
+  @Post('v1/logs')
+  @HttpCode(200)
+  receiveLogs(@Body() batch: LogBatch) {
+    if (!this.appService.storeLogs(batch)) {
+      // 400 tells the agent to drop the batch instead of retrying it forever
+      throw new BadRequestException('Invalid log batch');
+    }
+    return { message: 'Logs received and stored successfully' };
+  }
+
+  // Endpoint for the dashboard to fetch recent logs of a node
+  @Get('v1/logs')
+  getLogs(@Query('node') nodeId: string, @Query('project') project?: string) {
+    return this.appService.getLogs(nodeId, project);
+  }
+
   // Endpoint for agents to fetch tasks
   @Get('v1/tasks')
   getTasks(@Query('node') nodeId: string) {
@@ -49,6 +67,45 @@ export class AppController {
     return []; 
   }
 
+  // Endpoint for agents with remote_config to fetch their signed project list
+  @Get('v1/config')
+  getConfig(@Query('node') nodeId: string, @Res({ passthrough: true }) reply: FastifyReply) {
+    const published = this.appService.getConfig(nodeId);
+    if (!published) {
+      // 404 makes the agent keep its cached list
+      throw new NotFoundException(`No project list published for node ${nodeId}`);
+    }
+    // The signature covers the document byte for byte, so it is sent as is
+    reply.header('Content-Type', 'application/yaml');
+    reply.header('X-Signature', published.signature);
+    return published.document;
+  }
+
+  // Endpoint to publish a signed project list, for one node or as the default
+  @Put('v1/config')
+  publishConfig(@Body() config: PublishedConfig) {
+    if (!this.appService.publishConfig(config)) {
+      throw new BadRequestException('Invalid project list: document and signature are required');
+    }
+    return { message: 'Project list published' };
+  }
+
+  // Endpoint for agents to report the project list revision they applied
+  @Post('v1/config/status')
+  @HttpCode(200)
+  receiveConfigStatus(@Body() status: ConfigStatus) {
+    if (!this.appService.storeConfigStatus(status)) {
+      throw new BadRequestException('Invalid config status');
+    }
+    return { message: 'Config status received' };
+  }
+
+  // Endpoint for the dashboard to see which revision every node runs
+  @Get('v1/config/status')
+  getConfigStatuses() {
+    return this.appService.getConfigStatuses();
+  }
+
   // New endpoint for the dashboard to fetch all node statuses
   @Get('v1/status')
   getNodeStatuses() {
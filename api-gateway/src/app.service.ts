@@ -28,10 +28,50 @@ export interface StoredNodeInfo {
   last_agent_timestamp: Date; // Timestamp from the agent's data
 }
 
+// A single log record shipped by an agent
+export interface LogRecord {
+  ts: number; // Unix milliseconds
+  project: string;
+  source: string; // "file:<path>" or "journal:<unit>"
+  priority?: number;
+  message: string;
+}
+
+export interface LogBatch {
+  node_hostname: string;
+  timestamp: number;
+  records: LogRecord[];
+}
+
+// A project list for agents with remote_config. It is signed with the key
+// pinned in the agents' configuration; the gateway only stores and serves it.
+export interface PublishedConfig {
+  node?: string; // Omitted: the list for nodes without one of their own
+  document: string; // YAML with revision and projects, exactly as signed
+  signature: string; // Base64 Ed25519 signature from `agent config sign`
+}
+
+// The project list revision an agent applied, or why it rejected the latest one
+export interface ConfigStatus {
+  node_hostname: string;
+  timestamp: number;
+  revision: string; // "" if the agent has no remote project list yet
+  error?: string;
+}
+
+// Key of the list served to nodes without one of their own
+const DEFAULT_CONFIG = '*';
+
+// Keep only the most recent records per node until a database is wired in
+const MAX_LOG_RECORDS_PER_NODE = 5000;
+
 @Injectable()
 export class AppService {
   private readonly logger = new Logger(AppService.name);
   private latestMetrics: Map<string, StoredNodeInfo> = new Map();
+  private recentLogs: Map<string, LogRecord[]> = new Map();
+  private publishedConfigs: Map<string, PublishedConfig> = new Map();
+  private configStatuses: Map<string, ConfigStatus & { received: Date }> = new Map();
 
   getHello(): string {
     return 'Hello from API Gateway!';
@@ -60,4 +100,63 @@ export class AppService {
   getNodeStatuses(): StoredNodeInfo[] {
     return Array.from(this.latestMetrics.values());
   }
-} 
\ No newline at end of file
+
+  // Method to store a batch of log records from an agent
+  storeLogs(batch: LogBatch): boolean {
+    if (!batch || !batch.node_hostname || !Array.isArray(batch.records)) {
+      this.logger.warn('Received invalid log batch structure');
+      return false;
+    }
+
+    const logs = this.recentLogs.get(batch.node_hostname) ?? [];
+    logs.push(...batch.records);
+    if (logs.length > MAX_LOG_RECORDS_PER_NODE) {
+      logs.splice(0, logs.length - MAX_LOG_RECORDS_PER_NODE);
+    }
+    this.recentLogs.set(batch.node_hostname, logs);
+
+    this.logger.log(`Stored ${batch.records.length} log records for node: ${batch.node_hostname}`);
+    return true;
+  }
+
+  // Method to get recent log records of a node, optionally for one project
+  getLogs(nodeId: string, project?: string): LogRecord[] {
+    const logs = this.recentLogs.get(nodeId) ?? [];
+    return project ? logs.filter((r) => r.project === project) : logs;
+  }
+
+  // Method to store a signed project list for a node, or the default one
+  publishConfig(config: PublishedConfig): boolean {
+    if (!config || typeof config.document !== 'string' || !config.signature) {
+      this.logger.warn('Received invalid project list');
+      return false;
+    }
+    const key = config.node || DEFAULT_CONFIG;
+    this.publishedConfigs.set(key, config);
+    this.logger.log(`Project list published for ${config.node ? `node: ${config.node}` : 'all nodes'}`);
+    return true;
+  }
+
+  // Method to get the project list a node should run
+  getConfig(nodeId: string): PublishedConfig | undefined {
+    return this.publishedConfigs.get(nodeId) ?? this.publishedConfigs.get(DEFAULT_CONFIG);
+  }
+
+  // Method to store the project list revision reported by an agent
+  storeConfigStatus(status: ConfigStatus): boolean {
+    if (!status || !status.node_hostname || typeof status.revision !== 'string') {
+      this.logger.warn('Received invalid config status');
+      return false;
+    }
+    this.configStatuses.set(status.node_hostname, { ...status, received: new Date() });
+    if (status.error) {
+      this.logger.warn(`Node ${status.node_hostname} rejected its project list: ${status.error}`);
+    }
+    return true;
+  }
+
+  // Method to get the reported project list revision of every node
+  getConfigStatuses(): (ConfigStatus & { received: Date })[] {
+    return Array.from(this.configStatuses.values());
+  }
+}